@@ -0,0 +1,394 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package nfdump
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"slices"
+)
+
+// RecordBuilder constructs flow records from typed values. Set the fields
+// the record should carry and call Build for the record layout of the file
+// to write: RecordFormatV3 for V2 files or RecordFormatV4 for V3 files. Only
+// the extensions of fields that were set are added. The zero value is an
+// empty builder ready to use; a builder can build any number of records.
+type RecordBuilder struct {
+	fields recordFields
+
+	generic        *GenericFlow
+	src, dst       netip.Addr
+	flowMisc       *FlowMisc
+	counters       *Counters
+	vlan           *VLAN
+	as             *AS
+	ipInfo         *IPInfo
+	natSrc, natDst netip.Addr
+	natPorts       *[2]uint16
+	natEvent       *NATEvent
+	natPortBlock   *NATPortBlock
+}
+
+// Reset clears all fields of the builder.
+func (builder *RecordBuilder) Reset() *RecordBuilder {
+	*builder = RecordBuilder{}
+	return builder
+}
+
+// SetExporter sets the nfdump exporter identifier. V3 records hold 16 bits.
+func (builder *RecordBuilder) SetExporter(exporterID uint32) *RecordBuilder {
+	builder.fields.exporterID = exporterID
+	return builder
+}
+
+// SetEngine sets the engine type and engine ID of the exporter.
+func (builder *RecordBuilder) SetEngine(engineType, engineID uint8) *RecordBuilder {
+	builder.fields.engineType, builder.fields.engineID = engineType, engineID
+	return builder
+}
+
+// SetNetFlowVersion sets the exporting protocol version.
+func (builder *RecordBuilder) SetNetFlowVersion(version uint8) *RecordBuilder {
+	builder.fields.nfVersion = version
+	return builder
+}
+
+// SetFlags sets the record flags. V3 records hold 8 bits.
+func (builder *RecordBuilder) SetFlags(flags uint16) *RecordBuilder {
+	builder.fields.flags = flags
+	return builder
+}
+
+// SetGeneric sets the generic flow extension.
+func (builder *RecordBuilder) SetGeneric(generic GenericFlow) *RecordBuilder {
+	builder.generic = &generic
+	return builder
+}
+
+// SetAddrs sets the source and destination address. Both must be of the
+// same family; the family selects the IPv4 or IPv6 flow extension.
+func (builder *RecordBuilder) SetAddrs(src, dst netip.Addr) *RecordBuilder {
+	builder.src, builder.dst = src, dst
+	return builder
+}
+
+// SetFlowMisc sets the interfaces, masks and miscellaneous flow fields.
+func (builder *RecordBuilder) SetFlowMisc(flowMisc FlowMisc) *RecordBuilder {
+	builder.flowMisc = &flowMisc
+	return builder
+}
+
+// SetCounters sets the flow count and the output counters.
+func (builder *RecordBuilder) SetCounters(counters Counters) *RecordBuilder {
+	builder.counters = &counters
+	return builder
+}
+
+// SetVLAN sets the source and destination VLAN IDs.
+func (builder *RecordBuilder) SetVLAN(vlan VLAN) *RecordBuilder {
+	builder.vlan = &vlan
+	return builder
+}
+
+// SetAS sets the source and destination AS numbers.
+func (builder *RecordBuilder) SetAS(as AS) *RecordBuilder {
+	builder.as = &as
+	return builder
+}
+
+// SetIPInfo sets the fragment flags and the TTL range.
+func (builder *RecordBuilder) SetIPInfo(ipInfo IPInfo) *RecordBuilder {
+	builder.ipInfo = &ipInfo
+	return builder
+}
+
+// SetNATAddrs sets the translated source and destination address. Both must
+// be of the same family.
+func (builder *RecordBuilder) SetNATAddrs(src, dst netip.Addr) *RecordBuilder {
+	builder.natSrc, builder.natDst = src, dst
+	return builder
+}
+
+// SetNATPorts sets the translated source and destination port.
+func (builder *RecordBuilder) SetNATPorts(src, dst uint16) *RecordBuilder {
+	builder.natPorts = &[2]uint16{src, dst}
+	return builder
+}
+
+// SetNATEvent sets the NAT event, pool and event time.
+func (builder *RecordBuilder) SetNATEvent(event NATEvent) *RecordBuilder {
+	builder.natEvent = &event
+	return builder
+}
+
+// SetNATPortBlock sets the allocated NAT port block.
+func (builder *RecordBuilder) SetNATPortBlock(portBlock NATPortBlock) *RecordBuilder {
+	builder.natPortBlock = &portBlock
+	return builder
+}
+
+// builderIDs holds the extension IDs of the fields whose data is the same in
+// V3 and V4 records: V3 element IDs or V4 bitmap positions.
+type builderIDs struct {
+	generic, ipv4, ipv6, counters, vlan, as   uint16
+	natIPv4, natIPv6, natCommon, natPortBlock uint16
+}
+
+var (
+	v3BuilderIDs = builderIDs{
+		generic: EXgenericFlowID, ipv4: EXipv4FlowID, ipv6: EXipv6FlowID,
+		counters: EXcntFlowID, vlan: EXvLanID, as: EXasRoutingID,
+		natIPv4: EXnatXlateIPv4ID, natIPv6: EXnatXlateIPv6ID,
+		natCommon: EXnatCommonID, natPortBlock: EXnatPortBlockID,
+	}
+	v4BuilderIDs = builderIDs{
+		generic: v4ExGenericFlow, ipv4: v4ExIPv4Flow, ipv6: v4ExIPv6Flow,
+		counters: v4ExCntFlow, vlan: v4ExVLAN, as: v4ExASInfo,
+		natIPv4: v4ExNATXlateIPv4, natIPv6: v4ExNATXlateIPv6,
+		natCommon: v4ExNATCommon, natPortBlock: v4ExNATPortBlock,
+	}
+)
+
+// recordFields holds the header fields shared by V3 and V4 records.
+type recordFields struct {
+	exporterID uint32
+	flags      uint16
+	engineType uint8
+	engineID   uint8
+	nfVersion  uint8
+}
+
+// recordExtension is an encoded extension: a V3 element ID or a V4 bitmap
+// position and its data.
+type recordExtension struct {
+	id   uint16
+	data []byte
+}
+
+// Build returns a new record of format holding the fields set so far. It
+// fails if a value does not fit the layout, such as an exporter ID above
+// 65535 in a V3 record, or if an address pair mixes IPv4 and IPv6.
+func (builder *RecordBuilder) Build(format RecordFormat) (FlowRecord, error) {
+	var (
+		record FlowRecord
+		err    error
+	)
+	switch format {
+	case RecordFormatV3:
+		record, err = builder.buildV3()
+	case RecordFormatV4:
+		record, err = builder.buildV4()
+	default:
+		err = fmt.Errorf("unknown record format %d", format)
+	}
+	if err != nil {
+		return FlowRecord{}, fmt.Errorf("recordBuilder build: %w", err)
+	}
+	return record, nil
+}
+
+func (builder *RecordBuilder) buildV3() (FlowRecord, error) {
+	if builder.fields.exporterID > 0xffff {
+		return FlowRecord{}, fmt.Errorf("exporter ID %d exceeds V3 record", builder.fields.exporterID)
+	}
+	if builder.fields.flags > 0xff {
+		return FlowRecord{}, fmt.Errorf("flags 0x%x exceed V3 record", builder.fields.flags)
+	}
+	var extensions []recordExtension
+	add := func(id uint16, data []byte) {
+		extensions = append(extensions, recordExtension{id: id, data: data})
+	}
+	if err := builder.addCommon(add, v3BuilderIDs); err != nil {
+		return FlowRecord{}, err
+	}
+	if misc := builder.flowMisc; misc != nil {
+		data := binary.LittleEndian.AppendUint32(nil, misc.Input)
+		data = binary.LittleEndian.AppendUint32(data, misc.Output)
+		data = append(data, misc.SrcMask, misc.DstMask, misc.Dir, misc.DstTos, misc.BiFlowDir, misc.FlowEndReason, 0, 0)
+		add(EXflowMiscID, data)
+	}
+	if ports := builder.natPorts; ports != nil {
+		add(EXnatXlatePortID, binary.LittleEndian.AppendUint16(binary.LittleEndian.AppendUint16(nil, ports[0]), ports[1]))
+	}
+	if info := builder.ipInfo; info != nil {
+		add(EXipInfoID, []byte{0, info.FragmentFlags, info.MinTTL, info.MaxTTL})
+	}
+	sortExtensions(extensions)
+	raw, err := appendV3Record(nil, builder.fields, extensions)
+	if err != nil {
+		return FlowRecord{}, err
+	}
+	return newFlowRecordV3(raw)
+}
+
+func (builder *RecordBuilder) buildV4() (FlowRecord, error) {
+	var extensions []recordExtension
+	add := func(id uint16, data []byte) {
+		extensions = append(extensions, recordExtension{id: id, data: data})
+	}
+	if err := builder.addCommon(add, v4BuilderIDs); err != nil {
+		return FlowRecord{}, err
+	}
+	if misc := builder.flowMisc; misc != nil {
+		// V4 keeps the interfaces in EXinterface.
+		add(v4ExInterface, binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, misc.Input), misc.Output))
+		add(v4ExFlowMisc, []byte{misc.SrcMask, misc.DstMask, misc.Dir, misc.DstTos, misc.BiFlowDir, misc.FlowEndReason, 0, 0})
+	}
+	if ports := builder.natPorts; ports != nil {
+		data := binary.LittleEndian.AppendUint16(binary.LittleEndian.AppendUint16(nil, ports[0]), ports[1])
+		add(v4ExNATXlatePort, append(data, 0, 0, 0, 0))
+	}
+	if info := builder.ipInfo; info != nil {
+		add(v4ExIPInfo, []byte{0, info.FragmentFlags, info.MinTTL, info.MaxTTL, 0, 0, 0, 0})
+	}
+	sortExtensions(extensions)
+	raw, err := appendV4Record(nil, builder.fields, extensions)
+	if err != nil {
+		return FlowRecord{}, err
+	}
+	return newFlowRecordV4(raw)
+}
+
+func sortExtensions(extensions []recordExtension) {
+	slices.SortFunc(extensions, func(a, b recordExtension) int { return cmp.Compare(a.id, b.id) })
+}
+
+// appendV3Record appends a V3 record with the header fields and the
+// elements in the given order to dst.
+func appendV3Record(dst []byte, fields recordFields, elements []recordExtension) ([]byte, error) {
+	start := len(dst)
+	dst = append(dst, make([]byte, v3RecordHeaderSize)...)
+	header := dst[start:]
+	binary.LittleEndian.PutUint16(header[0:2], V3Record)
+	binary.LittleEndian.PutUint16(header[4:6], uint16(len(elements)))
+	header[6], header[7] = fields.engineType, fields.engineID
+	binary.LittleEndian.PutUint16(header[8:10], uint16(fields.exporterID))
+	header[10], header[11] = uint8(fields.flags), fields.nfVersion
+	for _, element := range elements {
+		if 4+len(element.data) > 0xffff {
+			return dst[:start], fmt.Errorf("extension %d exceeds maximum element size", element.id)
+		}
+		dst = binary.LittleEndian.AppendUint16(dst, element.id)
+		dst = binary.LittleEndian.AppendUint16(dst, uint16(4+len(element.data)))
+		dst = append(dst, element.data...)
+	}
+	if len(dst)-start > 0xffff {
+		return dst[:start], fmt.Errorf("V3 record exceeds maximum record size")
+	}
+	binary.LittleEndian.PutUint16(dst[start+2:start+4], uint16(len(dst)-start))
+	return dst, nil
+}
+
+// appendV4Record appends a V4 record with the header fields and the
+// extensions to dst. The extensions must be sorted by bitmap position; each
+// is padded to a multiple of eight bytes.
+func appendV4Record(dst []byte, fields recordFields, extensions []recordExtension) ([]byte, error) {
+	var bitmap uint64
+	for _, extension := range extensions {
+		bitmap |= 1 << extension.id
+	}
+	offset := v4OffsetTableSize(bitmap)
+	size := offset
+	for _, extension := range extensions {
+		size += (len(extension.data) + 7) &^ 7
+	}
+	if size > 0xffff {
+		return dst, fmt.Errorf("V4 record exceeds maximum record size")
+	}
+	start := len(dst)
+	dst = append(dst, make([]byte, size)...)
+	raw := dst[start:]
+	binary.LittleEndian.PutUint16(raw[0:2], v4RecordType)
+	binary.LittleEndian.PutUint16(raw[2:4], uint16(size))
+	binary.LittleEndian.PutUint16(raw[4:6], uint16(len(extensions)))
+	binary.LittleEndian.PutUint16(raw[6:8], fields.flags)
+	binary.LittleEndian.PutUint32(raw[8:12], fields.exporterID)
+	raw[12], raw[13], raw[14] = fields.engineType, fields.engineID, fields.nfVersion
+	binary.LittleEndian.PutUint64(raw[16:24], bitmap)
+	for rank, extension := range extensions {
+		binary.LittleEndian.PutUint16(raw[v4RecordHeaderSize+rank*2:], uint16(offset))
+		copy(raw[offset:], extension.data)
+		offset += (len(extension.data) + 7) &^ 7
+	}
+	return dst, nil
+}
+
+// addCommon encodes the extensions whose data is the same in V3 and V4
+// records, using the IDs of the target layout.
+func (builder *RecordBuilder) addCommon(add func(uint16, []byte), ids builderIDs) error {
+	if generic := builder.generic; generic != nil {
+		data := binary.LittleEndian.AppendUint64(nil, generic.MsecFirst)
+		data = binary.LittleEndian.AppendUint64(data, generic.MsecLast)
+		data = binary.LittleEndian.AppendUint64(data, generic.MsecReceived)
+		data = binary.LittleEndian.AppendUint64(data, generic.InPackets)
+		data = binary.LittleEndian.AppendUint64(data, generic.InBytes)
+		data = binary.LittleEndian.AppendUint16(data, generic.SrcPort)
+		data = binary.LittleEndian.AppendUint16(data, generic.DstPort)
+		add(ids.generic, append(data, generic.Proto, generic.TcpFlags, generic.FwdStatus, generic.SrcTos))
+	}
+	if err := addAddrPair(add, "address", builder.src, builder.dst, ids.ipv4, ids.ipv6); err != nil {
+		return err
+	}
+	if counters := builder.counters; counters != nil {
+		data := binary.LittleEndian.AppendUint64(nil, counters.Flows)
+		data = binary.LittleEndian.AppendUint64(data, counters.OutPackets)
+		add(ids.counters, binary.LittleEndian.AppendUint64(data, counters.OutBytes))
+	}
+	if vlan := builder.vlan; vlan != nil {
+		add(ids.vlan, binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, vlan.Src), vlan.Dst))
+	}
+	if as := builder.as; as != nil {
+		add(ids.as, binary.LittleEndian.AppendUint32(binary.LittleEndian.AppendUint32(nil, as.Src), as.Dst))
+	}
+	if err := addAddrPair(add, "NAT address", builder.natSrc, builder.natDst, ids.natIPv4, ids.natIPv6); err != nil {
+		return err
+	}
+	if event := builder.natEvent; event != nil {
+		data := binary.LittleEndian.AppendUint64(nil, event.MsecEvent)
+		data = binary.LittleEndian.AppendUint32(data, event.PoolID)
+		add(ids.natCommon, append(data, uint8(event.Event), 0, 0, 0))
+	}
+	if block := builder.natPortBlock; block != nil {
+		data := binary.LittleEndian.AppendUint16(nil, block.Start)
+		data = binary.LittleEndian.AppendUint16(data, block.End)
+		data = binary.LittleEndian.AppendUint16(data, block.Step)
+		add(ids.natPortBlock, binary.LittleEndian.AppendUint16(data, block.Size))
+	}
+	return nil
+}
+
+// addAddrPair encodes an address pair as an IPv4 or IPv6 extension. Unset
+// pairs add nothing.
+func addAddrPair(add func(uint16, []byte), name string, src, dst netip.Addr, ipv4ID, ipv6ID uint16) error {
+	switch {
+	case !src.IsValid() && !dst.IsValid():
+		return nil
+	case src.Is4() && dst.Is4():
+		add(ipv4ID, appendIPv4(appendIPv4(nil, src), dst))
+	case src.Is6() && dst.Is6():
+		add(ipv6ID, appendIPv6(appendIPv6(nil, src), dst))
+	default:
+		return fmt.Errorf("%s pair %v, %v mixes address families", name, src, dst)
+	}
+	return nil
+}
+
+// appendIPv4 appends addr as the 32-bit integer nfdump stores.
+func appendIPv4(data []byte, addr netip.Addr) []byte {
+	ip := addr.As4()
+	return binary.LittleEndian.AppendUint32(data, binary.BigEndian.Uint32(ip[:]))
+}
+
+// appendIPv6 appends addr as the two 64-bit integers nfdump stores, the
+// inverse of v3IPv6.
+func appendIPv6(data []byte, addr netip.Addr) []byte {
+	ip := addr.As16()
+	data = binary.LittleEndian.AppendUint64(data, binary.BigEndian.Uint64(ip[0:8]))
+	return binary.LittleEndian.AppendUint64(data, binary.BigEndian.Uint64(ip[8:16]))
+}
@@ -0,0 +1,352 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+// nfdump writes its files in the byte order of the collecting host and
+// stores the magic 0xA50C to tell them apart. Files from big-endian hosts
+// are read by decoding their container structures in big-endian order and
+// by converting every record to little-endian order in place after its
+// block has been decoded. The record accessors, the legacy FlowRecordV3 API
+// and the metadata parsers therefore only ever see little-endian records.
+
+package nfdump
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	nfFileMagic        = 0xA50C
+	nfFileMagicSwapped = 0x0CA5
+)
+
+// fileByteOrder returns the byte order of a file from its first two bytes.
+// ok is false if they do not hold the nfdump magic in either order.
+func fileByteOrder(magic []byte) (order binary.ByteOrder, ok bool) {
+	switch binary.LittleEndian.Uint16(magic) {
+	case nfFileMagic:
+		return binary.LittleEndian, true
+	case nfFileMagicSwapped:
+		return binary.BigEndian, true
+	default:
+		return nil, false
+	}
+}
+
+// swapLayout lists the widths of consecutive integer fields in bytes. Bytes
+// beyond the listed fields, such as strings, addresses stored as byte arrays
+// and padding, are left untouched.
+type swapLayout []uint8
+
+// swap reverses the bytes of every field of layout in data. Fields that do
+// not fit into data are left alone.
+func (layout swapLayout) swap(data []byte) {
+	offset := 0
+	for _, width := range layout {
+		if offset+int(width) > len(data) {
+			return
+		}
+		field := data[offset : offset+int(width)]
+		switch width {
+		case 2:
+			binary.LittleEndian.PutUint16(field, bits.ReverseBytes16(binary.LittleEndian.Uint16(field)))
+		case 4:
+			binary.LittleEndian.PutUint32(field, bits.ReverseBytes32(binary.LittleEndian.Uint32(field)))
+		case 8:
+			binary.LittleEndian.PutUint64(field, bits.ReverseBytes64(binary.LittleEndian.Uint64(field)))
+		}
+		offset += int(width)
+	}
+}
+
+// repeatLayout returns n fields of width bytes.
+func repeatLayout(width uint8, n int) swapLayout {
+	layout := make(swapLayout, n)
+	for i := range layout {
+		layout[i] = width
+	}
+	return layout
+}
+
+// v3SwapLayouts holds the field layout of the V3 extensions, indexed by
+// extension ID. Extensions without a layout, such as payloads, user names
+// and labels, are byte strings.
+var v3SwapLayouts = [MAXEXTENSIONS]swapLayout{
+	EXgenericFlowID:  {8, 8, 8, 8, 8, 2, 2},
+	EXipv4FlowID:     {4, 4},
+	EXipv6FlowID:     {8, 8, 8, 8},
+	EXflowMiscID:     {4, 4},
+	EXcntFlowID:      {8, 8, 8},
+	EXvLanID:         {4, 4},
+	EXasRoutingID:    {4, 4},
+	EXbgpNextHopV4ID: {4},
+	EXbgpNextHopV6ID: {8, 8},
+	EXipNextHopV4ID:  {4},
+	EXipNextHopV6ID:  {8, 8},
+	EXipReceivedV4ID: {4},
+	EXipReceivedV6ID: {8, 8},
+	EXmplsLabelID:    repeatLayout(4, 10),
+	EXmacAddrID:      {8, 8, 8, 8},
+	EXasAdjacentID:   {4, 4},
+	EXlatencyID:      {8, 8, 8},
+	EXsamplerInfoID:  {8, 2, 2},
+	EXnselCommonID:   {8, 4, 2},
+	EXnatXlateIPv4ID: {4, 4},
+	EXnatXlateIPv6ID: {8, 8, 8, 8},
+	EXnatXlatePortID: {2, 2},
+	EXnselAclID:      repeatLayout(4, 6),
+	EXnatCommonID:    {8, 4, 1, 1, 2},
+	EXnatPortBlockID: {2, 2, 2, 2},
+	EXvrfID:          {4, 4},
+	EXlayer2ID:       {2, 2, 2, 2, 4, 4, 8, 2},
+	EXflowIdID:       {8},
+	EXnokiaNatID:     {2, 2},
+}
+
+// v4SwapLayouts holds the field layout of the V4 extensions, indexed by
+// their bitmap position. Variable-length extensions start with a 32-bit
+// length. Extensions without a layout are delivered as stored.
+var v4SwapLayouts = [40]swapLayout{
+	1:  {8, 8, 8, 8, 8, 2, 2},    // EXgenericFlow
+	2:  {4, 4},                   // EXipv4Flow
+	3:  {8, 8, 8, 8},             // EXipv6Flow
+	4:  {4, 4},                   // EXinterface
+	6:  {8, 8, 8},                // EXcntFlow
+	7:  {4, 4},                   // EXvLan
+	8:  {4, 4},                   // EXasInfo
+	9:  {4, 4},                   // EXasAdjacent
+	10: {8, 8, 8, 8},             // EXmacAddr
+	11: {4},                      // EXbgpNextHopV4
+	12: {8, 8},                   // EXbgpNextHopV6
+	13: repeatLayout(4, 10),      // EXmplsLabel
+	14: {8, 8},                   // EXipNextHopV6
+	15: {8, 8},                   // EXipReceivedV6
+	16: {4},                      // EXipNextHopV4
+	17: {8, 8, 8},                // EXlatency
+	18: {4, 4},                   // EXnatXlateIPv4
+	19: {8, 8, 8, 8},             // EXnatXlateIPv6
+	20: {2, 2},                   // EXnatXlatePort
+	21: repeatLayout(4, 6),       // EXnselAcl
+	24: {4},                      // EXipReceivedV4
+	25: {4},                      // EXnbarApp
+	26: {4},                      // EXinPayload
+	27: {4},                      // EXoutPayload
+	28: {8, 4},                   // EXnatCommon
+	30: {8, 4, 2},                // EXnselCommon
+	31: {2, 2, 2, 2},             // EXnatPortBlock
+	32: {4},                      // EXnokiaNatString
+	33: {4, 4},                   // EXvrf
+	35: {2, 2, 2, 2, 4, 4, 8, 2}, // EXlayer2
+	36: {8},                      // EXflowId
+	38: {2, 2},                   // EXnokiaNat
+}
+
+// Field layouts of the record headers and metadata records.
+var (
+	recordHeaderLayout     = swapLayout{2, 2}
+	v3RecordHeaderLayout   = swapLayout{2, 2, 2, 1, 1, 2}
+	v4RecordHeaderLayout   = swapLayout{2, 2, 2, 2, 4, 1, 1, 1, 1, 8}
+	exporterInfoLayout     = swapLayout{2, 2, 4, 8, 8, 2, 2, 4}
+	exporterStatLayout     = swapLayout{2, 2, 4}
+	exporterStatEntry      = swapLayout{4, 4, 8, 8}
+	samplerLayout          = swapLayout{2, 2, 2, 2, 8, 4, 4}
+	samplerLegacyLayout    = swapLayout{2, 2, 4, 4, 2}
+	statLayout             = append(swapLayout{2, 2}, repeatLayout(8, 18)...)
+	v1ExtensionMapLayout   = swapLayout{2, 2, 2, 2}
+	v1CommonRecordLayout   = swapLayout{2, 2, 2, 2, 2, 2, 4, 4, 1, 1, 1, 1, 2, 2, 2}
+	v18BlockHeaderLayout   = swapLayout{4, 4, 4, 2, 2, 8}
+	v18FlowBlockHeadLayout = swapLayout{4, 4, 8, 8, 8}
+	v18MetaBlockHeadLayout = swapLayout{4, 4}
+)
+
+// v1SwapLayout returns the field layout of a V1 extension.
+func v1SwapLayout(id uint16) swapLayout {
+	switch id {
+	case v1ExIOSNMP2, v1ExAS2, v1ExVLAN, v1ExNSELXlatePorts:
+		return swapLayout{2, 2}
+	case v1ExIOSNMP4, v1ExAS4, v1ExBGPAdj, v1ExNSELXlateIPV4:
+		return swapLayout{4, 4}
+	case v1ExNextHopV4, v1ExNextHopBGPV4, v1ExOutPkg4, v1ExOutBytes4, v1ExAggrFlows4, v1ExRouterIPV4:
+		return swapLayout{4}
+	case v1ExOutPkg8, v1ExOutBytes8, v1ExAggrFlows8, v1ExReceived:
+		return swapLayout{8}
+	case v1ExNextHopV6, v1ExNextHopBGPV6, v1ExMAC1, v1ExMAC2, v1ExRouterIPV6:
+		return swapLayout{8, 8}
+	case v1ExRouterID:
+		return swapLayout{2}
+	case v1ExNSELCommon:
+		return swapLayout{8, 4, 1, 1, 2, 4}
+	case v1ExNSELXlateIPV6:
+		return swapLayout{8, 8, 8, 8}
+	case v1ExNSELACL:
+		return repeatLayout(4, 6)
+	case v1ExLatency:
+		return swapLayout{8, 8, 8}
+	case v1ExMPLS:
+		return repeatLayout(4, 10)
+	case v1ExNELCommon:
+		return swapLayout{1, 1, 2, 4, 4}
+	case v1ExPortBlockAlloc:
+		return swapLayout{2, 2, 2, 2}
+	default:
+		return nil
+	}
+}
+
+// swapRecords converts the numRecords records at the start of data to
+// little-endian order. A record with an invalid size ends the conversion,
+// leaving the error to the parser. V1 records depend on the extension maps
+// seen before them and are converted one by one by processDataBlock instead.
+func swapRecords(data []byte, numRecords uint32) {
+	offset := 0
+	for i := 0; i < int(numRecords) && len(data)-offset >= 4; i++ {
+		recordType, recordSize := swapRecordHeader(data[offset:])
+		if recordSize < 4 || recordSize > len(data)-offset {
+			return
+		}
+		swapRecordBody(recordType, data[offset:offset+recordSize], nil)
+		offset += recordSize
+	}
+}
+
+// swapRecordHeader converts the type and size at the start of a record and
+// returns them.
+func swapRecordHeader(record []byte) (uint16, int) {
+	recordHeaderLayout.swap(record)
+	return binary.LittleEndian.Uint16(record[0:2]), int(binary.LittleEndian.Uint16(record[2:4]))
+}
+
+// swapRecordBody converts a record whose header swapRecordHeader has already
+// converted. Unknown record types are left as stored.
+func swapRecordBody(recordType uint16, record []byte, maps v1ExtensionMaps) {
+	switch recordType {
+	case V3Record:
+		swapV3Record(record)
+	case v4RecordType:
+		swapV4Record(record)
+	case ExporterInfoRecordType:
+		exporterInfoLayout[2:].swap(record[4:])
+	case ExporterStatRecordType:
+		exporterStatLayout[2:].swap(record[4:])
+		for offset := 8; offset+24 <= len(record); offset += 24 {
+			exporterStatEntry.swap(record[offset:])
+		}
+	case SamplerRecordType:
+		samplerLayout[2:].swap(record[4:])
+	case SamplerLegacyRecordType:
+		samplerLegacyLayout[2:].swap(record[4:])
+	case TYPE_STAT:
+		statLayout[2:].swap(record[4:])
+	case v1ExtensionMapType:
+		v1ExtensionMapLayout[2:].swap(record[4:])
+		for offset := v1ExtensionMapHead; offset+2 <= len(record); offset += 2 {
+			swapLayout{2}.swap(record[offset:])
+		}
+	case v1CommonRecordType:
+		swapV1Record(record, maps)
+	}
+}
+
+func swapV3Record(record []byte) {
+	v3RecordHeaderLayout[2:].swap(record[4:])
+	numElements := int(binary.LittleEndian.Uint16(record[4:6]))
+	offset := v3RecordHeaderSize
+	for i := 0; i < numElements && offset+4 <= len(record); i++ {
+		elementType, elementSize := swapRecordHeader(record[offset:])
+		if elementSize < 4 || offset+elementSize > len(record) {
+			return
+		}
+		if elementType < MAXEXTENSIONS {
+			v3SwapLayouts[elementType].swap(record[offset+4 : offset+elementSize])
+		}
+		offset += elementSize
+	}
+}
+
+func swapV4Record(record []byte) {
+	if len(record) < v4RecordHeaderSize {
+		return
+	}
+	v4RecordHeaderLayout[2:].swap(record[4:])
+	bitmap := binary.LittleEndian.Uint64(record[16:24])
+	if v4OffsetTableSize(bitmap) > len(record) {
+		return
+	}
+	for remaining, rank := bitmap, 0; remaining != 0; rank++ {
+		id := bits.TrailingZeros64(remaining)
+		remaining &= remaining - 1
+		entry := record[v4RecordHeaderSize+rank*2:]
+		swapLayout{2}.swap(entry)
+		offset := int(binary.LittleEndian.Uint16(entry))
+		if id < len(v4SwapLayouts) && offset < len(record) {
+			v4SwapLayouts[id].swap(record[offset:])
+		}
+	}
+}
+
+// swapV1Record converts a V1 common record using the extension map it
+// references. Without a known map only the common part is converted.
+func swapV1Record(record []byte, maps v1ExtensionMaps) {
+	if len(record) < v1CommonRecordSize {
+		return
+	}
+	v1CommonRecordLayout[2:].swap(record[4:])
+	flags := binary.LittleEndian.Uint16(record[4:6])
+	extensions, ok := maps[binary.LittleEndian.Uint16(record[6:8])]
+	if !ok {
+		return
+	}
+	offset := v1CommonRecordSize
+	next := func(layout swapLayout, size int) bool {
+		if offset+size > len(record) {
+			return false
+		}
+		layout.swap(record[offset : offset+size])
+		offset += size
+		return true
+	}
+	if flags&v1FlagIPv6Addr != 0 {
+		if !next(swapLayout{8, 8, 8, 8}, 32) {
+			return
+		}
+	} else if !next(swapLayout{4, 4}, 8) {
+		return
+	}
+	for _, wide := range []bool{flags&v1FlagPkg64 != 0, flags&v1FlagBytes64 != 0} {
+		counter := swapLayout{4}
+		if wide {
+			counter = swapLayout{8}
+		}
+		if !next(counter, int(counter[0])) {
+			return
+		}
+	}
+	for _, id := range extensions {
+		size, _ := v1ExtensionSize(id)
+		if !next(v1SwapLayout(id), size) {
+			return
+		}
+	}
+}
+
+// swapV18Block converts a decoded V3 block, header included, to
+// little-endian order.
+func swapV18Block(block []byte) {
+	v18BlockHeaderLayout.swap(block)
+	if len(block) < v18MetaBlockHead {
+		return
+	}
+	if binary.LittleEndian.Uint32(block[0:4]) == v18BlockFlow {
+		if len(block) < v18FlowBlockHead {
+			return
+		}
+		v18FlowBlockHeadLayout.swap(block[v18BlockHeader:])
+		swapRecords(block[v18FlowBlockHead:], binary.LittleEndian.Uint32(block[24:28]))
+		return
+	}
+	v18MetaBlockHeadLayout.swap(block[v18BlockHeader:])
+	swapRecords(block[v18MetaBlockHead:], binary.LittleEndian.Uint32(block[24:28]))
+}
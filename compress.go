@@ -0,0 +1,79 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package nfdump
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/dsnet/compress/bzip2"
+	zstd "github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	lzo "github.com/rasky/go-lzo"
+)
+
+// blockCompressor encodes the payload of a block for the writers. The
+// output of every codec is what the nfdump C code produces for a block and
+// what the readers decode: a raw LZO1X-1 or LZ4 block, a bzip2 stream or a
+// zstd frame.
+type blockCompressor struct {
+	zstdEncoder *zstd.Encoder
+}
+
+// compress returns the encoded data. ok is false if the codec does not
+// shrink the data, so the block is better stored uncompressed.
+func (compressor *blockCompressor) compress(compression Compression, data []byte) (out []byte, ok bool, err error) {
+	switch compression {
+	case CompressionNone:
+		return data, false, nil
+	case CompressionLZO:
+		out = lzo.Compress1X(data)
+	case CompressionBzip2:
+		var buf bytes.Buffer
+		writer, err := bzip2.NewWriter(&buf, &bzip2.WriterConfig{Level: bzip2.BestCompression})
+		if err != nil {
+			return nil, false, fmt.Errorf("bzip2 block: %w", err)
+		}
+		if _, err := writer.Write(data); err != nil {
+			return nil, false, fmt.Errorf("bzip2 block: %w", err)
+		}
+		if err := writer.Close(); err != nil {
+			return nil, false, fmt.Errorf("bzip2 block: %w", err)
+		}
+		out = buf.Bytes()
+	case CompressionLZ4:
+		out = make([]byte, lz4.CompressBlockBound(len(data)))
+		n, err := lz4.CompressBlock(data, out, nil)
+		if err != nil {
+			return nil, false, fmt.Errorf("lz4 block: %w", err)
+		}
+		// CompressBlock returns 0 for incompressible data.
+		out = out[:n]
+	case CompressionZSTD:
+		if compressor.zstdEncoder == nil {
+			encoder, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+			if err != nil {
+				return nil, false, fmt.Errorf("create zstd encoder: %w", err)
+			}
+			compressor.zstdEncoder = encoder
+		}
+		out = compressor.zstdEncoder.EncodeAll(data, nil)
+	default:
+		return nil, false, fmt.Errorf("unknown compression: %d", compression)
+	}
+	if len(out) == 0 || len(out) >= len(data) {
+		return data, false, nil
+	}
+	return out, true, nil
+}
+
+func (compressor *blockCompressor) close() {
+	if compressor.zstdEncoder != nil {
+		compressor.zstdEncoder.Close()
+		compressor.zstdEncoder = nil
+	}
+}
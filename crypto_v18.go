@@ -0,0 +1,106 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package nfdump
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// V3 block encryption identifiers. Encrypted blocks carry a 12-byte nonce
+// followed by the AES-GCM ciphertext and its 16-byte tag. The first 16 bytes
+// of the block header (type, size, raw size, compression and encryption) are
+// authenticated as additional data, so a block cannot be moved or relabelled
+// without failing authentication. The block checksum covers the on-disk
+// ciphertext and can therefore be verified without the key.
+const (
+	v18EncryptionNone   = 0
+	v18EncryptionAESGCM = 1
+
+	v18FlagEncrypted = 0x1
+)
+
+// KeyProvider supplies the AES key (16, 24 or 32 bytes) for an encrypted
+// file. Key is called once per Open with the metadata of the file being
+// opened, so a provider can select a key by creation time or nfdump version.
+type KeyProvider interface {
+	Key(info FileInfo) ([]byte, error)
+}
+
+// KeyProviderFunc adapts an ordinary function to the KeyProvider interface.
+type KeyProviderFunc func(info FileInfo) ([]byte, error)
+
+// Key calls f(info).
+func (f KeyProviderFunc) Key(info FileInfo) ([]byte, error) {
+	return f(info)
+}
+
+var (
+	// ErrKeyRequired is returned when an encrypted file is opened without a
+	// KeyProvider.
+	ErrKeyRequired = errors.New("nfdump: encrypted file requires a key provider")
+	// ErrWrongKey is returned when the supplied key does not match the key
+	// check value stored in the file header.
+	ErrWrongKey = errors.New("nfdump: wrong decryption key")
+	// ErrAuthentication is returned when an encrypted block fails
+	// authentication. The block was modified, or the key is wrong and the
+	// file carries no key check value.
+	ErrAuthentication = errors.New("nfdump: block authentication failed")
+)
+
+// v18KeyCheck returns the key check value stored in bytes 40-48 of an
+// encrypted V3 header: the first 8 bytes of an all-zero block encrypted with
+// the file key. A zero value in the header disables the check.
+func v18KeyCheck(block cipher.Block) uint64 {
+	var check [aes.BlockSize]byte
+	block.Encrypt(check[:], check[:])
+	return binary.LittleEndian.Uint64(check[:8])
+}
+
+// newV18Cipher asks the provider for the file key and prepares the block
+// cipher for an encrypted V3 file.
+func newV18Cipher(provider KeyProvider, info FileInfo, keyCheck uint64) (cipher.AEAD, error) {
+	if provider == nil {
+		return nil, ErrKeyRequired
+	}
+	key, err := provider.Key(info)
+	if err != nil {
+		return nil, fmt.Errorf("nfFile V3 key provider: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("nfFile V3 key: %w", err)
+	}
+	if keyCheck != 0 && v18KeyCheck(block) != keyCheck {
+		return nil, ErrWrongKey
+	}
+	return cipher.NewGCM(block)
+}
+
+// decryptV18 authenticates and decrypts the payload of an encrypted block in
+// place and returns the plaintext. header is the on-disk block header.
+func (reader *v18Reader) decryptV18(header, payload []byte, encryption uint16) ([]byte, error) {
+	if encryption != v18EncryptionAESGCM {
+		return nil, fmt.Errorf("unknown V3 block encryption: %d", encryption)
+	}
+	if reader.aead == nil {
+		return nil, fmt.Errorf("encrypted block in unencrypted V3 file")
+	}
+	nonceSize := reader.aead.NonceSize()
+	if len(payload) < nonceSize+reader.aead.Overhead() {
+		return nil, fmt.Errorf("encrypted V3 payload too short: %w", ErrAuthentication)
+	}
+	nonce, ciphertext := payload[:nonceSize], payload[nonceSize:]
+	plain, err := reader.aead.Open(ciphertext[:0], nonce, ciphertext, header[:16])
+	if err != nil {
+		return nil, fmt.Errorf("decrypt V3 block: %w", ErrAuthentication)
+	}
+	return plain, nil
+}
@@ -12,14 +12,24 @@ import (
 	"fmt"
 	"io"
 
-	zstd "github.com/klauspost/compress/zstd"
 	"github.com/pierrec/lz4/v4"
 	lzo "github.com/rasky/go-lzo"
 )
 
-// uncompressBlock decodes a single V1/V2 block using the compression semantics
-// of the nfdump 1.7.x reader backend.
-func (reader *v17Reader) uncompressBlock(blockHeader *DataBlockHeader) ([]byte, error) {
+// uncompressBlock reads the payload of a single V1/V2 block from file and
+// decodes it using the compression semantics of the nfdump 1.7.x reader
+// backend.
+func (reader *v17Reader) uncompressBlock(file io.Reader, blockHeader *DataBlockHeader) ([]byte, error) {
+	dataBlock, err := reader.readBlockPayload(file, blockHeader, false)
+	if err != nil {
+		return nil, err
+	}
+	return reader.decompressBlock(dataBlock, blockHeader, false)
+}
+
+// readBlockPayload reads the on-disk payload of a block. Unless owned is set
+// the payload aliases readBuf and is overwritten by the next read.
+func (reader *v17Reader) readBlockPayload(file io.Reader, blockHeader *DataBlockHeader, owned bool) ([]byte, error) {
 	blockLimit := reader.blockSizeLimit()
 	if blockHeader.Size > blockLimit {
 		return nil, fmt.Errorf("block size %d exceeds file block size %d", blockHeader.Size, blockLimit)
@@ -28,14 +38,26 @@ func (reader *v17Reader) uncompressBlock(blockHeader *DataBlockHeader) ([]byte,
 		return nil, fmt.Errorf("encrypted data blocks are not supported")
 	}
 
-	if cap(reader.readBuf) < int(blockHeader.Size) {
-		reader.readBuf = make([]byte, blockHeader.Size)
+	var dataBlock []byte
+	if owned {
+		dataBlock = make([]byte, blockHeader.Size)
+	} else {
+		if cap(reader.readBuf) < int(blockHeader.Size) {
+			reader.readBuf = make([]byte, blockHeader.Size)
+		}
+		dataBlock = reader.readBuf[:blockHeader.Size]
 	}
-	dataBlock := reader.readBuf[:blockHeader.Size]
-	if _, err := io.ReadAtLeast(reader.file, dataBlock, int(blockHeader.Size)); err != nil {
+	if _, err := io.ReadFull(file, dataBlock); err != nil {
 		return nil, fmt.Errorf("nfFile read data block: %w", err)
 	}
+	return dataBlock, nil
+}
 
+// decompressBlock decodes a payload read by readBlockPayload and updates
+// blockHeader.Size to the decoded size. It only touches state that is safe
+// to share, so the blocks of a walk may be decoded concurrently.
+func (reader *v17Reader) decompressBlock(dataBlock []byte, blockHeader *DataBlockHeader, owned bool) ([]byte, error) {
+	blockLimit := reader.blockSizeLimit()
 	compression := reader.header.Compression
 	if blockHeader.Flags&flagBlockUncompressed != 0 {
 		compression = NOT_COMPRESSED
@@ -43,6 +65,9 @@ func (reader *v17Reader) uncompressBlock(blockHeader *DataBlockHeader) ([]byte,
 
 	switch compression {
 	case NOT_COMPRESSED:
+		if owned {
+			break
+		}
 		// dataBlock still aliases the reused reader.readBuf here (no
 		// decompression step made an independent copy), and readBuf is
 		// overwritten by the next block read while this one may still be
@@ -78,14 +103,11 @@ func (reader *v17Reader) uncompressBlock(blockHeader *DataBlockHeader) ([]byte,
 		dataBlock = out
 		blockHeader.Size = uint32(n)
 	case ZSTD_COMPRESSED:
-		if reader.zstdDecoder == nil {
-			decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(uint64(blockLimit)))
-			if err != nil {
-				return nil, fmt.Errorf("nfFile create zstd decoder: %w", err)
-			}
-			reader.zstdDecoder = decoder
+		decoder, err := reader.zstdDecoder.get(uint64(blockLimit))
+		if err != nil {
+			return nil, fmt.Errorf("nfFile create zstd decoder: %w", err)
 		}
-		out, err := reader.zstdDecoder.DecodeAll(dataBlock, nil)
+		out, err := decoder.DecodeAll(dataBlock, nil)
 		if err != nil {
 			return nil, fmt.Errorf("nfFile uncompress zstd data block: %w", err)
 		}
@@ -12,7 +12,6 @@ import (
 	"fmt"
 	"io"
 
-	zstd "github.com/klauspost/compress/zstd"
 	"github.com/pierrec/lz4/v4"
 	lzo "github.com/rasky/go-lzo"
 )
@@ -75,17 +74,14 @@ func (reader *v18Reader) uncompressV18(data []byte, compression uint16, dst []by
 		}
 		return nil
 	case 5: // ZSTD_COMPRESSED
-		if reader.zstdDecoder == nil {
-			decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(uint64(reader.header.blockSize)))
-			if err != nil {
-				return fmt.Errorf("create V3 zstd decoder: %w", err)
-			}
-			reader.zstdDecoder = decoder
+		decoder, err := reader.zstdDecoder.get(uint64(reader.header.blockSize))
+		if err != nil {
+			return fmt.Errorf("create V3 zstd decoder: %w", err)
 		}
 		// dst[:0] retains dst's full capacity (exactly rawSize), so DecodeAll
 		// appends into dst's own backing array rather than allocating a new
 		// one - guaranteed by append's semantics since len+n <= cap here.
-		out, err := reader.zstdDecoder.DecodeAll(data, dst[:0])
+		out, err := decoder.DecodeAll(data, dst[:0])
 		if err != nil {
 			return fmt.Errorf("uncompress V3 zstd block: %w", err)
 		}
@@ -0,0 +1,23 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+//go:build linux
+
+package nfdump
+
+import (
+	"os"
+	"syscall"
+)
+
+// diskUsage returns the bytes a file occupies on disk, counted in 512 byte
+// blocks like nfcapd and nfexpire count them.
+func diskUsage(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Blocks) * 512
+	}
+	return uint64(info.Size())
+}
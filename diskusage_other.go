@@ -0,0 +1,17 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+//go:build !linux
+
+package nfdump
+
+import "os"
+
+// diskUsage returns the size of a file, as the blocks it occupies on disk
+// are only known on Linux.
+func diskUsage(info os.FileInfo) uint64 {
+	return uint64(info.Size())
+}
@@ -0,0 +1,151 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+// extract writes the flows of an nfdump file that match prefixes, a time
+// window or a protocol into a new file of the same layout, like nfdump -w
+// with a filter.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	nfdump "github.com/phaag/go-nfdump"
+)
+
+// timeLayout is the time format of nfdump -t.
+const timeLayout = "2006/01/02.15:04:05"
+
+var (
+	fileName    = flag.String("r", "", "nfdump file to read")
+	outName     = flag.String("w", "", "nfdump file to write")
+	compression = flag.String("z", "", "compression of the written file: none, lzo, bzip2, lz4 or zstd, default that of the input")
+	nets        = flag.String("net", "", "comma separated prefixes, matching the source or destination address")
+	start       = flag.String("start", "", "keep flows starting at or after this local time, "+timeLayout)
+	end         = flag.String("end", "", "keep flows starting before this local time, "+timeLayout)
+	proto       = flag.Int("proto", -1, "keep flows of this IP protocol")
+)
+
+var compressions = map[string]nfdump.Compression{
+	"none":  nfdump.CompressionNone,
+	"lzo":   nfdump.CompressionLZO,
+	"bzip2": nfdump.CompressionBzip2,
+	"lz4":   nfdump.CompressionLZ4,
+	"zstd":  nfdump.CompressionZSTD,
+}
+
+// parseTime returns the time value in msec since the Unix epoch, or
+// fallback for an empty value.
+func parseTime(value string, fallback uint64) uint64 {
+	if value == "" {
+		return fallback
+	}
+	t, err := time.ParseInLocation(timeLayout, value, time.Local)
+	if err != nil {
+		fmt.Printf("Invalid time %s: %v\n", value, err)
+		os.Exit(255)
+	}
+	return uint64(t.UnixMilli())
+}
+
+func main() {
+
+	flag.CommandLine.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if len(*fileName) == 0 || len(*outName) == 0 {
+		fmt.Printf("Input and output file required\n")
+		flag.PrintDefaults()
+		os.Exit(255)
+	}
+
+	var prefixes []netip.Prefix
+	if len(*nets) > 0 {
+		for _, value := range strings.Split(*nets, ",") {
+			prefix, err := netip.ParsePrefix(strings.TrimSpace(value))
+			if err != nil {
+				fmt.Printf("Invalid prefix %s: %v\n", value, err)
+				os.Exit(255)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+		}
+	}
+	first := parseTime(*start, 0)
+	last := parseTime(*end, ^uint64(0))
+
+	nffile := nfdump.New()
+	if err := nffile.Open(*fileName); err != nil {
+		fmt.Printf("Failed to open nf file: %v\n", err)
+		os.Exit(255)
+	}
+	defer nffile.Close()
+
+	info := nffile.Info()
+	codec := info.Compression
+	if len(*compression) > 0 {
+		var ok bool
+		if codec, ok = compressions[*compression]; !ok {
+			fmt.Printf("Unknown compression: %s\n", *compression)
+			os.Exit(255)
+		}
+	}
+	// V1 records are read as V3 records, which V2 files hold.
+	layout := info.Layout
+	if layout == nfdump.FileLayoutV1 {
+		layout = nfdump.FileLayoutV2
+	}
+	writer, err := nfdump.Create(*outName, nfdump.WithLayout(layout), nfdump.WithCompression(codec))
+	if err != nil {
+		fmt.Printf("Failed to create nf file: %v\n", err)
+		os.Exit(255)
+	}
+
+	keep := func(record nfdump.FlowRecord) bool {
+		if len(prefixes) > 0 {
+			src, dst, ok := record.IP()
+			if !ok {
+				return false
+			}
+			match := false
+			for _, prefix := range prefixes {
+				if prefix.Contains(src) || prefix.Contains(dst) {
+					match = true
+					break
+				}
+			}
+			if !match {
+				return false
+			}
+		}
+		if first == 0 && last == ^uint64(0) && *proto < 0 {
+			return true
+		}
+		generic, ok := record.Generic()
+		if !ok {
+			return false
+		}
+		return generic.MsecFirst >= first && generic.MsecFirst < last && (*proto < 0 || int(generic.Proto) == *proto)
+	}
+	written, err := nfdump.Extract(context.Background(), nffile, writer, keep)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Printf("Failed to extract from %s: %v\n", *fileName, err)
+		os.Remove(*outName)
+		os.Exit(255)
+	}
+	fmt.Printf("%s -> %s: %d flows\n", *fileName, *outName, written)
+}
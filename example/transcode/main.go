@@ -0,0 +1,93 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+// transcode converts an nfdump file between the nfdump 1.7.x (V2) and
+// 1.8.x (V3) layouts and reports the fields the target layout cannot hold.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	nfdump "github.com/phaag/go-nfdump"
+)
+
+var (
+	fileName    = flag.String("r", "", "nfdump file to read")
+	outName     = flag.String("w", "", "nfdump file to write")
+	layout      = flag.Int("l", 0, "layout of the written file: 2 or 3, default the other layout of the input")
+	compression = flag.String("z", "lz4", "compression of the written file: none, lzo, bzip2, lz4 or zstd")
+	strict      = flag.Bool("strict", false, "fail instead of dropping fields")
+)
+
+var compressions = map[string]nfdump.Compression{
+	"none":  nfdump.CompressionNone,
+	"lzo":   nfdump.CompressionLZO,
+	"bzip2": nfdump.CompressionBzip2,
+	"lz4":   nfdump.CompressionLZ4,
+	"zstd":  nfdump.CompressionZSTD,
+}
+
+func main() {
+
+	flag.CommandLine.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if len(*fileName) == 0 || len(*outName) == 0 {
+		fmt.Printf("Input and output file required\n")
+		flag.PrintDefaults()
+		os.Exit(255)
+	}
+	codec, ok := compressions[*compression]
+	if !ok {
+		fmt.Printf("Unknown compression: %s\n", *compression)
+		os.Exit(255)
+	}
+
+	nffile := nfdump.New()
+	if err := nffile.Open(*fileName); err != nil {
+		fmt.Printf("Failed to open nf file: %v\n", err)
+		os.Exit(255)
+	}
+	defer nffile.Close()
+
+	target := nfdump.FileLayout(*layout)
+	if target == 0 {
+		target = nfdump.FileLayoutV3
+		if nffile.Info().Layout == nfdump.FileLayoutV3 {
+			target = nfdump.FileLayoutV2
+		}
+	}
+	writer, err := nfdump.Create(*outName, nfdump.WithLayout(target), nfdump.WithCompression(codec))
+	if err != nil {
+		fmt.Printf("Failed to create nf file: %v\n", err)
+		os.Exit(255)
+	}
+
+	var options []nfdump.TranscodeOption
+	if *strict {
+		options = append(options, nfdump.WithStrict())
+	}
+	report, err := nfdump.Transcode(context.Background(), nffile, writer, options...)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Printf("Failed to transcode %s: %v\n", *fileName, err)
+		os.Remove(*outName)
+		os.Exit(255)
+	}
+	fmt.Printf("%s -> %s: %v\n", *fileName, *outName, report)
+	if !report.Lossless() {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+// verify checks nfdump files for corrupt blocks, container damage and stat
+// records that do not match the flows. It exits with 1 if any file has a
+// problem, so it can be used for alerting.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	nfdump "github.com/phaag/go-nfdump"
+)
+
+var (
+	quiet = flag.Bool("q", false, "print only files with problems")
+)
+
+func main() {
+
+	flag.CommandLine.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage of %s [flags] file ...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Printf("Filename required\n")
+		flag.CommandLine.Usage()
+		os.Exit(255)
+	}
+
+	failed := false
+	for _, fileName := range flag.Args() {
+		report, err := nfdump.VerifyFile(context.Background(), fileName)
+		if err != nil {
+			fmt.Printf("Failed to verify %s: %v\n", fileName, err)
+			os.Exit(255)
+		}
+		if !report.OK() {
+			failed = true
+		} else if *quiet {
+			continue
+		}
+		fmt.Printf("%s: %v\n", fileName, report)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
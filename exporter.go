@@ -11,6 +11,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net"
+	"slices"
 	"syscall"
 	"unsafe"
 )
@@ -68,6 +69,7 @@ func (nfFile *NfFile) addExporterInfo(record []byte) error {
 		exporter.IP = net.IP{record[exOffset+7], record[exOffset+6], record[exOffset+5], record[exOffset+4], record[exOffset+3], record[exOffset+2], record[exOffset+1], record[exOffset+0], record[exOffset+15], record[exOffset+14], record[exOffset+13], record[exOffset+12], record[exOffset+11], record[exOffset+10], record[exOffset+9], record[exOffset+8]}
 	}
 	nfFile.ExporterList[exporter.SysId] = exporter
+	nfFile.exporterUpdates++
 	return nil
 }
 
@@ -120,11 +122,89 @@ func (nfFile *NfFile) addSampler(record []byte) error {
 		PacketInterval: samplerInfo.PacketInterval,
 		SpaceInterval:  samplerInfo.SpaceInterval,
 	})
+	nfFile.exporterUpdates++
 
 	return nil
 }
 
+// addLegacySampler adds an nfdump 1.6.x sampler record: record header, sampler
+// ID (int32), packet interval (uint32), exporter sysID (uint16) and mode.
+func (nfFile *NfFile) addLegacySampler(record []byte) error {
+	const legacySamplerSize = 16
+	if len(record) < legacySamplerSize {
+		return fmt.Errorf("legacy sampler record too short: %d bytes", len(record))
+	}
+	sysID := binary.LittleEndian.Uint16(record[12:14])
+	if int(sysID) >= len(nfFile.ExporterList) || nfFile.ExporterList[sysID].IP == nil {
+		return fmt.Errorf("no valid exporter for sampler")
+	}
+	nfFile.ExporterList[sysID].SamplerList = append(nfFile.ExporterList[sysID].SamplerList, Sampler{
+		Id:             int64(int32(binary.LittleEndian.Uint32(record[4:8]))),
+		Algorithm:      uint16(record[14]),
+		PacketInterval: binary.LittleEndian.Uint32(record[8:12]),
+	})
+	nfFile.exporterUpdates++
+	return nil
+}
+
 // Get exporter list
 func (nfFile *NfFile) GetExporterList() []Exporter {
 	return nfFile.ExporterList
 }
+
+// encodeExporterInfo encodes the exporter record of exporter, the inverse of
+// addExporterInfo.
+func encodeExporterInfo(exporter Exporter) []byte {
+	record := make([]byte, unsafe.Sizeof(ExporterInfoRecord{}))
+	binary.LittleEndian.PutUint16(record[0:2], ExporterInfoRecordType)
+	binary.LittleEndian.PutUint16(record[2:4], uint16(len(record)))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(exporter.Version))
+	if ip := exporter.IP.To4(); ip != nil && !exporter.isV6 {
+		binary.LittleEndian.PutUint32(record[16:20], binary.BigEndian.Uint32(ip))
+		binary.LittleEndian.PutUint16(record[24:26], syscall.AF_INET)
+	} else if ip := exporter.IP.To16(); ip != nil {
+		binary.LittleEndian.PutUint64(record[8:16], binary.BigEndian.Uint64(ip[0:8]))
+		binary.LittleEndian.PutUint64(record[16:24], binary.BigEndian.Uint64(ip[8:16]))
+		binary.LittleEndian.PutUint16(record[24:26], syscall.AF_INET6)
+	}
+	binary.LittleEndian.PutUint16(record[26:28], exporter.SysId)
+	binary.LittleEndian.PutUint32(record[28:32], exporter.Id)
+	return record
+}
+
+// encodeSampler encodes a sampler of the exporter sysID, the inverse of
+// addSampler.
+func encodeSampler(sysID uint16, sampler Sampler) []byte {
+	record := make([]byte, unsafe.Sizeof(SamplerRecord{}))
+	binary.LittleEndian.PutUint16(record[0:2], SamplerRecordType)
+	binary.LittleEndian.PutUint16(record[2:4], uint16(len(record)))
+	binary.LittleEndian.PutUint16(record[4:6], sysID)
+	binary.LittleEndian.PutUint16(record[6:8], sampler.Algorithm)
+	binary.LittleEndian.PutUint64(record[8:16], uint64(sampler.Id))
+	binary.LittleEndian.PutUint32(record[16:20], sampler.PacketInterval)
+	binary.LittleEndian.PutUint32(record[20:24], sampler.SpaceInterval)
+	return record
+}
+
+// encodeExporterStats encodes the counters of exporters as exporter stat
+// records, the inverse of addExporterStat. Each record holds up to 64
+// exporters, so that it fits into any block.
+func encodeExporterStats(exporters []Exporter) [][]byte {
+	const statsHeaderSize = 8
+	const statSize = 24
+	var records [][]byte
+	for chunk := range slices.Chunk(exporters, 64) {
+		record := make([]byte, statsHeaderSize, statsHeaderSize+len(chunk)*statSize)
+		binary.LittleEndian.PutUint16(record[0:2], ExporterStatRecordType)
+		binary.LittleEndian.PutUint16(record[2:4], uint16(cap(record)))
+		binary.LittleEndian.PutUint32(record[4:8], uint32(len(chunk)))
+		for _, exporter := range chunk {
+			record = binary.LittleEndian.AppendUint32(record, uint32(exporter.SysId))
+			record = binary.LittleEndian.AppendUint32(record, exporter.SequenceFailures)
+			record = binary.LittleEndian.AppendUint64(record, exporter.Packets)
+			record = binary.LittleEndian.AppendUint64(record, exporter.Flows)
+		}
+		records = append(records, record)
+	}
+	return records
+}
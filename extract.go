@@ -0,0 +1,57 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package nfdump
+
+import (
+	"context"
+	"fmt"
+)
+
+// Extract writes the flows of src that keep accepts to dst, like nfdump -w
+// with a filter, and returns the number of flows written. The records are
+// written unchanged, so dst must hold the record format of src: a V2 file
+// for the records of V1 and V2 files, a V3 file for those of V3 files. The
+// exporter and sampler records of the exporters of the written flows are
+// written before their first flow; the exporter counters are not, as they
+// account for all flows of src. The stat record of dst is computed from the
+// written flows. dst is not closed.
+//
+// If dst is an NfWriter, Extract sets its ident to that of src, unless dst
+// was created WithIdent or the ident of src is longer than WithIdent allows.
+// keep must not be nil.
+func Extract(ctx context.Context, src *NfFile, dst Writer, keep func(FlowRecord) bool) (uint64, error) {
+	if keep == nil {
+		return 0, fmt.Errorf("nfFile extract: nil keep function")
+	}
+	writer, ok := dst.(*NfWriter)
+	if ok && writer.closed {
+		return 0, fmt.Errorf("nfFile extract: writer closed")
+	}
+	format := dst.RecordFormat()
+	sink := newFlowSink(dst)
+	var written uint64
+	err := src.Walk(ctx, func(record FlowRecord) error {
+		if !keep(record) {
+			return nil
+		}
+		if record.format != format {
+			return fmt.Errorf("nfFile extract: record format %d does not match writer format %d", record.format, format)
+		}
+		if err := sink.write(src, record); err != nil {
+			return fmt.Errorf("nfFile extract: %w", err)
+		}
+		written++
+		return nil
+	})
+	if err != nil {
+		return written, err
+	}
+	if ident := src.Ident(); ok && writer.ident == "" && len(ident) <= maxIdentLength {
+		writer.ident = ident
+	}
+	return written, nil
+}
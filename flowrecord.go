@@ -10,6 +10,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math/bits"
+	"net"
 	"net/netip"
 )
 
@@ -55,6 +56,21 @@ const (
 	ExtensionASRouting   ExtensionID = EXasRoutingID
 	ExtensionInPayload   ExtensionID = EXinPayloadID
 	ExtensionIPInfo      ExtensionID = EXipInfoID
+
+	ExtensionBGPNextHopV4 ExtensionID = EXbgpNextHopV4ID
+	ExtensionBGPNextHopV6 ExtensionID = EXbgpNextHopV6ID
+	ExtensionIPNextHopV4  ExtensionID = EXipNextHopV4ID
+	ExtensionIPNextHopV6  ExtensionID = EXipNextHopV6ID
+	ExtensionIPReceivedV4 ExtensionID = EXipReceivedV4ID
+	ExtensionIPReceivedV6 ExtensionID = EXipReceivedV6ID
+
+	ExtensionNATXlateIPv4 ExtensionID = EXnatXlateIPv4ID
+	ExtensionNATXlateIPv6 ExtensionID = EXnatXlateIPv6ID
+	ExtensionNATXlatePort ExtensionID = EXnatXlatePortID
+	ExtensionNATCommon    ExtensionID = EXnatCommonID
+	ExtensionNATPortBlock ExtensionID = EXnatPortBlockID
+	ExtensionMACAddr      ExtensionID = EXmacAddrID
+	ExtensionLayer2       ExtensionID = EXlayer2ID
 )
 
 // GenericFlow contains the fields common to every flow record that has a
@@ -73,6 +89,141 @@ type GenericFlow struct {
 	SrcTos       uint8
 }
 
+// FlowMisc contains the interfaces, network masks and miscellaneous flow
+// attributes. V4 records store the interfaces in an extension of their own.
+type FlowMisc struct {
+	Input         uint32
+	Output        uint32
+	SrcMask       uint8
+	DstMask       uint8
+	Dir           uint8
+	DstTos        uint8
+	BiFlowDir     uint8
+	FlowEndReason uint8
+}
+
+// Counters contains the flow count and the output counters of a flow.
+type Counters struct {
+	Flows      uint64
+	OutPackets uint64
+	OutBytes   uint64
+}
+
+// VLAN contains the source and destination VLAN IDs.
+type VLAN struct {
+	Src uint32
+	Dst uint32
+}
+
+// AS contains the source and destination autonomous system numbers.
+type AS struct {
+	Src uint32
+	Dst uint32
+}
+
+// IPInfo contains the IP fragment flags and the TTL range of a flow.
+type IPInfo struct {
+	FragmentFlags uint8
+	MinTTL        uint8
+	MaxTTL        uint8
+}
+
+// MAC contains the MAC addresses of a flow, seen at the input and output
+// interface. Addresses the exporter did not report are nil.
+type MAC struct {
+	InSrc  net.HardwareAddr
+	OutDst net.HardwareAddr
+	InDst  net.HardwareAddr
+	OutSrc net.HardwareAddr
+}
+
+// Layer2 contains the layer 2 information of a flow: its VLAN and customer
+// VLAN IDs before and after the exporter, the physical ingress and egress
+// interfaces, the VXLAN network identifier, the ether type and the IP
+// version.
+type Layer2 struct {
+	VLAN             uint16
+	CustomerVLAN     uint16
+	PostVLAN         uint16
+	PostCustomerVLAN uint16
+	Ingress          uint32
+	Egress           uint32
+	VXLAN            uint64
+	EtherType        uint16
+	IPVersion        uint8
+}
+
+// NATEventType is the type of a NAT event, such as a session or port-block
+// allocation.
+type NATEventType uint8
+
+// NAT event types, as registered for the IPFIX natEvent element.
+const (
+	NATEventCreate                NATEventType = 1
+	NATEventDelete                NATEventType = 2
+	NATEventAddressesExhausted    NATEventType = 3
+	NATEventNAT44SessionCreate    NATEventType = 4
+	NATEventNAT44SessionDelete    NATEventType = 5
+	NATEventNAT64SessionCreate    NATEventType = 6
+	NATEventNAT64SessionDelete    NATEventType = 7
+	NATEventNAT44BIBCreate        NATEventType = 8
+	NATEventNAT44BIBDelete        NATEventType = 9
+	NATEventNAT64BIBCreate        NATEventType = 10
+	NATEventNAT64BIBDelete        NATEventType = 11
+	NATEventPortsExhausted        NATEventType = 12
+	NATEventQuotaExceeded         NATEventType = 13
+	NATEventAddressBindingCreate  NATEventType = 14
+	NATEventAddressBindingDelete  NATEventType = 15
+	NATEventPortBlockAllocation   NATEventType = 16
+	NATEventPortBlockDeallocation NATEventType = 17
+	NATEventThresholdReached      NATEventType = 18
+)
+
+var natEventNames = [...]string{
+	NATEventCreate:                "NAT translation create",
+	NATEventDelete:                "NAT translation delete",
+	NATEventAddressesExhausted:    "NAT addresses exhausted",
+	NATEventNAT44SessionCreate:    "NAT44 session create",
+	NATEventNAT44SessionDelete:    "NAT44 session delete",
+	NATEventNAT64SessionCreate:    "NAT64 session create",
+	NATEventNAT64SessionDelete:    "NAT64 session delete",
+	NATEventNAT44BIBCreate:        "NAT44 BIB create",
+	NATEventNAT44BIBDelete:        "NAT44 BIB delete",
+	NATEventNAT64BIBCreate:        "NAT64 BIB create",
+	NATEventNAT64BIBDelete:        "NAT64 BIB delete",
+	NATEventPortsExhausted:        "NAT ports exhausted",
+	NATEventQuotaExceeded:         "quota exceeded",
+	NATEventAddressBindingCreate:  "address binding create",
+	NATEventAddressBindingDelete:  "address binding delete",
+	NATEventPortBlockAllocation:   "port block allocation",
+	NATEventPortBlockDeallocation: "port block deallocation",
+	NATEventThresholdReached:      "threshold reached",
+}
+
+// String returns the registered name of the event type.
+func (event NATEventType) String() string {
+	if int(event) < len(natEventNames) && natEventNames[event] != "" {
+		return natEventNames[event]
+	}
+	return fmt.Sprintf("NAT event %d", uint8(event))
+}
+
+// NATEvent describes the NAT event of a CGNAT log record. MsecEvent is the
+// event time in milliseconds since the Unix epoch.
+type NATEvent struct {
+	MsecEvent uint64
+	Event     NATEventType
+	PoolID    uint32
+}
+
+// NATPortBlock is the port block allocated by a NAT event.
+type NATPortBlock struct {
+	Start uint16
+	End   uint16
+	Step  uint16
+	Size  uint16
+}
+
 // FlowRecord is a compact, read-only view of a flow record. It is passed to a
 // Walk callback by value and is valid only for the duration of that callback.
 // Call Clone to retain a record after the callback returns.
@@ -133,36 +284,126 @@ func (record FlowRecord) v3Extension(id ExtensionID) []byte {
 	return nil
 }
 
-// v4ExtensionID maps a stable public identifier to its V4 bitmap bit. The V4
-// layout split several old extensions and assigned new bitmap positions.
+// V4 extension bitmap positions. The V4 layout split several old extensions
+// and assigned new bitmap positions: EXinterface holds the interfaces of the
+// V3 EXflowMisc, and the other extensions are numbered apart from their V3
+// counterparts.
+const (
+	v4ExGenericFlow    = 1
+	v4ExIPv4Flow       = 2
+	v4ExIPv6Flow       = 3
+	v4ExInterface      = 4
+	v4ExFlowMisc       = 5
+	v4ExCntFlow        = 6
+	v4ExVLAN           = 7
+	v4ExASInfo         = 8
+	v4ExASAdjacent     = 9
+	v4ExMacAddr        = 10
+	v4ExBGPNextHopV4   = 11
+	v4ExBGPNextHopV6   = 12
+	v4ExMPLSLabel      = 13
+	v4ExIPNextHopV6    = 14
+	v4ExIPReceivedV6   = 15
+	v4ExIPNextHopV4    = 16
+	v4ExLatency        = 17
+	v4ExNATXlateIPv4   = 18
+	v4ExNATXlateIPv6   = 19
+	v4ExNATXlatePort   = 20
+	v4ExNSELACL        = 21
+	v4ExIPReceivedV4   = 24
+	v4ExInPayload      = 26
+	v4ExNATCommon      = 28
+	v4ExNSELCommon     = 30
+	v4ExNATPortBlock   = 31
+	v4ExNokiaNatString = 32
+	v4ExVRF            = 33
+	v4ExLayer2         = 35
+	v4ExFlowID         = 36
+	v4ExNokiaNat       = 38
+	v4ExIPInfo         = 39
+)
+
+// v4Mapping describes how a logical extension is stored in a V4 record: its
+// bitmap position and the size of its V3 data. V4 pads smaller V3 data to
+// its fixed extension size and prefixes variable-length data, marked by a
+// zero v3Size, with its length. EXflowMisc additionally stores its
+// interfaces in EXinterface.
+type v4Mapping struct {
+	bit    uint8
+	v3Size uint16
+}
+
+// v4Mappings maps the logical extension IDs, which are the V3 element IDs,
+// to their V4 representation. Extensions without a bitmap position have no
+// V4 equivalent.
+var v4Mappings = [MAXEXTENSIONS]v4Mapping{
+	EXgenericFlowID:    {v4ExGenericFlow, 48},
+	EXipv4FlowID:       {v4ExIPv4Flow, 8},
+	EXipv6FlowID:       {v4ExIPv6Flow, 32},
+	EXflowMiscID:       {v4ExFlowMisc, 16},
+	EXcntFlowID:        {v4ExCntFlow, 24},
+	EXvLanID:           {v4ExVLAN, 8},
+	EXasRoutingID:      {v4ExASInfo, 8}, // V4 EXasInfo carries source and destination AS.
+	EXbgpNextHopV4ID:   {v4ExBGPNextHopV4, 4},
+	EXbgpNextHopV6ID:   {v4ExBGPNextHopV6, 16},
+	EXipNextHopV4ID:    {v4ExIPNextHopV4, 4},
+	EXipNextHopV6ID:    {v4ExIPNextHopV6, 16},
+	EXipReceivedV4ID:   {v4ExIPReceivedV4, 4},
+	EXipReceivedV6ID:   {v4ExIPReceivedV6, 16},
+	EXmplsLabelID:      {v4ExMPLSLabel, 40},
+	EXmacAddrID:        {v4ExMacAddr, 32},
+	EXasAdjacentID:     {v4ExASAdjacent, 8},
+	EXlatencyID:        {v4ExLatency, 24},
+	EXnselCommonID:     {v4ExNSELCommon, 16},
+	EXnatXlateIPv4ID:   {v4ExNATXlateIPv4, 8},
+	EXnatXlateIPv6ID:   {v4ExNATXlateIPv6, 32},
+	EXnatXlatePortID:   {v4ExNATXlatePort, 4},
+	EXnselAclID:        {v4ExNSELACL, 24},
+	EXnatCommonID:      {v4ExNATCommon, 16},
+	EXnatPortBlockID:   {v4ExNATPortBlock, 8},
+	EXinPayloadID:      {v4ExInPayload, 0},
+	EXvrfID:            {v4ExVRF, 8},
+	EXlayer2ID:         {v4ExLayer2, 32},
+	EXflowIdID:         {v4ExFlowID, 8},
+	EXnokiaNatID:       {v4ExNokiaNat, 4},
+	EXnokiaNatStringID: {v4ExNokiaNatString, 0},
+	EXipInfoID:         {v4ExIPInfo, 4},
+}
+
+// v3ExtensionIDs is the inverse of v4Mappings, indexed by bitmap position.
+var v3ExtensionIDs = func() (ids [40]ExtensionID) {
+	for id, mapping := range v4Mappings {
+		if mapping.bit != 0 {
+			ids[mapping.bit] = ExtensionID(id)
+		}
+	}
+	return ids
+}()
+
+// v4ExtensionID maps a stable public identifier to its V4 bitmap bit.
 func v4ExtensionID(id ExtensionID) (uint, bool) {
-	switch id {
-	case ExtensionGenericFlow:
-		return 1, true
-	case ExtensionIPv4Flow:
-		return 2, true
-	case ExtensionIPv6Flow:
-		return 3, true
-	case ExtensionFlowMisc:
-		return 5, true
-	case ExtensionCounters:
-		return 6, true
-	case ExtensionVLAN:
-		return 7, true
-	case ExtensionASRouting:
-		return 8, true // V4 EXasInfo carries source and destination AS.
-	case ExtensionInPayload:
-		return 26, true
-	case ExtensionIPInfo:
-		return 39, true
-	default:
+	if int(id) >= len(v4Mappings) || v4Mappings[id].bit == 0 {
 		return 0, false
 	}
+	return uint(v4Mappings[id].bit), true
 }
 
 func (record FlowRecord) v4Extension(id ExtensionID) []byte {
 	extID, ok := v4ExtensionID(id)
-	if !ok || len(record.raw) < v4RecordHeaderSize {
+	if !ok {
+		return nil
+	}
+	data := record.v4ExtensionAt(extID)
+	if extID == v4ExInPayload && data != nil { // EXPayload_t: expose its byte payload, not the length word.
+		return data[4:]
+	}
+	return data
+}
+
+// v4ExtensionAt returns the extension at bitmap position extID, including
+// the length word of variable-length extensions.
+func (record FlowRecord) v4ExtensionAt(extID uint) []byte {
+	if len(record.raw) < v4RecordHeaderSize {
 		return nil
 	}
 	bitmap := binary.LittleEndian.Uint64(record.raw[16:24])
@@ -177,11 +418,7 @@ func (record FlowRecord) v4Extension(id ExtensionID) []byte {
 	if !ok || offset < offsetTable || offset+size > len(record.raw) {
 		return nil
 	}
-	data := record.raw[offset : offset+size]
-	if extID == 26 { // EXPayload_t: expose its byte payload, not the length word.
-		return data[4:]
-	}
-	return data
+	return record.raw[offset : offset+size]
 }
 
 // Generic returns generic flow counters, timestamps, and transport fields.
@@ -228,6 +465,198 @@ func (record FlowRecord) IsIPv6() bool {
 	return len(record.Extension(ExtensionIPv6Flow)) >= 32
 }
 
+// FlowMisc returns the interfaces, network masks and miscellaneous flow
+// attributes. ok is false when the record has none of them. A V4 record may
+// carry only one of its two extensions; the fields of the other are zero.
+func (record FlowRecord) FlowMisc() (FlowMisc, bool) {
+	var interfaces, misc []byte
+	switch record.format {
+	case RecordFormatV3:
+		if data := record.v3Extension(ExtensionFlowMisc); len(data) >= 14 {
+			interfaces, misc = data[0:8], data[8:14]
+		}
+	case RecordFormatV4:
+		if data := record.v4ExtensionAt(v4ExInterface); len(data) >= 8 {
+			interfaces = data[0:8]
+		}
+		if data := record.v4ExtensionAt(v4ExFlowMisc); len(data) >= 6 {
+			misc = data[0:6]
+		}
+	}
+	if interfaces == nil && misc == nil {
+		return FlowMisc{}, false
+	}
+	var flowMisc FlowMisc
+	if interfaces != nil {
+		flowMisc.Input = binary.LittleEndian.Uint32(interfaces[0:4])
+		flowMisc.Output = binary.LittleEndian.Uint32(interfaces[4:8])
+	}
+	if misc != nil {
+		flowMisc.SrcMask, flowMisc.DstMask, flowMisc.Dir = misc[0], misc[1], misc[2]
+		flowMisc.DstTos, flowMisc.BiFlowDir, flowMisc.FlowEndReason = misc[3], misc[4], misc[5]
+	}
+	return flowMisc, true
+}
+
+// Counters returns the flow count and the output counters.
+func (record FlowRecord) Counters() (Counters, bool) {
+	data := record.Extension(ExtensionCounters)
+	if len(data) < 24 {
+		return Counters{}, false
+	}
+	return Counters{
+		Flows:      binary.LittleEndian.Uint64(data[0:8]),
+		OutPackets: binary.LittleEndian.Uint64(data[8:16]),
+		OutBytes:   binary.LittleEndian.Uint64(data[16:24]),
+	}, true
+}
+
+// VLAN returns the source and destination VLAN IDs.
+func (record FlowRecord) VLAN() (VLAN, bool) {
+	data := record.Extension(ExtensionVLAN)
+	if len(data) < 8 {
+		return VLAN{}, false
+	}
+	return VLAN{Src: binary.LittleEndian.Uint32(data[0:4]), Dst: binary.LittleEndian.Uint32(data[4:8])}, true
+}
+
+// AS returns the source and destination AS numbers, stored in EXasRouting
+// by V3 records and in EXasInfo by V4 records.
+func (record FlowRecord) AS() (AS, bool) {
+	data := record.Extension(ExtensionASRouting)
+	if len(data) < 8 {
+		return AS{}, false
+	}
+	return AS{Src: binary.LittleEndian.Uint32(data[0:4]), Dst: binary.LittleEndian.Uint32(data[4:8])}, true
+}
+
+// IPInfo returns the IP fragment flags and the TTL range.
+func (record FlowRecord) IPInfo() (IPInfo, bool) {
+	data := record.Extension(ExtensionIPInfo)
+	if len(data) < 4 {
+		return IPInfo{}, false
+	}
+	return IPInfo{FragmentFlags: data[1], MinTTL: data[2], MaxTTL: data[3]}, true
+}
+
+// BGPNextHop returns the BGP next hop address.
+func (record FlowRecord) BGPNextHop() (netip.Addr, bool) {
+	return record.addr(ExtensionBGPNextHopV4, ExtensionBGPNextHopV6)
+}
+
+// IPNextHop returns the IP next hop address.
+func (record FlowRecord) IPNextHop() (netip.Addr, bool) {
+	return record.addr(ExtensionIPNextHopV4, ExtensionIPNextHopV6)
+}
+
+// Received returns the address the collector received the flow from.
+func (record FlowRecord) Received() (netip.Addr, bool) {
+	return record.addr(ExtensionIPReceivedV4, ExtensionIPReceivedV6)
+}
+
+// addr returns the address of the IPv4 extension v4 or, if it is absent,
+// of the IPv6 extension v6. Both formats store IPv4 addresses as a
+// little-endian word and IPv6 addresses as two little-endian words.
+func (record FlowRecord) addr(v4, v6 ExtensionID) (netip.Addr, bool) {
+	if data := record.Extension(v4); len(data) >= 4 {
+		return netip.AddrFrom4([4]byte{data[3], data[2], data[1], data[0]}), true
+	}
+	if data := record.Extension(v6); len(data) >= 16 {
+		return netip.AddrFrom16(v3IPv6(data[0:16])), true
+	}
+	return netip.Addr{}, false
+}
+
+// NATAddrs returns the translated source and destination addresses.
+func (record FlowRecord) NATAddrs() (src, dst netip.Addr, ok bool) {
+	if data := record.Extension(ExtensionNATXlateIPv4); len(data) >= 8 {
+		return netip.AddrFrom4([4]byte{data[3], data[2], data[1], data[0]}),
+			netip.AddrFrom4([4]byte{data[7], data[6], data[5], data[4]}), true
+	}
+	if data := record.Extension(ExtensionNATXlateIPv6); len(data) >= 32 {
+		return netip.AddrFrom16(v3IPv6(data[0:16])), netip.AddrFrom16(v3IPv6(data[16:32])), true
+	}
+	return netip.Addr{}, netip.Addr{}, false
+}
+
+// NATPorts returns the translated source and destination ports.
+func (record FlowRecord) NATPorts() (src, dst uint16, ok bool) {
+	data := record.Extension(ExtensionNATXlatePort)
+	if len(data) < 4 {
+		return 0, 0, false
+	}
+	return binary.LittleEndian.Uint16(data[0:2]), binary.LittleEndian.Uint16(data[2:4]), true
+}
+
+// NATEvent returns the NAT event type, pool ID and event time.
+func (record FlowRecord) NATEvent() (NATEvent, bool) {
+	data := record.Extension(ExtensionNATCommon)
+	if len(data) < 13 {
+		return NATEvent{}, false
+	}
+	return NATEvent{
+		MsecEvent: binary.LittleEndian.Uint64(data[0:8]),
+		PoolID:    binary.LittleEndian.Uint32(data[8:12]),
+		Event:     NATEventType(data[12]),
+	}, true
+}
+
+// NATPortBlock returns the allocated NAT port block.
+func (record FlowRecord) NATPortBlock() (NATPortBlock, bool) {
+	data := record.Extension(ExtensionNATPortBlock)
+	if len(data) < 8 {
+		return NATPortBlock{}, false
+	}
+	return NATPortBlock{
+		Start: binary.LittleEndian.Uint16(data[0:2]),
+		End:   binary.LittleEndian.Uint16(data[2:4]),
+		Step:  binary.LittleEndian.Uint16(data[4:6]),
+		Size:  binary.LittleEndian.Uint16(data[6:8]),
+	}, true
+}
+
+// MAC returns the MAC addresses.
+func (record FlowRecord) MAC() (MAC, bool) {
+	data := record.Extension(ExtensionMACAddr)
+	if len(data) < 32 {
+		return MAC{}, false
+	}
+	return MAC{
+		InSrc:  hardwareAddr(data[0:8]),
+		OutDst: hardwareAddr(data[8:16]),
+		InDst:  hardwareAddr(data[16:24]),
+		OutSrc: hardwareAddr(data[24:32]),
+	}, true
+}
+
+// hardwareAddr decodes a MAC address nfdump stores in the low 48 bits of a
+// 64-bit integer. A zero address yields nil.
+func hardwareAddr(data []byte) net.HardwareAddr {
+	if binary.LittleEndian.Uint64(data) == 0 {
+		return nil
+	}
+	return net.HardwareAddr{data[5], data[4], data[3], data[2], data[1], data[0]}
+}
+
+// Layer2 returns the layer 2 information.
+func (record FlowRecord) Layer2() (Layer2, bool) {
+	data := record.Extension(ExtensionLayer2)
+	if len(data) < 27 {
+		return Layer2{}, false
+	}
+	return Layer2{
+		VLAN:             binary.LittleEndian.Uint16(data[0:2]),
+		CustomerVLAN:     binary.LittleEndian.Uint16(data[2:4]),
+		PostVLAN:         binary.LittleEndian.Uint16(data[4:6]),
+		PostCustomerVLAN: binary.LittleEndian.Uint16(data[6:8]),
+		Ingress:          binary.LittleEndian.Uint32(data[8:12]),
+		Egress:           binary.LittleEndian.Uint32(data[12:16]),
+		VXLAN:            binary.LittleEndian.Uint64(data[16:24]),
+		EtherType:        binary.LittleEndian.Uint16(data[24:26]),
+		IPVersion:        data[26],
+	}, true
+}
+
 // ExporterID returns nfdump's exporter identifier.
 func (record FlowRecord) ExporterID() uint32 {
 	switch record.format {
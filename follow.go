@@ -0,0 +1,130 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package nfdump
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// FollowOption configures Follow.
+type FollowOption func(*followConfig)
+
+type followConfig struct {
+	poll time.Duration
+}
+
+// WithPollInterval sets how long Follow waits before it looks for new blocks
+// again. The default is one second.
+func WithPollInterval(d time.Duration) FollowOption {
+	return func(cfg *followConfig) {
+		if d > 0 {
+			cfg.poll = d
+		}
+	}
+}
+
+// follower tracks a file that is still being written, such as the
+// nfcapd.current.<pid> file of a running collector.
+type follower struct {
+	file *os.File
+	name string
+	// info identifies the followed file, so a rename or replacement of name
+	// can be told apart from a file that is still growing.
+	info os.FileInfo
+	poll time.Duration
+}
+
+// size returns the number of bytes written so far.
+func (f *follower) size() (int64, error) {
+	info, err := f.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// ended reports whether the file is no longer reachable under its name. nfcapd
+// renames the file once it has been closed at rotation, so no more data is
+// appended after that.
+func (f *follower) ended() bool {
+	info, err := os.Stat(f.name)
+	return err != nil || !os.SameFile(info, f.info)
+}
+
+// wait sleeps for one poll interval or until ctx is done.
+func (f *follower) wait(ctx context.Context) error {
+	timer := time.NewTimer(f.poll)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Follow reads a flow file while nfcapd is still writing it, such as
+// nfcapd.current.<pid>. It walks the blocks that are complete, waits for new
+// blocks to be appended and returns nil once the writer has closed the file
+// or the file has been renamed or removed, after the last complete block has
+// been delivered. Canceling ctx stops Follow with ctx.Err(). Records are
+// delivered to fn as with Walk.
+//
+// Follow opens fileName itself and closes it before returning; Ident, Stat
+// and the exporter list hold the metadata read up to then. The appendix of a
+// 1.7.x file and the ident and stat blocks of a 1.8.x file are written when
+// the file is closed, so they are only available if Follow saw the close.
+// V1 files cannot be followed.
+func (nfFile *NfFile) Follow(ctx context.Context, fileName string, fn func(FlowRecord) error, options ...FollowOption) error {
+	if ctx == nil {
+		return fmt.Errorf("nfFile follow: nil context")
+	}
+	cfg := followConfig{poll: time.Second}
+	for _, option := range options {
+		if option != nil {
+			option(&cfg)
+		}
+	}
+	file, err := os.Open(fileName)
+	if err != nil {
+		return fmt.Errorf("nfFile Follow() on %s: %v", fileName, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("nfFile stat %s: %w", fileName, err)
+	}
+	f := &follower{file: file, name: fileName, info: info, poll: cfg.poll}
+
+	// A new file may not even hold its header yet.
+	size := info.Size()
+	for size < v18HeaderSize && !f.ended() {
+		if err := f.wait(ctx); err != nil {
+			file.Close()
+			return err
+		}
+		if size, err = f.size(); err != nil {
+			file.Close()
+			return fmt.Errorf("nfFile follow %s: %w", fileName, err)
+		}
+	}
+	src := source{ReaderAt: file, size: size, closer: file}
+	if err := nfFile.open(src, fileName, []OpenOption{followOpen}); err != nil {
+		return err
+	}
+	defer nfFile.Close()
+	return nfFile.walk(ctx, walkConfig{follow: f}, fn)
+}
+
+// followOpen makes open accept a file whose header and footer are not final
+// yet.
+func followOpen(opts *openOptions) {
+	opts.follow = true
+}
@@ -3,6 +3,7 @@ module github.com/phaag/go-nfdump
 go 1.24
 
 require (
+	github.com/dsnet/compress v0.0.1
 	github.com/klauspost/compress v1.19.2
 	github.com/pierrec/lz4/v4 v4.1.28
 	github.com/rasky/go-lzo v0.0.0-20200203143853-96a758eda86e
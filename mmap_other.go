@@ -0,0 +1,23 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+//go:build !linux
+
+package nfdump
+
+import (
+	"fmt"
+	"os"
+)
+
+// mapFile is only implemented on Linux. Open falls back to regular reads.
+func mapFile(file *os.File, size int64) ([]byte, error) {
+	return nil, fmt.Errorf("nfFile memory mapping is not supported on this platform")
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
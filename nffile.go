@@ -8,11 +8,17 @@
 package nfdump
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/binary"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"strings"
 	"sync"
+	"time"
 )
 
 type NfFile struct {
@@ -20,6 +26,7 @@ type NfFile struct {
 	stateMu               sync.Mutex
 	readCancel            context.CancelFunc
 	reader                fileReader
+	options               openOptions
 	walkContextCheckEvery uint32
 	// Header is the V1/V2 container header retained for compatibility. It is
 	// not populated for newer container layouts; use Info for new code.
@@ -28,6 +35,9 @@ type NfFile struct {
 	ident        string
 	StatRecord   StatRecord
 	ExporterList []Exporter
+	// exporterUpdates counts the exporter and sampler records added to
+	// ExporterList, so that Transcode notices new ones between two flows.
+	exporterUpdates uint64
 }
 
 const NOT_COMPRESSED = 0
@@ -123,6 +133,47 @@ type StatRecord struct {
 const TYPE_IDENT = 0x8001
 const TYPE_STAT = 0x8002
 
+// processMetadataRecords applies a sequence of non-flow records to the file
+// metadata. V2 appendix blocks and V3 metadata blocks share this record
+// encoding, so both generations fill ident, stat, exporter and sampler
+// information the same way. Unknown record types are skipped.
+func (nfFile *NfFile) processMetadataRecords(data []byte, numRecords uint32) error {
+	const headerSize = 4
+	offset := 0
+	for i := 0; i < int(numRecords); i++ {
+		if len(data)-offset < headerSize {
+			return fmt.Errorf("metadata record %d: truncated record header", i)
+		}
+		recordType := binary.LittleEndian.Uint16(data[offset : offset+2])
+		recordSize := int(binary.LittleEndian.Uint16(data[offset+2 : offset+4]))
+		if recordSize < headerSize || recordSize > len(data)-offset {
+			return fmt.Errorf("metadata record %d: invalid size %d", i, recordSize)
+		}
+		record := data[offset : offset+recordSize]
+		var err error
+		switch recordType {
+		case TYPE_IDENT:
+			nfFile.ident = strings.TrimRight(string(record[headerSize:]), "\x00")
+		case TYPE_STAT:
+			if recordSize-headerSize != binary.Size(StatRecord{}) {
+				return fmt.Errorf("metadata record %d: invalid stat size %d", i, recordSize)
+			}
+			err = binary.Read(bytes.NewReader(record[headerSize:]), binary.LittleEndian, &nfFile.StatRecord)
+		case ExporterInfoRecordType:
+			err = nfFile.addExporterInfo(record)
+		case ExporterStatRecordType:
+			err = nfFile.addExporterStat(record)
+		case SamplerRecordType:
+			err = nfFile.addSampler(record)
+		}
+		if err != nil {
+			return fmt.Errorf("metadata record %d: %w", i, err)
+		}
+		offset += recordSize
+	}
+	return nil
+}
+
 // New returns a new empty NfFile object
 func New() *NfFile {
 	return &NfFile{walkContextCheckEvery: 256}
@@ -144,72 +195,149 @@ func (nfFile *NfFile) String() string {
 	return s
 }
 
-// Open opens an nffile given as string argument
-func (nfFile *NfFile) Open(fileName string) error {
+// Open opens an nffile given as string argument. Options such as
+// WithKeyProvider apply to this file only.
+func (nfFile *NfFile) Open(fileName string, options ...OpenOption) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return fmt.Errorf("nfFile Open() on %s: %v", fileName, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("nfFile stat %s: %w", fileName, err)
+	}
+	if newOpenOptions(options).mmap {
+		// The mapping stays valid once the file is closed. If the file cannot
+		// be mapped, it is read with ReadAt instead.
+		if data, err := mapFile(file, info.Size()); err == nil {
+			file.Close()
+			src := source{ReaderAt: bytes.NewReader(data), size: info.Size(), closer: mapping(data), mapped: data}
+			return nfFile.open(src, fileName, options)
+		}
+	}
+	return nfFile.open(source{ReaderAt: file, size: info.Size(), closer: file}, fileName, options)
+}
+
+// OpenReaderAt opens a flow file of size bytes read through r. The caller
+// keeps ownership of r; Close does not close it, even if r implements
+// io.Closer.
+func (nfFile *NfFile) OpenReaderAt(r io.ReaderAt, size int64, options ...OpenOption) error {
+	if r == nil {
+		return fmt.Errorf("nfFile OpenReaderAt(): nil reader")
+	}
+	if size < 0 {
+		return fmt.Errorf("nfFile OpenReaderAt(): invalid size %d", size)
+	}
+	return nfFile.open(source{ReaderAt: r, size: size}, "reader", options)
+}
+
+// OpenFS opens the flow file name in fsys. Files that implement io.ReaderAt,
+// such as those of os.DirFS and embed.FS, are read in place; others are read
+// into memory first. Close closes the fs.File.
+func (nfFile *NfFile) OpenFS(fsys fs.FS, name string, options ...OpenOption) error {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return fmt.Errorf("nfFile OpenFS() on %s: %w", name, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("nfFile stat %s: %w", name, err)
+	}
+	if readerAt, ok := file.(io.ReaderAt); ok && info.Mode().IsRegular() {
+		return nfFile.open(source{ReaderAt: readerAt, size: info.Size(), closer: file}, name, options)
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("nfFile read %s: %w", name, err)
+	}
+	return nfFile.open(source{ReaderAt: bytes.NewReader(data), size: int64(len(data)), closer: file}, name, options)
+}
+
+// OpenBytes opens a flow file held in memory. data must not be modified
+// until the file is closed.
+func (nfFile *NfFile) OpenBytes(data []byte, options ...OpenOption) error {
+	return nfFile.open(source{ReaderAt: bytes.NewReader(data), size: int64(len(data))}, "memory", options)
+}
+
+// OpenStream opens a flow file read sequentially from r, such as os.Stdin or
+// a pipe. r is never seeked: V1/V2 files read the appendix after the data
+// blocks and apply it once the walk is done, and V3 files are read block by
+// block up to the directory, which is not needed. Ident, stat, exporter and
+// sampler metadata therefore become available only once the walk reaches
+// them, and Info().FlowBlocks is 0 for V3 streams. A stream can be read once; a second Walk, AllRecords or
+// ReadDataBlocks fails with ErrStreamConsumed. The caller keeps ownership of
+// r.
+func (nfFile *NfFile) OpenStream(r io.Reader, options ...OpenOption) error {
+	if r == nil {
+		return fmt.Errorf("nfFile OpenStream(): nil reader")
+	}
+	stream := &streamInput{r: bufio.NewReaderSize(r, 64*1024)}
+	return nfFile.open(source{size: -1, stream: stream}, "stream", options)
+}
+
+// open selects the reader backend for src. It takes ownership of src and
+// closes it on failure.
+func (nfFile *NfFile) open(src source, name string, options []OpenOption) error {
 	nfFile.cancelRead()
 	nfFile.readMu.Lock()
 	defer nfFile.readMu.Unlock()
 
 	if nfFile.reader != nil {
 		if err := nfFile.close(); err != nil {
+			src.close()
 			return fmt.Errorf("nfFile close previous file: %w", err)
 		}
 	}
 
-	file, err := os.Open(fileName)
-	if err != nil {
-		return fmt.Errorf("nfFile Open() on %s: %v", fileName, err)
-	}
-
-	var prefix struct {
-		Magic   uint16
-		Version uint16
-	}
-	if err = binary.Read(file, binary.LittleEndian, &prefix); err != nil {
-		file.Close()
-		return fmt.Errorf("nfFile read header on %s: %v", fileName, err)
+	prefix := make([]byte, 4)
+	if err := src.readPrefix(prefix); err != nil {
+		src.close()
+		return fmt.Errorf("nfFile read header on %s: %v", name, err)
 	}
-
-	if prefix.Magic != 0xA50C {
-		file.Close()
-		return fmt.Errorf("nfFile read header, bad magic : 0x%x", prefix.Magic)
+	order, ok := fileByteOrder(prefix[0:2])
+	if !ok {
+		src.close()
+		return fmt.Errorf("nfFile read header, bad magic : 0x%x", binary.LittleEndian.Uint16(prefix[0:2]))
 	}
+	version := order.Uint16(prefix[2:4])
 
+	nfFile.options = newOpenOptions(options)
 	nfFile.ExporterList = make([]Exporter, 8)
 	nfFile.Header = NfFileHeader{}
 	nfFile.info = FileInfo{}
 	nfFile.ident = ""
 	nfFile.StatRecord = StatRecord{}
 
-	switch prefix.Version {
+	var reader fileReader
+	var err error
+	switch version {
 	case 1:
-		reader, err := openV17ReaderV1(nfFile, file)
-		if err != nil {
-			file.Close()
-			return err
+		if nfFile.options.follow {
+			err = unsupportedError{operation: "Follow", layout: FileLayoutV1}
+			break
 		}
-		nfFile.reader = reader
-		return nil
+		reader, err = openV17ReaderV1(nfFile, src, order)
 	case 2:
-		reader, err := openV17ReaderV2(nfFile, file, fileName)
-		if err != nil {
-			file.Close()
-			return err
-		}
-		nfFile.reader = reader
-		return nil
+		reader, err = openV17ReaderV2(nfFile, src, name, order)
 	case 3:
-		reader, err := openV18Reader(nfFile, file, fileName)
-		if err != nil {
-			file.Close()
-			return err
+		if nfFile.options.follow {
+			reader, err = openV18FollowReader(nfFile, src, order)
+			break
 		}
-		nfFile.reader = reader
-		return nil
+		reader, err = openV18Reader(nfFile, src, order)
 	default:
-		file.Close()
-		return unsupportedError{operation: "open", layout: FileLayout(prefix.Version)}
+		err = unsupportedError{operation: "open", layout: FileLayout(version)}
+	}
+	if err != nil {
+		src.close()
+		return err
 	}
+	nfFile.info.NameTime = nameTime(name)
+	nfFile.reader = reader
+	return nil
 }
 
 // Closes the current underlaying file
@@ -266,7 +394,8 @@ func (nfFile *NfFile) Stat() StatRecord {
 }
 
 // ReadDataBlocks iterates over a V1/V2 file and decompresses its Type-3 data
-// blocks. It is a legacy, container-specific API; use Walk for new code.
+// blocks, and the Type-2 blocks of V1 files. It is a legacy,
+// container-specific API; use Walk for new code.
 func (nfFile *NfFile) ReadDataBlocks() (chan DataBlock, error) {
 	blockChannel := make(chan DataBlock, 16)
 	nfFile.readMu.Lock()
@@ -297,7 +426,8 @@ func (nfFile *NfFile) ReadDataBlocks() (chan DataBlock, error) {
 }
 
 // AllRecords is the legacy V1/V2 API. It reads Type-3 data blocks and converts
-// their V3 flow records into owned *FlowRecordV3 values. Use Walk for the
+// their V3 flow records into owned *FlowRecordV3 values. V1 flow records are
+// converted to the V3 layout first. Use Walk for the
 // format-neutral streaming API.
 func (nfFile *NfFile) AllRecords() *RecordChain {
 	recordChannel := make(chan *FlowRecordV3, 32)
@@ -345,7 +475,41 @@ func (nfFile *NfFile) AllRecords() *RecordChain {
 // compact views of the current block and must be copied with Clone before retaining
 // them after fn returns. Context cancellation is checked before each block and
 // at least once every 256 flow records within a block.
-func (nfFile *NfFile) Walk(ctx context.Context, fn func(FlowRecord) error) error {
+//
+// WithWorkers moves decompression to a pool of goroutines while records are
+// still delivered in file order, and WithBlocksInFlight bounds the number of
+// blocks read ahead. No worker is left running when Walk returns.
+func (nfFile *NfFile) Walk(ctx context.Context, fn func(FlowRecord) error, options ...WalkOption) error {
+	return nfFile.walk(ctx, newWalkConfig(options), fn)
+}
+
+// WalkRange is like Walk but delivers only the records whose MsecFirst..MsecLast
+// span overlaps the half-open window [from, to). Records without a generic flow
+// extension carry no times and are not delivered. V3 flow blocks written by
+// NfWriter record their first and last flow time, so blocks outside the window
+// are skipped; blocks stored uncompressed are skipped without being read at
+// all. V1/V2 files and V3 files written by other tools are scanned
+// sequentially. WalkRange accepts the same options as Walk.
+func (nfFile *NfFile) WalkRange(ctx context.Context, from, to time.Time, fn func(FlowRecord) error, options ...WalkOption) error {
+	if to.Before(from) {
+		return fmt.Errorf("nfFile walk range: end %v before start %v", to, from)
+	}
+	if fn == nil {
+		return fmt.Errorf("nfFile walk: nil callback")
+	}
+	cfg := newWalkConfig(options)
+	window := &timeWindow{from: uint64(max(from.UnixMilli(), 0)), to: uint64(max(to.UnixMilli(), 0))}
+	cfg.window = window
+	return nfFile.walk(ctx, cfg, func(record FlowRecord) error {
+		generic, ok := record.Generic()
+		if !ok || !window.overlaps(generic.MsecFirst, generic.MsecLast) {
+			return nil
+		}
+		return fn(record)
+	})
+}
+
+func (nfFile *NfFile) walk(ctx context.Context, cfg walkConfig, fn func(FlowRecord) error) error {
 	if ctx == nil {
 		return fmt.Errorf("nfFile walk: nil context")
 	}
@@ -354,20 +518,36 @@ func (nfFile *NfFile) Walk(ctx context.Context, fn func(FlowRecord) error) error
 	}
 
 	nfFile.readMu.Lock()
+	defer nfFile.readMu.Unlock()
+	return nfFile.walkLocked(ctx, cfg, fn)
+}
+
+// walkLocked walks the open file for walk and Verify, which hold readMu.
+func (nfFile *NfFile) walkLocked(ctx context.Context, cfg walkConfig, fn func(FlowRecord) error) error {
 	if nfFile.reader == nil {
-		nfFile.readMu.Unlock()
 		return fmt.Errorf("nfFile walk: no open file")
 	}
 
 	walkCtx, cancel := context.WithCancel(ctx)
 	nfFile.setReadCancel(cancel)
 	reader := nfFile.reader
-	walkErr := reader.walk(walkCtx, cancel, fn)
+	if cfg.salvage != nil {
+		userFn := fn
+		fn = func(record FlowRecord) error {
+			if err := userFn(record); err != nil {
+				return callbackError{err}
+			}
+			return nil
+		}
+	}
+	walkErr := reader.walk(walkCtx, cancel, cfg, fn)
 	cancel()
 	nfFile.clearReadCancel()
-	nfFile.readMu.Unlock()
 
 	if walkErr != nil {
+		if callbackErr, ok := walkErr.(callbackError); ok {
+			return callbackErr.err
+		}
 		return walkErr
 	}
 	if err := ctx.Err(); err != nil {
@@ -9,8 +9,6 @@ package nfdump
 import (
 	"encoding/binary"
 	"fmt"
-	"io"
-	"os"
 )
 
 const (
@@ -75,18 +73,19 @@ type statRecordV1 struct {
 	SequenceFailure uint32
 }
 
-func openV17ReaderV1(owner *NfFile, file *os.File) (*v17Reader, error) {
+func openV17ReaderV1(owner *NfFile, src source, order binary.ByteOrder) (*v17Reader, error) {
 
 	var nfFileV1Header NfFileHeaderV1
 	var statRecordV1 statRecordV1
 
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return nil, fmt.Errorf("nfFile seek V1 header: %w", err)
+	file, err := src.reader(0)
+	if err != nil {
+		return nil, fmt.Errorf("nfFile read V1 header: %w", err)
 	}
-	if err := binary.Read(file, binary.LittleEndian, &nfFileV1Header); err != nil {
+	if err := binary.Read(file, order, &nfFileV1Header); err != nil {
 		return nil, fmt.Errorf("nfFile read V1 header: %w", err)
 	}
-	if err := binary.Read(file, binary.LittleEndian, &statRecordV1); err != nil {
+	if err := binary.Read(file, order, &statRecordV1); err != nil {
 		return nil, fmt.Errorf("nfFile read V1 stats: %w", err)
 	}
 
@@ -141,7 +140,9 @@ func openV17ReaderV1(owner *NfFile, file *os.File) (*v17Reader, error) {
 		NfdumpVersion: header.NfVersion,
 		Compression:   Compression(header.Compression),
 		FlowBlocks:    header.NumBlocks,
+		BigEndian:     order == binary.BigEndian,
 	}
 
-	return &v17Reader{owner: owner, file: file, header: header}, nil
+	dataOffset := int64(binary.Size(nfFileV1Header) + binary.Size(statRecordV1))
+	return &v17Reader{owner: owner, src: src, header: header, dataOffset: dataOffset, order: order}, nil
 }
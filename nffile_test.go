@@ -1,16 +1,28 @@
 package nfdump
 
 import (
+	"bytes"
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
 	"net/netip"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 	"testing"
+	"testing/fstest"
 	"time"
 	"unsafe"
+
+	zstd "github.com/klauspost/compress/zstd"
 )
 
 func writeV2File(t *testing.T, header NfFileHeader, blocks ...[]byte) string {
@@ -78,11 +90,6 @@ func v3Record(size uint16) []byte {
 	return record
 }
 
-type v3Element struct {
-	id   uint16
-	data []byte
-}
-
 func v3RecordWithElements(elements ...v3Element) []byte {
 	size := 12
 	for _, element := range elements {
@@ -164,26 +171,38 @@ func v18FlowBlock(record []byte) []byte {
 	return block
 }
 
-func writeV3File(t *testing.T, block []byte) string {
+func writeV3File(t *testing.T, blocks ...[]byte) string {
 	t.Helper()
-	directoryOffset := uint64(v18HeaderSize + len(block))
-	directory := make([]byte, v18DirectoryHead+v18DirectoryEnt)
+	directoryOffset := uint64(v18HeaderSize)
+	for _, block := range blocks {
+		directoryOffset += uint64(len(block))
+	}
+	directory := make([]byte, v18DirectoryHead+len(blocks)*v18DirectoryEnt)
 	binary.LittleEndian.PutUint32(directory[0:4], v18DirectoryMagic)
-	binary.LittleEndian.PutUint32(directory[4:8], 1)
-	binary.LittleEndian.PutUint32(directory[8:12], v18BlockFlow)
-	binary.LittleEndian.PutUint32(directory[12:16], uint32(len(block)))
-	binary.LittleEndian.PutUint64(directory[16:24], v18HeaderSize)
+	binary.LittleEndian.PutUint32(directory[4:8], uint32(len(blocks)))
+	blockOffset := uint64(v18HeaderSize)
+	for i, block := range blocks {
+		entry := directory[v18DirectoryHead+i*v18DirectoryEnt:]
+		binary.LittleEndian.PutUint32(entry[0:4], binary.LittleEndian.Uint32(block[0:4]))
+		binary.LittleEndian.PutUint32(entry[4:8], uint32(len(block)))
+		binary.LittleEndian.PutUint64(entry[8:16], blockOffset)
+		blockOffset += uint64(len(block))
+	}
 
-	fileData := make([]byte, v18HeaderSize+len(block)+len(directory)+v18FooterSize)
+	fileData := make([]byte, int(directoryOffset)+len(directory)+v18FooterSize)
 	binary.LittleEndian.PutUint16(fileData[0:2], 0xA50C)
 	binary.LittleEndian.PutUint16(fileData[2:4], 3)
 	binary.LittleEndian.PutUint32(fileData[4:8], 0x10800)
 	binary.LittleEndian.PutUint64(fileData[8:16], 123456789)
 	binary.LittleEndian.PutUint16(fileData[18:20], 1) // NOT_COMPRESSED
+	binary.LittleEndian.PutUint32(fileData[20:24], v18FlagPackageLayout)
 	binary.LittleEndian.PutUint32(fileData[24:28], 1024)
 	binary.LittleEndian.PutUint32(fileData[28:32], uint32(len(directory)))
 	binary.LittleEndian.PutUint64(fileData[32:40], directoryOffset)
-	copy(fileData[v18HeaderSize:], block)
+	offset := v18HeaderSize
+	for _, block := range blocks {
+		offset += copy(fileData[offset:], block)
+	}
 	copy(fileData[directoryOffset:], directory)
 	footerOffset := len(fileData) - v18FooterSize
 	binary.LittleEndian.PutUint32(fileData[footerOffset:footerOffset+4], v18FooterMagic)
@@ -198,6 +217,52 @@ func writeV3File(t *testing.T, block []byte) string {
 	return path
 }
 
+// v18MetaBlock builds an uncompressed V3 metadata block from complete
+// type/size records.
+func v18MetaBlock(typeID uint32, records ...[]byte) []byte {
+	block := make([]byte, v18MetaBlockHead)
+	for _, record := range records {
+		block = append(block, record...)
+	}
+	binary.LittleEndian.PutUint32(block[0:4], typeID)
+	binary.LittleEndian.PutUint32(block[4:8], uint32(len(block)))
+	binary.LittleEndian.PutUint32(block[8:12], uint32(len(block)))
+	binary.LittleEndian.PutUint16(block[12:14], 1) // NOT_COMPRESSED
+	binary.LittleEndian.PutUint32(block[24:28], uint32(len(records)))
+	binary.LittleEndian.PutUint64(block[16:24], v3Checksum64(block[v18BlockHeader:]))
+	return block
+}
+
+func metadataRecord(recordType uint16, payload []byte) []byte {
+	record := make([]byte, 4+len(payload))
+	binary.LittleEndian.PutUint16(record[0:2], recordType)
+	binary.LittleEndian.PutUint16(record[2:4], uint16(len(record)))
+	copy(record[4:], payload)
+	return record
+}
+
+func exporterInfoRecord(sysID uint16, id uint32, ipv4 [4]byte) []byte {
+	record := make([]byte, 32)
+	binary.LittleEndian.PutUint16(record[0:2], ExporterInfoRecordType)
+	binary.LittleEndian.PutUint16(record[2:4], 32)
+	binary.LittleEndian.PutUint32(record[4:8], 10)
+	record[16], record[17], record[18], record[19] = ipv4[3], ipv4[2], ipv4[1], ipv4[0]
+	binary.LittleEndian.PutUint16(record[24:26], 2) // AF_INET
+	binary.LittleEndian.PutUint16(record[26:28], sysID)
+	binary.LittleEndian.PutUint32(record[28:32], id)
+	return record
+}
+
+func samplerRecord(sysID uint16, id int64, packetInterval uint32) []byte {
+	record := make([]byte, 24)
+	binary.LittleEndian.PutUint16(record[0:2], SamplerRecordType)
+	binary.LittleEndian.PutUint16(record[2:4], 24)
+	binary.LittleEndian.PutUint16(record[4:6], sysID)
+	binary.LittleEndian.PutUint64(record[8:16], uint64(id))
+	binary.LittleEndian.PutUint32(record[16:20], packetInterval)
+	return record
+}
+
 func TestAllRecordsHonorsUncompressedBlockFlag(t *testing.T) {
 	path := writeV2File(t, v2Header(LZ4_COMPRESSED, 1), flowBlock(t, flagBlockUncompressed, v3Record(12)))
 	nf := New()
@@ -378,6 +443,2703 @@ func TestWalkReadsV3ContainerV4Records(t *testing.T) {
 	}
 }
 
+func TestV3ContainerMetadataBlocks(t *testing.T) {
+	stat := StatRecord{Numflows: 1, Numpackets: 42, Numbytes: 4096, FirstSeen: 1000, LastSeen: 2000}
+	statPayload := make([]byte, binary.Size(stat))
+	writer := sliceWriter(statPayload)
+	if err := binary.Write(&writer, binary.LittleEndian, &stat); err != nil {
+		t.Fatal(err)
+	}
+	exporterStat := make([]byte, 8+24)
+	binary.LittleEndian.PutUint16(exporterStat[0:2], ExporterStatRecordType)
+	binary.LittleEndian.PutUint16(exporterStat[2:4], uint16(len(exporterStat)))
+	binary.LittleEndian.PutUint32(exporterStat[4:8], 1)
+	binary.LittleEndian.PutUint32(exporterStat[8:12], 1)
+	binary.LittleEndian.PutUint64(exporterStat[16:24], 42)
+	binary.LittleEndian.PutUint64(exporterStat[24:32], 1)
+
+	record := v4RecordWithElements(t, 0, 1, v4Element{id: 1, data: make([]byte, 48)})
+	nf := New()
+	path := writeV3File(t,
+		v18MetaBlock(v18BlockIdent, metadataRecord(TYPE_IDENT, []byte("router-1\x00\x00\x00\x00"))),
+		v18MetaBlock(v18BlockExporter, exporterInfoRecord(1, 7, [4]byte{192, 0, 2, 1})),
+		v18MetaBlock(v18BlockSampler, samplerRecord(1, 5, 100)),
+		v18FlowBlock(record),
+		v18MetaBlock(v18BlockExporterStat, exporterStat),
+		v18MetaBlock(v18BlockStat, metadataRecord(TYPE_STAT, statPayload)),
+	)
+	if err := nf.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer nf.Close()
+
+	if nf.Ident() != "router-1" {
+		t.Fatalf("got ident %q, want router-1", nf.Ident())
+	}
+	if nf.Stat() != stat {
+		t.Fatalf("got stat %#v, want %#v", nf.Stat(), stat)
+	}
+	if info := nf.Info(); info.FlowBlocks != 1 {
+		t.Fatalf("got %d flow blocks, want 1", info.FlowBlocks)
+	}
+	count := 0
+	if err := nf.Walk(context.Background(), func(FlowRecord) error {
+		count++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("got %d records, want 1", count)
+	}
+	exporter := nf.GetExporterList()[1]
+	if exporter.IP.String() != "192.0.2.1" || exporter.Id != 7 || exporter.Packets != 42 || exporter.Flows != 1 {
+		t.Fatalf("unexpected exporter: %#v", exporter)
+	}
+	if len(exporter.SamplerList) != 1 || exporter.SamplerList[0].PacketInterval != 100 {
+		t.Fatalf("unexpected samplers: %#v", exporter.SamplerList)
+	}
+
+	// Without the package layout flag the metadata blocks are skipped, and
+	// Verify does not miss the stat record it cannot read.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint32(data[20:24], 0)
+	foreign := New()
+	if err := foreign.OpenBytes(data); err != nil {
+		t.Fatal(err)
+	}
+	defer foreign.Close()
+	if foreign.Ident() != "" || foreign.Stat() != (StatRecord{}) {
+		t.Fatalf("got ident %q and stat %+v", foreign.Ident(), foreign.Stat())
+	}
+	report, err := foreign.Verify(context.Background())
+	if err != nil || !report.OK() || report.Records != 1 || foreign.GetExporterList()[1].IP != nil {
+		t.Fatalf("got report %+v: %v", report, err)
+	}
+	stream := New()
+	if err := stream.OpenStream(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+	count = 0
+	if err := stream.Walk(context.Background(), func(FlowRecord) error {
+		count++
+		return nil
+	}); err != nil || count != 1 || stream.Ident() != "" || stream.GetExporterList()[1].IP != nil {
+		t.Fatalf("streamed %d records, ident %q: %v", count, stream.Ident(), err)
+	}
+}
+
+func TestV2AppendixIdentIsTrimmed(t *testing.T) {
+	// nfcapd pads the ident record of the appendix with NUL bytes; the
+	// V2 appendix shares processMetadataRecords with V3 metadata blocks.
+	data := flowBlock(t, 0, v3Record(12))
+	header := v2Header(NOT_COMPRESSED, 1)
+	header.AppendixBlocks = 1
+	header.OffAppendix = uint64(binary.Size(header) + len(data))
+	appendix := flowBlock(t, 0, metadataRecord(TYPE_IDENT, []byte("router-1\x00\x00\x00\x00")))
+	nf := New()
+	if err := nf.Open(writeV2File(t, header, data, appendix)); err != nil {
+		t.Fatal(err)
+	}
+	defer nf.Close()
+	if nf.Ident() != "router-1" {
+		t.Fatalf("got ident %q, want router-1", nf.Ident())
+	}
+}
+
+// encryptV18Block encrypts an uncompressed V3 block in place of its payload
+// using AES-GCM with the block header as additional data.
+func encryptV18Block(t *testing.T, key, block []byte) []byte {
+	t.Helper()
+	cipherBlock, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(cipherBlock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := make([]byte, v18BlockHeader, len(block)+aead.NonceSize()+aead.Overhead())
+	copy(encrypted, block[:v18BlockHeader])
+	binary.LittleEndian.PutUint32(encrypted[4:8], uint32(cap(encrypted)))
+	binary.LittleEndian.PutUint16(encrypted[14:16], v18EncryptionAESGCM)
+	nonce := make([]byte, aead.NonceSize())
+	nonce[0] = 1
+	encrypted = append(encrypted, nonce...)
+	encrypted = aead.Seal(encrypted, nonce, block[v18BlockHeader:], encrypted[:16])
+	binary.LittleEndian.PutUint64(encrypted[16:24], v3Checksum64(encrypted[v18BlockHeader:]))
+	return encrypted
+}
+
+func writeEncryptedV3File(t *testing.T, key []byte, keyCheck bool, blocks ...[]byte) string {
+	t.Helper()
+	for i := range blocks {
+		blocks[i] = encryptV18Block(t, key, blocks[i])
+	}
+	path := writeV3File(t, blocks...)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint32(data[20:24], v18FlagPackageLayout|v18FlagEncrypted)
+	if keyCheck {
+		cipherBlock, _ := aes.NewCipher(key)
+		binary.LittleEndian.PutUint64(data[40:48], v18KeyCheck(cipherBlock))
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestWalkEncryptedV3Container(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	record := v4RecordWithElements(t, 0, 1, v4Element{id: 1, data: make([]byte, 48)})
+	path := writeEncryptedV3File(t, key, true,
+		v18MetaBlock(v18BlockIdent, metadataRecord(TYPE_IDENT, []byte("secret"))),
+		v18FlowBlock(record))
+
+	nf := New()
+	if err := nf.Open(path); !errors.Is(err, ErrKeyRequired) {
+		t.Fatalf("got error %v, want ErrKeyRequired", err)
+	}
+	wrongKey := KeyProviderFunc(func(FileInfo) ([]byte, error) { return []byte("fedcba9876543210fedcba9876543210"), nil })
+	if err := nf.Open(path, WithKeyProvider(wrongKey)); !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("got error %v, want ErrWrongKey", err)
+	}
+	provider := KeyProviderFunc(func(info FileInfo) ([]byte, error) {
+		if !info.Encrypted {
+			t.Fatal("key requested for unencrypted file")
+		}
+		return key, nil
+	})
+	if err := nf.Open(path, WithKeyProvider(provider)); err != nil {
+		t.Fatal(err)
+	}
+	defer nf.Close()
+	if nf.Ident() != "secret" || !nf.Info().Encrypted {
+		t.Fatalf("got ident %q, encrypted %t", nf.Ident(), nf.Info().Encrypted)
+	}
+	count := 0
+	if err := nf.Walk(context.Background(), func(FlowRecord) error {
+		count++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("got %d records, want 1", count)
+	}
+}
+
+func TestWalkMemoryMappedV3Container(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	provider := KeyProviderFunc(func(FileInfo) ([]byte, error) { return key, nil })
+	record := v4RecordWithElements(t, 0, 1, v4Element{id: 1, data: make([]byte, 48)})
+	blocks := func() [][]byte {
+		return [][]byte{v18MetaBlock(v18BlockIdent, metadataRecord(TYPE_IDENT, []byte("mapped"))), v18FlowBlock(record), v18FlowBlock(record)}
+	}
+
+	for _, test := range []struct {
+		name string
+		path string
+	}{
+		{"plain", writeV3File(t, blocks()...)},
+		{"encrypted", writeEncryptedV3File(t, key, true, blocks()...)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			nf := New()
+			if err := nf.Open(test.path, WithMmap(), WithKeyProvider(provider)); err != nil {
+				t.Fatal(err)
+			}
+			if mapped := nf.reader.(*v18Reader).src.mapped != nil; mapped != (runtime.GOOS == "linux") {
+				t.Fatalf("got mapped %t on %s", mapped, runtime.GOOS)
+			}
+			count := 0
+			if err := nf.Walk(context.Background(), func(FlowRecord) error {
+				count++
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if count != 2 || nf.Ident() != "mapped" {
+				t.Fatalf("got %d records, ident %q", count, nf.Ident())
+			}
+			if err := nf.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestWalkEncryptedV3ReportsAuthenticationFailure(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	record := v4RecordWithElements(t, 0, 1, v4Element{id: 1, data: make([]byte, 48)})
+	path := writeEncryptedV3File(t, key, false, v18FlowBlock(record))
+
+	nf := New()
+	wrongKey := KeyProviderFunc(func(FileInfo) ([]byte, error) { return []byte("fedcba9876543210"), nil })
+	if err := nf.Open(path, WithKeyProvider(wrongKey)); err != nil {
+		t.Fatal(err)
+	}
+	defer nf.Close()
+	err := nf.Walk(context.Background(), func(FlowRecord) error { return nil })
+	if !errors.Is(err, ErrAuthentication) {
+		t.Fatalf("got error %v, want ErrAuthentication", err)
+	}
+}
+
+func writeV1File(t *testing.T, blocks ...[]byte) string {
+	t.Helper()
+	var buf bytes.Buffer
+	header := NfFileHeaderV1{Magic: 0xA50C, Version: 1, NumBlocks: uint32(len(blocks))}
+	copy(header.Ident[:], "legacy")
+	if err := binary.Write(&buf, binary.LittleEndian, &header); err != nil {
+		t.Fatal(err)
+	}
+	if err := binary.Write(&buf, binary.LittleEndian, &statRecordV1{Numflows: 1}); err != nil {
+		t.Fatal(err)
+	}
+	for _, block := range blocks {
+		buf.Write(block)
+	}
+	path := filepath.Join(t.TempDir(), "flows-v1.nf")
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestWalkDecodesV1Records(t *testing.T) {
+	extensionMap := []byte{2, 0, 20, 0, 1, 0, 20, 0, 4, 0, 7, 0, 13, 0, 11, 0, 27, 0, 0, 0}
+	record := make([]byte, 32, 80)
+	binary.LittleEndian.PutUint16(record[0:2], v1CommonRecordType)
+	binary.LittleEndian.PutUint16(record[4:6], v1FlagPkg64|v1FlagSampled)
+	binary.LittleEndian.PutUint16(record[6:8], 1)    // extension map
+	binary.LittleEndian.PutUint16(record[8:10], 250) // msec first
+	binary.LittleEndian.PutUint32(record[12:16], 1700000000)
+	binary.LittleEndian.PutUint32(record[16:20], 1700000001)
+	record[21], record[22] = 0x12, 6
+	binary.LittleEndian.PutUint16(record[24:26], 12345)
+	binary.LittleEndian.PutUint16(record[26:28], 443)
+	binary.LittleEndian.PutUint16(record[28:30], 5)
+	record = append(record, 1, 2, 0, 192, 8, 8, 8, 8) // IPv4 addresses
+	record = binary.LittleEndian.AppendUint64(record, 42)
+	record = binary.LittleEndian.AppendUint32(record, 4096)
+	record = append(record, 3, 0, 4, 0)                      // SNMP input/output
+	record = append(record, 0xe9, 0xfd, 0, 0, 0x3d, 0, 0, 0) // AS 65001 -> 61
+	record = append(record, 10, 0, 20, 0)                    // VLAN
+	record = append(record, 1, 0, 0, 10)                     // BGP next hop
+	record = binary.LittleEndian.AppendUint64(record, 1700000002000)
+	binary.LittleEndian.PutUint16(record[2:4], uint16(len(record)))
+
+	block := flowBlock(t, 0, extensionMap, record)
+	binary.LittleEndian.PutUint16(block[8:10], 2)
+	path := writeV1File(t, block)
+	nf := New()
+	if err := nf.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer nf.Close()
+
+	count := 0
+	err := nf.Walk(context.Background(), func(flow FlowRecord) error {
+		count++
+		generic, ok := flow.Generic()
+		if !ok || generic.MsecFirst != 1700000000250 || generic.MsecLast != 1700000001000 ||
+			generic.MsecReceived != 1700000002000 || generic.InPackets != 42 || generic.InBytes != 4096 ||
+			generic.SrcPort != 12345 || generic.DstPort != 443 || generic.Proto != 6 || generic.TcpFlags != 0x12 {
+			t.Fatalf("unexpected V1 generic flow: %#v, ok=%t", generic, ok)
+		}
+		src, dst, ok := flow.IP()
+		if !ok || src != netip.MustParseAddr("192.0.2.1") || dst != netip.MustParseAddr("8.8.8.8") {
+			t.Fatalf("unexpected V1 addresses: %v %v %t", src, dst, ok)
+		}
+		if flow.ExporterID() != 5 || flow.Flags() != uint16(V3_FLAG_SAMPLED) {
+			t.Fatalf("unexpected V1 header fields")
+		}
+		if as := flow.Extension(ExtensionASRouting); len(as) != 8 || binary.LittleEndian.Uint32(as[0:4]) != 65001 {
+			t.Fatalf("unexpected V1 AS extension %v", as)
+		}
+		if vlan := flow.Extension(ExtensionVLAN); len(vlan) != 8 || binary.LittleEndian.Uint32(vlan[4:8]) != 20 {
+			t.Fatalf("unexpected V1 VLAN extension %v", vlan)
+		}
+		if misc := flow.Extension(ExtensionFlowMisc); len(misc) != 16 || binary.LittleEndian.Uint32(misc[4:8]) != 4 {
+			t.Fatalf("unexpected V1 misc extension %v", misc)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("got %d records, want 1", count)
+	}
+
+	legacyFile := New()
+	if err := legacyFile.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer legacyFile.Close()
+	chain := legacyFile.AllRecords()
+	records, err := chain.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for legacy := range records {
+		if hop := legacy.BgpNextHop(); hop == nil || hop.IP.String() != "10.0.0.1" {
+			t.Fatalf("unexpected legacy BGP next hop %v", hop)
+		}
+	}
+	if err := chain.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenFromMemoryAndFS(t *testing.T) {
+	v2Data, err := os.ReadFile(writeV2File(t, v2Header(NOT_COMPRESSED, 1), flowBlock(t, 0, v3Record(12), v3Record(12))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := v4RecordWithElements(t, 0, 1, v4Element{id: 1, data: make([]byte, 48)})
+	v3Data, err := os.ReadFile(writeV3File(t, v18FlowBlock(record)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := fstest.MapFS{"flows/nfcapd.202601010000": &fstest.MapFile{Data: v2Data}}
+
+	for _, test := range []struct {
+		name  string
+		open  func(*NfFile) error
+		count int
+	}{
+		{"bytes", func(nf *NfFile) error { return nf.OpenBytes(v3Data) }, 1},
+		{"reader-at", func(nf *NfFile) error { return nf.OpenReaderAt(bytes.NewReader(v2Data), int64(len(v2Data))) }, 2},
+		{"fs", func(nf *NfFile) error { return nf.OpenFS(fsys, "flows/nfcapd.202601010000") }, 2},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			nf := New()
+			if err := test.open(nf); err != nil {
+				t.Fatal(err)
+			}
+			defer nf.Close()
+			// A second walk starts again at the first block.
+			for pass := 0; pass < 2; pass++ {
+				count := 0
+				if err := nf.Walk(context.Background(), func(FlowRecord) error {
+					count++
+					return nil
+				}); err != nil {
+					t.Fatal(err)
+				}
+				if count != test.count {
+					t.Fatalf("pass %d: got %d records, want %d", pass, count, test.count)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenStreamReadsSequentially(t *testing.T) {
+	stat := StatRecord{Numflows: 2, Numpackets: 42}
+	statPayload := make([]byte, binary.Size(stat))
+	writer := sliceWriter(statPayload)
+	if err := binary.Write(&writer, binary.LittleEndian, &stat); err != nil {
+		t.Fatal(err)
+	}
+	ident := metadataRecord(TYPE_IDENT, []byte("router-1"))
+
+	data := flowBlock(t, 0, v3Record(12), v3Record(12))
+	header := v2Header(NOT_COMPRESSED, 1)
+	header.AppendixBlocks = 1
+	header.OffAppendix = uint64(binary.Size(header) + len(data))
+	v2Data, err := os.ReadFile(writeV2File(t, header, data, flowBlock(t, 0, ident, metadataRecord(TYPE_STAT, statPayload))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := v4RecordWithElements(t, 0, 1, v4Element{id: 1, data: make([]byte, 48)})
+	v3Data, err := os.ReadFile(writeV3File(t,
+		v18MetaBlock(v18BlockIdent, ident),
+		v18MetaBlock(v18BlockExporter, exporterInfoRecord(1, 7, [4]byte{192, 0, 2, 1})),
+		v18FlowBlock(record),
+		v18MetaBlock(v18BlockStat, metadataRecord(TYPE_STAT, statPayload)),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name  string
+		data  []byte
+		count int
+		// ident is seen by the callback. The V2 appendix follows the
+		// flows and is applied once the walk is done.
+		ident string
+	}{
+		{"V2", v2Data, 2, ""},
+		{"V3", v3Data, 1, "router-1"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			nf := New()
+			// Hide io.ReaderAt and io.Seeker so only sequential reads work.
+			if err := nf.OpenStream(struct{ io.Reader }{bytes.NewReader(test.data)}); err != nil {
+				t.Fatal(err)
+			}
+			defer nf.Close()
+			count := 0
+			if err := nf.Walk(context.Background(), func(FlowRecord) error {
+				if nf.Ident() != test.ident {
+					return fmt.Errorf("got ident %q during walk", nf.Ident())
+				}
+				count++
+				return nil
+			}, WithWorkers(2)); err != nil {
+				t.Fatal(err)
+			}
+			if count != test.count {
+				t.Fatalf("got %d records, want %d", count, test.count)
+			}
+			if nf.Ident() != "router-1" || nf.Stat() != stat {
+				t.Fatalf("got ident %q stat %#v after walk", nf.Ident(), nf.Stat())
+			}
+			err := nf.Walk(context.Background(), func(FlowRecord) error { return nil })
+			if !errors.Is(err, ErrStreamConsumed) {
+				t.Fatalf("second walk: got %v, want ErrStreamConsumed", err)
+			}
+		})
+	}
+}
+
+// genericTimes returns a generic flow extension payload spanning first..last.
+func genericTimes(first, last uint64) []byte {
+	generic := make([]byte, 48)
+	binary.LittleEndian.PutUint64(generic[0:8], first)
+	binary.LittleEndian.PutUint64(generic[8:16], last)
+	return generic
+}
+
+func TestWalkRangeSkipsBlocksAndFiltersRecords(t *testing.T) {
+	from, to := time.UnixMilli(4000), time.UnixMilli(7000)
+	collect := func(t *testing.T, nf *NfFile) []uint64 {
+		t.Helper()
+		var firsts []uint64
+		if err := nf.WalkRange(context.Background(), from, to, func(record FlowRecord) error {
+			generic, _ := record.Generic()
+			firsts = append(firsts, generic.MsecFirst)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return firsts
+	}
+	v4Block := func(first, last, recordFirst, recordLast uint64) []byte {
+		block := v18FlowBlock(v4RecordWithElements(t, 0, 1, v4Element{id: 1, data: genericTimes(recordFirst, recordLast)}))
+		binary.LittleEndian.PutUint64(block[v18FlowBlockFirst:], first)
+		binary.LittleEndian.PutUint64(block[v18FlowBlockLast:], last)
+		binary.LittleEndian.PutUint64(block[16:24], v3Checksum64(block[v18BlockHeader:]))
+		return block
+	}
+
+	t.Run("V3", func(t *testing.T) {
+		outside := v4Block(1000, 2000, 1000, 2000)
+		// Corrupt the record area after the checksum: the block must be
+		// skipped without being read.
+		outside[len(outside)-1] ^= 0xff
+		path := writeV3File(t,
+			outside,
+			v4Block(5000, 9000, 5000, 6000),
+			v4Block(0, 0, 6500, 8000), // no block bounds
+			v4Block(0, 0, 7000, 8000), // starts at the exclusive end
+		)
+		nf := New()
+		if err := nf.Open(path); err != nil {
+			t.Fatal(err)
+		}
+		defer nf.Close()
+		if got := collect(t, nf); len(got) != 2 || got[0] != 5000 || got[1] != 6500 {
+			t.Fatalf("got flows starting at %v, want [5000 6500]", got)
+		}
+	})
+
+	t.Run("V3 not written by this package", func(t *testing.T) {
+		// The block bounds are only trusted in files of this package, so
+		// every record is filtered on its own.
+		data, err := os.ReadFile(writeV3File(t,
+			v4Block(1000, 2000, 5000, 6000),
+			v4Block(1000, 2000, 1000, 2000),
+		))
+		if err != nil {
+			t.Fatal(err)
+		}
+		binary.LittleEndian.PutUint32(data[20:24], 0)
+		nf := New()
+		if err := nf.OpenBytes(data); err != nil {
+			t.Fatal(err)
+		}
+		defer nf.Close()
+		if got := collect(t, nf); len(got) != 1 || got[0] != 5000 {
+			t.Fatalf("got flows starting at %v, want [5000]", got)
+		}
+	})
+
+	t.Run("V2", func(t *testing.T) {
+		path := writeV2File(t, v2Header(NOT_COMPRESSED, 1), flowBlock(t, 0,
+			v3RecordWithElements(v3Element{id: EXgenericFlowID, data: genericTimes(1000, 3999)}),
+			v3RecordWithElements(v3Element{id: EXgenericFlowID, data: genericTimes(3000, 4000)}),
+			v3Record(12), // no generic flow extension
+			v3RecordWithElements(v3Element{id: EXgenericFlowID, data: genericTimes(6999, 9000)}),
+		))
+		nf := New()
+		if err := nf.Open(path); err != nil {
+			t.Fatal(err)
+		}
+		defer nf.Close()
+		if got := collect(t, nf); len(got) != 2 || got[0] != 3000 || got[1] != 6999 {
+			t.Fatalf("got flows starting at %v, want [3000 6999]", got)
+		}
+	})
+}
+
+// zstdV2Block compresses the payload of an uncompressed V2 data block.
+func zstdV2Block(t *testing.T, block []byte) []byte {
+	t.Helper()
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer encoder.Close()
+	compressed := encoder.EncodeAll(block[12:], append([]byte(nil), block[:12]...))
+	binary.LittleEndian.PutUint32(compressed[4:8], uint32(len(compressed)-12))
+	return compressed
+}
+
+// zstdV18Block compresses the payload of an uncompressed V3 block.
+func zstdV18Block(t *testing.T, block []byte) []byte {
+	t.Helper()
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer encoder.Close()
+	compressed := encoder.EncodeAll(block[v18BlockHeader:], append([]byte(nil), block[:v18BlockHeader]...))
+	binary.LittleEndian.PutUint32(compressed[4:8], uint32(len(compressed)))
+	binary.LittleEndian.PutUint16(compressed[12:14], 5) // ZSTD_COMPRESSED
+	binary.LittleEndian.PutUint64(compressed[16:24], v3Checksum64(compressed[v18BlockHeader:]))
+	return compressed
+}
+
+func TestWalkWithWorkersKeepsFileOrder(t *testing.T) {
+	const numBlocks, perBlock = 24, 3
+	var v2Blocks, v3Blocks [][]byte
+	for i := 0; i < numBlocks; i++ {
+		var v2Records [][]byte
+		v3Block := make([]byte, v18FlowBlockHead)
+		for j := 0; j < perBlock; j++ {
+			first := uint64(i*perBlock + j + 1)
+			v2Records = append(v2Records, v3RecordWithElements(v3Element{id: EXgenericFlowID, data: genericTimes(first, first)}))
+			v3Block = append(v3Block, v4RecordWithElements(t, 0, 1, v4Element{id: 1, data: genericTimes(first, first)})...)
+		}
+		v2Blocks = append(v2Blocks, zstdV2Block(t, flowBlock(t, 0, v2Records...)))
+		binary.LittleEndian.PutUint32(v3Block[0:4], v18BlockFlow)
+		binary.LittleEndian.PutUint32(v3Block[4:8], uint32(len(v3Block)))
+		binary.LittleEndian.PutUint32(v3Block[8:12], uint32(len(v3Block)))
+		binary.LittleEndian.PutUint32(v3Block[24:28], perBlock)
+		v3Blocks = append(v3Blocks, zstdV18Block(t, v3Block))
+	}
+	paths := map[string]string{
+		"V2": writeV2File(t, v2Header(ZSTD_COMPRESSED, numBlocks), v2Blocks...),
+		"V3": writeV3File(t, v3Blocks...),
+	}
+
+	for name, path := range paths {
+		t.Run(name, func(t *testing.T) {
+			nf := New()
+			if err := nf.Open(path); err != nil {
+				t.Fatal(err)
+			}
+			defer nf.Close()
+			next := uint64(1)
+			if err := nf.Walk(context.Background(), func(record FlowRecord) error {
+				generic, _ := record.Generic()
+				if generic.MsecFirst != next {
+					return fmt.Errorf("got flow %d, want %d", generic.MsecFirst, next)
+				}
+				next++
+				return nil
+			}, WithWorkers(4), WithBlocksInFlight(3)); err != nil {
+				t.Fatal(err)
+			}
+			if next != numBlocks*perBlock+1 {
+				t.Fatalf("got %d flows, want %d", next-1, numBlocks*perBlock)
+			}
+
+			stop := errors.New("stop")
+			count := 0
+			err := nf.Walk(context.Background(), func(FlowRecord) error {
+				if count++; count == 10 {
+					return stop
+				}
+				return nil
+			}, WithWorkers(8))
+			if !errors.Is(err, stop) || count != 10 {
+				t.Fatalf("got error %v after %d flows, want stop after 10", err, count)
+			}
+		})
+	}
+}
+
+func TestWalkWithSalvageSkipsCorruptBlocks(t *testing.T) {
+	walk := func(t *testing.T, path string, options ...WalkOption) ([]uint64, error) {
+		t.Helper()
+		nf := New()
+		if err := nf.Open(path); err != nil {
+			t.Fatal(err)
+		}
+		defer nf.Close()
+		var firsts []uint64
+		err := nf.Walk(context.Background(), func(record FlowRecord) error {
+			generic, _ := record.Generic()
+			firsts = append(firsts, generic.MsecFirst)
+			return nil
+		}, options...)
+		return firsts, err
+	}
+
+	t.Run("V3", func(t *testing.T) {
+		v3Block := func(first uint64) []byte {
+			return v18FlowBlock(v4RecordWithElements(t, 0, 1, v4Element{id: 1, data: genericTimes(first, first)}))
+		}
+		corrupt := v3Block(2)
+		corrupt[len(corrupt)-1] ^= 0xff
+		path := writeV3File(t, v3Block(1), corrupt, v3Block(3))
+		if _, err := walk(t, path); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+			t.Fatalf("got error %v, want checksum mismatch", err)
+		}
+		var report SalvageReport
+		firsts, err := walk(t, path, WithSalvage(&report), WithWorkers(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(firsts) != 2 || firsts[0] != 1 || firsts[1] != 3 {
+			t.Fatalf("got flows %v, want [1 3]", firsts)
+		}
+		offset := int64(v18HeaderSize + len(v3Block(1)))
+		if len(report.Skipped) != 1 || report.Skipped[0].Block != 1 || report.Skipped[0].Offset != offset {
+			t.Fatalf("unexpected report: %s", report.String())
+		}
+	})
+
+	t.Run("V2", func(t *testing.T) {
+		v2Block := func(first uint64) []byte {
+			return flowBlock(t, 0, v3RecordWithElements(v3Element{id: EXgenericFlowID, data: genericTimes(first, first)}))
+		}
+		badHeader := v2Block(2)
+		binary.LittleEndian.PutUint32(badHeader[4:8], 1<<20)
+		badRecords := v2Block(4)
+		binary.LittleEndian.PutUint32(badRecords[0:4], 2)
+		path := writeV2File(t, v2Header(NOT_COMPRESSED, 5), v2Block(1), badHeader, v2Block(3), badRecords, v2Block(5))
+		if _, err := walk(t, path); err == nil {
+			t.Fatal("walk of a corrupt file succeeded")
+		}
+		var report SalvageReport
+		firsts, err := walk(t, path, WithSalvage(&report))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// badRecords claims two records. Its only record is delivered before
+		// the missing second one is detected.
+		if len(firsts) != 4 || firsts[0] != 1 || firsts[1] != 3 || firsts[2] != 4 || firsts[3] != 5 {
+			t.Fatalf("got flows %v, want [1 3 4 5]", firsts)
+		}
+		if len(report.Skipped) != 2 || report.Resyncs != 1 || report.Skipped[0].Block != 1 {
+			t.Fatalf("unexpected report: %s", report.String())
+		}
+		if report.Err() == nil {
+			t.Fatal("report without error")
+		}
+	})
+
+	t.Run("callback error", func(t *testing.T) {
+		path := writeV2File(t, v2Header(NOT_COMPRESSED, 1), flowBlock(t, 0, v3Record(12), v3Record(12)))
+		nf := New()
+		if err := nf.Open(path); err != nil {
+			t.Fatal(err)
+		}
+		defer nf.Close()
+		stop := errors.New("stop")
+		var report SalvageReport
+		err := nf.Walk(context.Background(), func(FlowRecord) error { return stop }, WithSalvage(&report))
+		if !errors.Is(err, stop) || len(report.Skipped) != 0 {
+			t.Fatalf("got error %v and report %s, want stop", err, report.String())
+		}
+	})
+}
+
+func TestVerifyReportsAllProblems(t *testing.T) {
+	verify := func(t *testing.T, path string) *VerifyReport {
+		t.Helper()
+		report, err := VerifyFile(context.Background(), path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return report
+	}
+	statBlock := func(t *testing.T, stat StatRecord) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := binary.Write(&buf, binary.LittleEndian, &stat); err != nil {
+			t.Fatal(err)
+		}
+		return v18MetaBlock(v18BlockStat, metadataRecord(TYPE_STAT, buf.Bytes()))
+	}
+	generic := genericTimes(1000, 2000)
+	binary.LittleEndian.PutUint64(generic[24:32], 3)   // InPackets
+	binary.LittleEndian.PutUint64(generic[32:40], 180) // InBytes
+	generic[44] = 6                                    // TCP
+	flows := func() []byte {
+		return v18FlowBlock(v4RecordWithElements(t, 0, 1, v4Element{id: 1, data: generic}))
+	}
+	stat := StatRecord{Numflows: 1, Numbytes: 180, Numpackets: 3, NumflowsTcp: 1, NumbytesTcp: 180, NumpacketsTcp: 3, FirstSeen: 1000, LastSeen: 2000}
+
+	t.Run("valid", func(t *testing.T) {
+		report := verify(t, writeV3File(t, flows(), statBlock(t, stat)))
+		if !report.OK() || report.Blocks != 1 || report.Records != 1 || report.Stat != stat {
+			t.Fatalf("unexpected report: %s", report.String())
+		}
+	})
+
+	t.Run("corrupt block", func(t *testing.T) {
+		corrupt := flows()
+		corrupt[len(corrupt)-1] ^= 0xff
+		report := verify(t, writeV3File(t, flows(), corrupt, statBlock(t, stat)))
+		if len(report.Problems) != 1 || report.Problems[0].Check != VerifyBlock || report.Problems[0].Block != 1 ||
+			report.Records != 1 || report.Blocks != 2 {
+			t.Fatalf("unexpected report: %s", report.String())
+		}
+	})
+
+	t.Run("stat mismatch", func(t *testing.T) {
+		wrong := stat
+		wrong.Numflows = 2
+		wrong.NumbytesUdp = 10
+		report := verify(t, writeV3File(t, flows(), statBlock(t, wrong)))
+		if len(report.Problems) != 2 || report.Problems[0].Check != VerifyStat || report.Problems[1].Check != VerifyStat {
+			t.Fatalf("unexpected report: %s", report.String())
+		}
+	})
+
+	t.Run("corrupt metadata", func(t *testing.T) {
+		// Open fails on the ident block; VerifyFile still checks the flows.
+		ident := v18MetaBlock(v18BlockIdent, metadataRecord(TYPE_IDENT, []byte("router-1")))
+		ident[len(ident)-1] ^= 0xff
+		path := writeV3File(t, ident, flows(), statBlock(t, stat))
+		if err := New().Open(path); err == nil {
+			t.Fatal("opened file with corrupt ident block")
+		}
+		report := verify(t, path)
+		if len(report.Problems) != 1 || report.Problems[0].Check != VerifyContainer ||
+			!strings.Contains(report.Problems[0].Err.Error(), "metadata block 0") || report.Records != 1 || report.Stat != stat {
+			t.Fatalf("unexpected report: %s", report.String())
+		}
+	})
+
+	t.Run("V2 truncated", func(t *testing.T) {
+		block := flowBlock(t, 0, v3RecordWithElements(v3Element{id: EXgenericFlowID, data: generic}))
+		// The file lacks its second block and, written without appendix,
+		// its stat record.
+		report := verify(t, writeV2File(t, v2Header(NOT_COMPRESSED, 2), block))
+		if len(report.Problems) != 2 || report.Problems[0].Check != VerifyContainer || report.Problems[1].Check != VerifyStat {
+			t.Fatalf("unexpected report: %s", report.String())
+		}
+	})
+
+	t.Run("open", func(t *testing.T) {
+		report := verify(t, filepath.Join(t.TempDir(), "missing.nf"))
+		if len(report.Problems) != 1 || report.Problems[0].Check != VerifyOpen {
+			t.Fatalf("unexpected report: %s", report.String())
+		}
+	})
+}
+
+func TestReadBigEndianFiles(t *testing.T) {
+	be := binary.BigEndian
+	src, dst := netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("198.51.100.7")
+	putGeneric := func(data []byte) {
+		be.PutUint64(data[0:8], 1000)  // MsecFirst
+		be.PutUint64(data[8:16], 2000) // MsecLast
+		be.PutUint64(data[24:32], 3)   // InPackets
+		be.PutUint64(data[32:40], 180) // InBytes
+		be.PutUint16(data[40:42], 443) // SrcPort
+		be.PutUint16(data[42:44], 5353)
+		data[44] = 6
+	}
+	putIPv4 := func(data []byte) {
+		be.PutUint32(data[0:4], binary.BigEndian.Uint32(src.AsSlice()))
+		be.PutUint32(data[4:8], binary.BigEndian.Uint32(dst.AsSlice()))
+	}
+	check := func(t *testing.T, nf *NfFile) {
+		t.Helper()
+		if !nf.Info().BigEndian {
+			t.Fatal("file not detected as big-endian")
+		}
+		flows := 0
+		err := nf.Walk(context.Background(), func(record FlowRecord) error {
+			flows++
+			generic, ok := record.Generic()
+			if !ok || generic.MsecFirst != 1000 || generic.MsecLast != 2000 || generic.InPackets != 3 ||
+				generic.InBytes != 180 || generic.SrcPort != 443 || generic.DstPort != 5353 || generic.Proto != 6 {
+				t.Fatalf("unexpected generic flow %+v", generic)
+			}
+			recordSrc, recordDst, ok := record.IP()
+			if !ok || recordSrc != src || recordDst != dst {
+				t.Fatalf("got addresses %v %v", recordSrc, recordDst)
+			}
+			if record.ExporterID() != 1 {
+				t.Fatalf("got exporter ID %d", record.ExporterID())
+			}
+			return nil
+		})
+		if err != nil || flows != 1 {
+			t.Fatalf("walked %d flows: %v", flows, err)
+		}
+		if stat := nf.Stat(); stat.Numflows != 1 || stat.Numbytes != 180 {
+			t.Fatalf("unexpected stat %+v", stat)
+		}
+		exporters := nf.GetExporterList()
+		if !exporters[1].IP.Equal(src.AsSlice()) || exporters[1].Id != 7 {
+			t.Fatalf("unexpected exporter %+v", exporters[1])
+		}
+	}
+	exporterInfo := make([]byte, 32)
+	be.PutUint16(exporterInfo[0:2], ExporterInfoRecordType)
+	be.PutUint16(exporterInfo[2:4], 32)
+	be.PutUint32(exporterInfo[4:8], 10)
+	be.PutUint32(exporterInfo[20:24], binary.BigEndian.Uint32(src.AsSlice()))
+	be.PutUint16(exporterInfo[24:26], 2) // AF_INET
+	be.PutUint16(exporterInfo[26:28], 1)
+	be.PutUint32(exporterInfo[28:32], 7)
+	stat := make([]byte, 4+binary.Size(StatRecord{}))
+	be.PutUint16(stat[0:2], TYPE_STAT)
+	be.PutUint16(stat[2:4], uint16(len(stat)))
+	be.PutUint64(stat[4:12], 1)
+	be.PutUint64(stat[12:20], 180)
+
+	t.Run("V2", func(t *testing.T) {
+		record := make([]byte, v3RecordHeaderSize+4+48+4+8)
+		be.PutUint16(record[0:2], V3Record)
+		be.PutUint16(record[2:4], uint16(len(record)))
+		be.PutUint16(record[4:6], 2)
+		be.PutUint16(record[8:10], 1)
+		be.PutUint16(record[12:14], EXgenericFlowID)
+		be.PutUint16(record[14:16], 52)
+		putGeneric(record[16:64])
+		be.PutUint16(record[64:66], EXipv4FlowID)
+		be.PutUint16(record[66:68], 12)
+		putIPv4(record[68:76])
+		block := func(records ...[]byte) []byte {
+			var payload []byte
+			for _, record := range records {
+				payload = append(payload, record...)
+			}
+			var buf bytes.Buffer
+			header := DataBlockHeader{NumRecords: uint32(len(records)), Size: uint32(len(payload)), Type: 3}
+			if err := binary.Write(&buf, be, &header); err != nil {
+				t.Fatal(err)
+			}
+			return append(buf.Bytes(), payload...)
+		}
+		data := block(exporterInfo, record)
+		appendix := block(stat)
+		header := v2Header(NOT_COMPRESSED, 1)
+		header.AppendixBlocks = 1
+		header.OffAppendix = uint64(binary.Size(header) + len(data))
+		var file bytes.Buffer
+		if err := binary.Write(&file, be, &header); err != nil {
+			t.Fatal(err)
+		}
+		file.Write(data)
+		file.Write(appendix)
+
+		nf := New()
+		if err := nf.OpenBytes(file.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		defer nf.Close()
+		check(t, nf)
+
+		chain := nf.AllRecords()
+		records, err := chain.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		legacyFlows := 0
+		for legacy := range records {
+			legacyFlows++
+			if generic := legacy.GenericFlow(); generic == nil || generic.InBytes != 180 || generic.SrcPort != 443 {
+				t.Fatalf("unexpected legacy generic flow %+v", generic)
+			}
+			if ip := legacy.IP(); !ip.SrcIP.Equal(src.AsSlice()) {
+				t.Fatalf("unexpected legacy address %v", ip.SrcIP)
+			}
+		}
+		if err := chain.Err(); err != nil || legacyFlows != 1 {
+			t.Fatalf("read %d legacy flows: %v", legacyFlows, err)
+		}
+
+		// ReadDataBlocks returns the blocks as stored.
+		blocks, err := nf.ReadDataBlocks()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stored [][]byte
+		for dataBlock := range blocks {
+			if dataBlock.Err != nil {
+				t.Fatal(dataBlock.Err)
+			}
+			stored = append(stored, dataBlock.Data)
+		}
+		if want := append(append([]byte(nil), exporterInfo...), record...); len(stored) != 1 || !bytes.Equal(stored[0], want) {
+			t.Fatalf("got blocks %x, want %x", stored, want)
+		}
+	})
+
+	t.Run("V3", func(t *testing.T) {
+		record := make([]byte, 88)
+		be.PutUint16(record[0:2], v4RecordType)
+		be.PutUint16(record[2:4], 88)
+		be.PutUint16(record[4:6], 2)
+		be.PutUint32(record[8:12], 1)
+		be.PutUint64(record[16:24], 1<<1|1<<2)
+		be.PutUint16(record[24:26], 32)
+		be.PutUint16(record[26:28], 80)
+		putGeneric(record[32:80])
+		putIPv4(record[80:88])
+		block := func(typeID uint32, head int, records ...[]byte) []byte {
+			block := make([]byte, head)
+			for _, record := range records {
+				block = append(block, record...)
+			}
+			be.PutUint32(block[0:4], typeID)
+			be.PutUint32(block[4:8], uint32(len(block)))
+			be.PutUint32(block[8:12], uint32(len(block)))
+			be.PutUint16(block[12:14], 1) // NOT_COMPRESSED
+			be.PutUint32(block[24:28], uint32(len(records)))
+			be.PutUint64(block[16:24], v3Checksum64(block[v18BlockHeader:]))
+			return block
+		}
+		blocks := [][]byte{
+			block(v18BlockExporter, v18MetaBlockHead, exporterInfo),
+			block(v18BlockFlow, v18FlowBlockHead, record),
+			block(v18BlockStat, v18MetaBlockHead, stat),
+		}
+		file := make([]byte, v18HeaderSize)
+		directory := make([]byte, v18DirectoryHead)
+		be.PutUint32(directory[0:4], v18DirectoryMagic)
+		be.PutUint32(directory[4:8], uint32(len(blocks)))
+		for _, block := range blocks {
+			entry := make([]byte, v18DirectoryEnt)
+			be.PutUint32(entry[0:4], be.Uint32(block[0:4]))
+			be.PutUint32(entry[4:8], uint32(len(block)))
+			be.PutUint64(entry[8:16], uint64(len(file)))
+			directory = append(directory, entry...)
+			file = append(file, block...)
+		}
+		be.PutUint16(file[0:2], 0xA50C)
+		be.PutUint16(file[2:4], 3)
+		be.PutUint16(file[18:20], 1)
+		be.PutUint32(file[20:24], v18FlagPackageLayout)
+		be.PutUint32(file[24:28], 1024)
+		be.PutUint32(file[28:32], uint32(len(directory)))
+		be.PutUint64(file[32:40], uint64(len(file)))
+		footer := make([]byte, v18FooterSize)
+		be.PutUint32(footer[0:4], v18FooterMagic)
+		be.PutUint32(footer[4:8], uint32(len(directory)))
+		be.PutUint64(footer[8:16], uint64(len(file)))
+		be.PutUint64(footer[16:24], v3Checksum64(directory))
+		file = append(append(file, directory...), footer...)
+
+		nf := New()
+		if err := nf.OpenBytes(file); err != nil {
+			t.Fatal(err)
+		}
+		defer nf.Close()
+		check(t, nf)
+	})
+}
+
+func TestFollowGrowingFile(t *testing.T) {
+	stat := StatRecord{Numflows: 2, Numpackets: 42}
+	statPayload := make([]byte, binary.Size(stat))
+	writer := sliceWriter(statPayload)
+	if err := binary.Write(&writer, binary.LittleEndian, &stat); err != nil {
+		t.Fatal(err)
+	}
+
+	// follow runs Follow on path and returns the first flow time of each
+	// record as it arrives and the final error.
+	follow := func(t *testing.T, path string) (*NfFile, <-chan uint64, <-chan error) {
+		t.Helper()
+		nf := New()
+		flows := make(chan uint64, 8)
+		done := make(chan error, 1)
+		go func() {
+			done <- nf.Follow(context.Background(), path, func(record FlowRecord) error {
+				generic, _ := record.Generic()
+				flows <- generic.MsecFirst
+				return nil
+			}, WithPollInterval(time.Millisecond))
+		}()
+		return nf, flows, done
+	}
+	expect := func(t *testing.T, flows <-chan uint64, want uint64) {
+		t.Helper()
+		select {
+		case got := <-flows:
+			if got != want {
+				t.Fatalf("got flow %d, want %d", got, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("flow %d not delivered", want)
+		}
+	}
+	finish := func(t *testing.T, flows <-chan uint64, done <-chan error) {
+		t.Helper()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Follow did not return")
+		}
+		if len(flows) != 0 {
+			t.Fatalf("%d unexpected flows", len(flows))
+		}
+	}
+	appendFile := func(t *testing.T, f *os.File, data []byte) {
+		t.Helper()
+		if _, err := f.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("V2 closed", func(t *testing.T) {
+		block := func(first uint64) []byte {
+			return flowBlock(t, 0, v3RecordWithElements(v3Element{id: EXgenericFlowID, data: genericTimes(first, first)}))
+		}
+		ident := metadataRecord(TYPE_IDENT, []byte("router-1"))
+		appendix := flowBlock(t, 0, ident, metadataRecord(TYPE_STAT, statPayload))
+		header := v2Header(NOT_COMPRESSED, 0)
+		path := filepath.Join(t.TempDir(), "nfcapd.current.1234")
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := binary.Write(f, binary.LittleEndian, &header); err != nil {
+			t.Fatal(err)
+		}
+		nf, flows, done := follow(t, path)
+		appendFile(t, f, block(1))
+		expect(t, flows, 1)
+		second := block(2)
+		appendFile(t, f, second[:20])
+		time.Sleep(20 * time.Millisecond)
+		if len(flows) != 0 {
+			t.Fatal("incomplete block delivered")
+		}
+		appendFile(t, f, second[20:])
+		expect(t, flows, 2)
+
+		// nfcapd appends the appendix and rewrites the header on close.
+		header.NumBlocks = 2
+		header.AppendixBlocks = 1
+		header.OffAppendix = uint64(binary.Size(header) + 2*len(second))
+		appendFile(t, f, appendix)
+		var buf bytes.Buffer
+		if err := binary.Write(&buf, binary.LittleEndian, &header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteAt(buf.Bytes(), 0); err != nil {
+			t.Fatal(err)
+		}
+		finish(t, flows, done)
+		if nf.Ident() != "router-1" || nf.Stat() != stat {
+			t.Fatalf("got ident %q and stat %+v", nf.Ident(), nf.Stat())
+		}
+	})
+
+	t.Run("V2 closed with the last block", func(t *testing.T) {
+		header := v2Header(NOT_COMPRESSED, 0)
+		path := filepath.Join(t.TempDir(), "nfcapd.current.1234")
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := binary.Write(f, binary.LittleEndian, &header); err != nil {
+			t.Fatal(err)
+		}
+		nf := New()
+		done := make(chan error, 1)
+		go func() {
+			done <- nf.Follow(context.Background(), path, func(FlowRecord) error {
+				// The appendix is read while the block is still processed,
+				// but must only be applied after it.
+				time.Sleep(50 * time.Millisecond)
+				if nf.Stat() != (StatRecord{}) {
+					return fmt.Errorf("stat record applied during the walk")
+				}
+				return nil
+			}, WithPollInterval(time.Millisecond))
+		}()
+		data := flowBlock(t, 0, v3Record(12))
+		appendFile(t, f, data)
+		// Let Follow open the file and start on the block before the file
+		// is closed.
+		time.Sleep(10 * time.Millisecond)
+		header.NumBlocks = 1
+		header.AppendixBlocks = 1
+		header.OffAppendix = uint64(binary.Size(header) + len(data))
+		appendFile(t, f, flowBlock(t, 0, metadataRecord(TYPE_STAT, statPayload)))
+		var buf bytes.Buffer
+		if err := binary.Write(&buf, binary.LittleEndian, &header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteAt(buf.Bytes(), 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if nf.Stat() != stat {
+			t.Fatalf("got stat %+v", nf.Stat())
+		}
+	})
+
+	t.Run("V3 renamed", func(t *testing.T) {
+		block := func(first uint64) []byte {
+			return v18FlowBlock(v4RecordWithElements(t, 0, 1, v4Element{id: 1, data: genericTimes(first, first)}))
+		}
+		full, err := os.ReadFile(writeV3File(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Until it is closed, the file has neither directory nor footer.
+		header := full[:v18HeaderSize]
+		clear(header[28:40])
+		dir := t.TempDir()
+		path := filepath.Join(dir, "nfcapd.current.1234")
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		appendFile(t, f, header)
+		nf, flows, done := follow(t, path)
+		appendFile(t, f, block(1))
+		expect(t, flows, 1)
+		appendFile(t, f, block(2))
+		appendFile(t, f, v18MetaBlock(v18BlockStat, metadataRecord(TYPE_STAT, statPayload)))
+		expect(t, flows, 2)
+		if err := os.Rename(path, filepath.Join(dir, "nfcapd.202610161200")); err != nil {
+			t.Fatal(err)
+		}
+		finish(t, flows, done)
+		if nf.Stat() != stat {
+			t.Fatalf("got stat %+v", nf.Stat())
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		path := writeV2File(t, v2Header(NOT_COMPRESSED, 0))
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		err := New().Follow(ctx, path, func(FlowRecord) error { return nil }, WithPollInterval(time.Millisecond))
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got error %v, want deadline exceeded", err)
+		}
+	})
+}
+
+func TestProbe(t *testing.T) {
+	stat := StatRecord{Numflows: 2, Numpackets: 42, FirstSeen: 1000, LastSeen: 2000}
+	statPayload := make([]byte, binary.Size(stat))
+	writer := sliceWriter(statPayload)
+	if err := binary.Write(&writer, binary.LittleEndian, &stat); err != nil {
+		t.Fatal(err)
+	}
+	ident := metadataRecord(TYPE_IDENT, []byte("router-1"))
+
+	data := flowBlock(t, 0, v3Record(12), v3Record(12))
+	header := v2Header(NOT_COMPRESSED, 1)
+	header.AppendixBlocks = 1
+	header.OffAppendix = uint64(binary.Size(header) + len(data))
+	v2Path := writeV2File(t, header, data, flowBlock(t, 0, ident, metadataRecord(TYPE_STAT, statPayload)))
+	v3Path := writeV3File(t,
+		v18MetaBlock(v18BlockIdent, ident),
+		v18FlowBlock(v4RecordWithElements(t, 0, 1, v4Element{id: 1, data: make([]byte, 48)})),
+		v18MetaBlock(v18BlockStat, metadataRecord(TYPE_STAT, statPayload)),
+	)
+
+	for _, test := range []struct {
+		name   string
+		path   string
+		layout FileLayout
+	}{
+		{"V2", v2Path, FileLayoutV2},
+		{"V3", v3Path, FileLayoutV3},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(filepath.Dir(test.path), "nfcapd.202610161230")
+			if err := os.Rename(test.path, path); err != nil {
+				t.Fatal(err)
+			}
+			info, got, err := Probe(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Layout != test.layout || info.FlowBlocks != 1 || got != stat {
+				t.Fatalf("got info %+v and stat %+v", info, got)
+			}
+			if want := time.Date(2026, 10, 16, 12, 30, 0, 0, time.Local); !info.NameTime.Equal(want) {
+				t.Fatalf("got name time %v, want %v", info.NameTime, want)
+			}
+
+			nf := New()
+			if err := nf.Open(path); err != nil {
+				t.Fatal(err)
+			}
+			defer nf.Close()
+			if nf.Info() != info {
+				t.Fatalf("Open reports %+v, Probe %+v", nf.Info(), info)
+			}
+		})
+	}
+
+	for _, name := range []string{"flows.nf", "nfcapd.current.1234", "nfcapd.2026101612", "nfcapd.202613161230", "/data/2026/10/nfcapd.202610161230.nfstat"} {
+		if slot := nameTime(name); !slot.IsZero() {
+			t.Errorf("name %s: got time %v", name, slot)
+		}
+	}
+	if slot := nameTime("/data/2026/10/16/nfcapd.20261016123015"); !slot.Equal(time.Date(2026, 10, 16, 12, 30, 15, 0, time.Local)) {
+		t.Errorf("got time %v", slot)
+	}
+
+	if _, _, err := Probe(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("Probe of a missing file succeeded")
+	}
+}
+
+// writerTestRecords returns V3 records for writer tests: records with a
+// random payload, which compress badly, followed by compressible flows.
+func writerTestRecords(t *testing.T) []FlowRecord {
+	t.Helper()
+	random := rand.New(rand.NewPCG(1, 2))
+	var records []FlowRecord
+	for i := range 240 {
+		generic := genericTimes(uint64(1000+i), uint64(2000+i))
+		binary.LittleEndian.PutUint64(generic[24:32], uint64(i+1))
+		binary.LittleEndian.PutUint64(generic[32:40], uint64(100*(i+1)))
+		generic[44] = []uint8{1, 6, 17, 47}[i%4]
+		elements := []v3Element{{id: EXgenericFlowID, data: generic}}
+		if i < 40 {
+			payload := make([]byte, 1000)
+			for j := range payload {
+				payload[j] = byte(random.Uint32())
+			}
+			elements = append(elements, v3Element{id: EXinPayloadID, data: payload})
+		}
+		record, err := newFlowRecordV3(v3RecordWithElements(elements...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestWriterV2RoundTrip(t *testing.T) {
+	records := writerTestRecords(t)
+	var want StatRecord
+	for _, record := range records {
+		want.addFlow(record)
+	}
+
+	for _, compression := range []Compression{CompressionNone, CompressionLZO, CompressionBzip2, CompressionLZ4, CompressionZSTD} {
+		t.Run(fmt.Sprint(compression), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "flows.nf")
+			writer, err := Create(path, WithCompression(compression), WithBlockSize(4096), WithIdent("router-1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, record := range records {
+				if err := writer.Write(record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if writer.Stat() != want {
+				t.Fatalf("writer stat %+v, want %+v", writer.Stat(), want)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.Write(records[0]); err == nil {
+				t.Fatal("Write after Close succeeded")
+			}
+
+			nf := New()
+			if err := nf.Open(path); err != nil {
+				t.Fatal(err)
+			}
+			defer nf.Close()
+			info := nf.Info()
+			if info.Layout != FileLayoutV2 || info.Compression != compression || info.BlockSize != 4096 || info.FlowBlocks < 10 {
+				t.Fatalf("got info %+v", info)
+			}
+			if nf.Ident() != "router-1" || nf.Stat() != want {
+				t.Fatalf("got ident %q and stat %+v, want %+v", nf.Ident(), nf.Stat(), want)
+			}
+			i := 0
+			if err := nf.Walk(context.Background(), func(record FlowRecord) error {
+				if i >= len(records) || !bytes.Equal(record.raw, records[i].raw) {
+					return fmt.Errorf("record %d differs", i)
+				}
+				i++
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if i != len(records) {
+				t.Fatalf("got %d records, want %d", i, len(records))
+			}
+			report, err := nf.Verify(context.Background())
+			if err != nil || !report.OK() {
+				t.Fatalf("verify: %v %v", report, err)
+			}
+		})
+	}
+
+	t.Run("rejects", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "flows.nf")
+		if _, err := Create(path, WithBlockSize(100)); err == nil {
+			t.Fatal("Create accepted a block size of 100 bytes")
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("failed Create left %s behind", path)
+		}
+		writer, err := Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v4, err := newFlowRecordV4(v4RecordWithElements(t, 0, 1, v4Element{id: 1, data: make([]byte, 48)}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Write(v4); err == nil {
+			t.Fatal("V2 writer accepted a V4 record")
+		}
+		if err := writer.Write(records[0]); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, stat, err := Probe(path); err != nil || stat.Numflows != 1 {
+			t.Fatalf("got stat %+v and error %v", stat, err)
+		}
+	})
+}
+
+// writerTestV4Records returns the V4 counterparts of writerTestRecords.
+func writerTestV4Records(t *testing.T) []FlowRecord {
+	t.Helper()
+	var records []FlowRecord
+	for _, v3 := range writerTestRecords(t) {
+		elements := []v4Element{{id: 1, data: v3.Extension(ExtensionGenericFlow)}}
+		if payload := v3.Extension(ExtensionInPayload); payload != nil {
+			data := binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))
+			elements = append(elements, v4Element{id: 26, data: append(data, payload...)})
+		}
+		record, err := newFlowRecordV4(v4RecordWithElements(t, 0, 1, elements...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestWriterV3RoundTrip(t *testing.T) {
+	records := writerTestV4Records(t)
+	var want StatRecord
+	for _, record := range records {
+		want.addFlow(record)
+	}
+	readBack := func(t *testing.T, path string) *NfFile {
+		t.Helper()
+		nf := New()
+		if err := nf.Open(path); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { nf.Close() })
+		if nf.Ident() != "router-1" || nf.Stat() != want {
+			t.Fatalf("got ident %q and stat %+v, want %+v", nf.Ident(), nf.Stat(), want)
+		}
+		i := 0
+		if err := nf.Walk(context.Background(), func(record FlowRecord) error {
+			if i >= len(records) || !bytes.Equal(record.raw, records[i].raw) {
+				return fmt.Errorf("record %d differs", i)
+			}
+			i++
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if i != len(records) {
+			t.Fatalf("got %d records, want %d", i, len(records))
+		}
+		report, err := nf.Verify(context.Background())
+		if err != nil || !report.OK() || report.Unchecksummed != 0 {
+			t.Fatalf("verify: %v %v", report, err)
+		}
+		return nf
+	}
+
+	for _, compression := range []Compression{CompressionNone, CompressionLZO, CompressionBzip2, CompressionLZ4, CompressionZSTD} {
+		t.Run(fmt.Sprint(compression), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "flows.nf")
+			writer, err := Create(path, WithLayout(FileLayoutV3), WithCompression(compression), WithBlockSize(4096), WithIdent("router-1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, record := range records {
+				if err := writer.Write(record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+			info := readBack(t, path).Info()
+			if info.Layout != FileLayoutV3 || info.Compression != compression || info.BlockSize != 4096 || info.FlowBlocks < 10 {
+				t.Fatalf("got info %+v", info)
+			}
+			if compression != CompressionNone {
+				return
+			}
+			// The first block holds records 0 to 2, whose flows span 1000 to
+			// 2002.
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			head := data[v18HeaderSize:]
+			if binary.LittleEndian.Uint32(head[24:28]) != 3 ||
+				binary.LittleEndian.Uint64(head[v18FlowBlockFirst:]) != 1000 || binary.LittleEndian.Uint64(head[v18FlowBlockLast:]) != 2002 {
+				t.Fatalf("got flow block head %v", head[:v18FlowBlockHead])
+			}
+		})
+	}
+
+	t.Run("per-block compression", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "flows.nf")
+		writer, err := Create(path, WithLayout(FileLayoutV3), WithCompression(CompressionLZ4), WithBlockSize(4096), WithIdent("router-1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, record := range records {
+			switch i {
+			case 80:
+				err = writer.SetCompression(CompressionZSTD)
+			case 160:
+				err = writer.SetCompression(CompressionNone)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.Write(record); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		readBack(t, path)
+
+		var v3 FlowRecord
+		v3, err = newFlowRecordV3(v3RecordWithElements(v3Element{id: EXgenericFlowID, data: genericTimes(1, 2)}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer, err = Create(path, WithLayout(FileLayoutV3))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer writer.Close()
+		if err := writer.Write(v3); err == nil {
+			t.Fatal("V3 writer accepted a V3 record")
+		}
+	})
+
+	t.Run("V2 compression switch", func(t *testing.T) {
+		writer, err := Create(filepath.Join(t.TempDir(), "flows.nf"), WithCompression(CompressionLZ4))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer writer.Close()
+		if err := writer.SetCompression(CompressionNone); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.SetCompression(CompressionZSTD); err == nil {
+			t.Fatal("V2 writer switched from LZ4 to ZSTD")
+		}
+	})
+}
+
+func TestRecordBuilder(t *testing.T) {
+	generic := GenericFlow{MsecFirst: 1000, MsecLast: 2000, MsecReceived: 2100, InPackets: 10, InBytes: 1500, SrcPort: 1024, DstPort: 443, Proto: 6, TcpFlags: 0x1b, FwdStatus: 1, SrcTos: 4}
+	flowMisc := FlowMisc{Input: 3, Output: 7, SrcMask: 24, DstMask: 16, Dir: 1, DstTos: 8, BiFlowDir: 2, FlowEndReason: 3}
+	counters := Counters{Flows: 1, OutPackets: 8, OutBytes: 900}
+	event := NATEvent{MsecEvent: 1500, Event: 1, PoolID: 42}
+	portBlock := NATPortBlock{Start: 1024, End: 2047, Step: 1, Size: 1024}
+	var builder RecordBuilder
+	builder.SetExporter(5).SetEngine(9, 3).SetNetFlowVersion(10).SetFlags(2).
+		SetGeneric(generic).
+		SetAddrs(netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("2001:db8::2")).
+		SetFlowMisc(flowMisc).SetCounters(counters).
+		SetVLAN(VLAN{Src: 10, Dst: 20}).SetAS(AS{Src: 64500, Dst: 64501}).
+		SetIPInfo(IPInfo{FragmentFlags: FlagDF, MinTTL: 60, MaxTTL: 64}).
+		SetNATAddrs(netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("198.51.100.1")).
+		SetNATPorts(40000, 443).SetNATEvent(event).SetNATPortBlock(portBlock)
+
+	for _, format := range []RecordFormat{RecordFormatV3, RecordFormatV4} {
+		record, err := builder.Build(format)
+		if err != nil {
+			t.Fatalf("format %d: %v", format, err)
+		}
+		if record.Format() != format {
+			t.Fatalf("got format %d, want %d", record.Format(), format)
+		}
+		if got, ok := record.Generic(); !ok || got != generic {
+			t.Fatalf("format %d: got generic %+v", format, got)
+		}
+		src, dst, ok := record.IP()
+		if !ok || !record.IsIPv6() || src.String() != "2001:db8::1" || dst.String() != "2001:db8::2" {
+			t.Fatalf("format %d: got addresses %v %v", format, src, dst)
+		}
+		engineType, engineID := record.Engine()
+		if record.ExporterID() != 5 || record.Flags() != 2 || record.NetFlowVersion() != 10 || engineType != 9 || engineID != 3 {
+			t.Fatalf("format %d: got header fields %d %d %d %d %d", format, record.ExporterID(), record.Flags(), record.NetFlowVersion(), engineType, engineID)
+		}
+		if data := record.Extension(ExtensionCounters); len(data) != 24 || binary.LittleEndian.Uint64(data[16:24]) != 900 {
+			t.Fatalf("format %d: got counters %x", format, data)
+		}
+		if data := record.Extension(ExtensionASRouting); len(data) != 8 || binary.LittleEndian.Uint32(data[4:8]) != 64501 {
+			t.Fatalf("format %d: got AS %x", format, data)
+		}
+		if data := record.Extension(ExtensionIPInfo); len(data) < 4 || data[1] != FlagDF || data[3] != 64 {
+			t.Fatalf("format %d: got IP info %x", format, data)
+		}
+	}
+
+	// The V3 record decodes with the legacy API as well.
+	record, err := builder.Build(RecordFormatV3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := NewRecord(record.raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if misc := legacy.FlowMisc(); misc == nil || misc.Input != 3 || misc.Output != 7 || misc.SrcMask != 24 || misc.FlowEndReason != 3 {
+		t.Fatalf("got flow misc %+v", misc)
+	}
+	if vlan := legacy.VLan(); vlan == nil || vlan.SrcVlan != 10 || vlan.DstVlan != 20 {
+		t.Fatalf("got VLAN %+v", vlan)
+	}
+	if xlate := legacy.NatXlateIP(); xlate == nil || xlate.SrcXIP.String() != "192.0.2.1" || xlate.DstXIP.String() != "198.51.100.1" {
+		t.Fatalf("got NAT addresses %+v", xlate)
+	}
+	if ports := legacy.NatXlatePort(); ports == nil || ports.XlateSrcPort != 40000 || ports.XlateDstPort != 443 {
+		t.Fatalf("got NAT ports %+v", ports)
+	}
+	if common := legacy.NatCommon(); common == nil || common.MsecEvent != 1500 || common.NatEvent != 1 || common.NatPoolID != 42 {
+		t.Fatalf("got NAT event %+v", common)
+	}
+	if block := legacy.NatPortBlock(); block == nil || *block != (EXnatPortBlock{1024, 2047, 1, 1024}) {
+		t.Fatalf("got NAT port block %+v", block)
+	}
+	if info := legacy.IpInfo(); info == nil || info.MinTTL != 60 || info.MaxTTL != 64 {
+		t.Fatalf("got IP info %+v", info)
+	}
+
+	// Built records are written like records read from a file.
+	for _, layout := range []FileLayout{FileLayoutV2, FileLayoutV3} {
+		format := RecordFormatV3
+		if layout == FileLayoutV3 {
+			format = RecordFormatV4
+		}
+		record, err := builder.Build(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "flows.nf")
+		writer, err := Create(path, WithLayout(layout))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Write(record); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+		nf := New()
+		if err := nf.Open(path); err != nil {
+			t.Fatal(err)
+		}
+		var got [][]byte
+		err = nf.Walk(context.Background(), func(record FlowRecord) error {
+			got = append(got, record.Clone().raw)
+			return nil
+		})
+		nf.Close()
+		if err != nil || len(got) != 1 || !bytes.Equal(got[0], record.raw) {
+			t.Fatalf("layout %d: read back %d records: %v", layout, len(got), err)
+		}
+	}
+
+	// An IPv4 pair uses the IPv4 extension.
+	builder.Reset().SetAddrs(netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2"))
+	for _, format := range []RecordFormat{RecordFormatV3, RecordFormatV4} {
+		record, err := builder.Build(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if src, dst, ok := record.IP(); !ok || !record.IsIPv4() || src.String() != "10.0.0.1" || dst.String() != "10.0.0.2" {
+			t.Fatalf("format %d: got addresses %v %v", format, src, dst)
+		}
+	}
+
+	for name, invalid := range map[string]*RecordBuilder{
+		"mixed families":  new(RecordBuilder).SetAddrs(netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("2001:db8::1")),
+		"single address":  new(RecordBuilder).SetNATAddrs(netip.MustParseAddr("10.0.0.1"), netip.Addr{}),
+		"V3 exporter ID":  new(RecordBuilder).SetExporter(0x10000),
+		"V3 record flags": new(RecordBuilder).SetFlags(0x100),
+	} {
+		if _, err := invalid.Build(RecordFormatV3); err == nil {
+			t.Fatalf("%s: built invalid record", name)
+		}
+	}
+	if _, err := new(RecordBuilder).SetExporter(0x10000).SetFlags(0x100).Build(RecordFormatV4); err != nil {
+		t.Fatalf("V4 record: %v", err)
+	}
+}
+
+func TestTranscode(t *testing.T) {
+	for id, mapping := range v4Mappings {
+		if mapping.bit == 0 {
+			continue
+		}
+		size, ok := v4ExtensionSize(uint(mapping.bit), make([]byte, 128))
+		if !ok || (id != int(EXflowMiscID) && int(mapping.v3Size) > size) || v3ExtensionIDs[mapping.bit] != ExtensionID(id) {
+			t.Fatalf("extension %d maps to V4 extension %d of size %d", id, mapping.bit, size)
+		}
+	}
+
+	var builder RecordBuilder
+	builder.SetExporter(1).SetEngine(9, 3).SetNetFlowVersion(10).SetFlags(2).
+		SetGeneric(GenericFlow{MsecFirst: 1000, MsecLast: 2000, InPackets: 10, InBytes: 1500, SrcPort: 1024, DstPort: 443, Proto: 6}).
+		SetAddrs(netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")).
+		SetFlowMisc(FlowMisc{Input: 3, Output: 7, SrcMask: 24, DstMask: 16}).
+		SetCounters(Counters{Flows: 1, OutPackets: 8, OutBytes: 900}).
+		SetAS(AS{Src: 64500, Dst: 64501}).SetIPInfo(IPInfo{MinTTL: 60, MaxTTL: 64}).
+		SetNATAddrs(netip.MustParseAddr("2001:db8::1"), netip.MustParseAddr("2001:db8::2")).
+		SetNATPorts(40000, 443).SetNATEvent(NATEvent{MsecEvent: 1500, Event: 1, PoolID: 42})
+	built, err := builder.Build(RecordFormatV3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Elements built by hand in ascending order, with a payload, a BGP next
+	// hop, MAC addresses and a sampler info V4 cannot hold.
+	generic := make([]byte, 48)
+	binary.LittleEndian.PutUint64(generic[0:8], 3000)
+	binary.LittleEndian.PutUint64(generic[8:16], 4000)
+	generic[44] = 17
+	mac := make([]byte, 32)
+	for i := range mac {
+		mac[i] = byte(i)
+	}
+	handmade := v3RecordWithElements(
+		v3Element{EXgenericFlowID, generic},
+		v3Element{EXipv6FlowID, bytes.Repeat([]byte{0xab}, 32)},
+		v3Element{EXbgpNextHopV4ID, []byte{1, 2, 3, 4}},
+		v3Element{EXmacAddrID, mac},
+		v3Element{EXsamplerInfoID, make([]byte, 16)},
+		v3Element{EXinPayloadID, []byte("GET / HTTP/1.1")},
+		v3Element{EXnokiaNatStringID, []byte("nat-1")},
+	)
+	handmade[8] = 2 // exporter 2
+	withoutSampler := v3RecordWithElements(
+		v3Element{EXgenericFlowID, generic},
+		v3Element{EXipv6FlowID, bytes.Repeat([]byte{0xab}, 32)},
+		v3Element{EXbgpNextHopV4ID, []byte{1, 2, 3, 4}},
+		v3Element{EXmacAddrID, mac},
+		v3Element{EXinPayloadID, []byte("GET / HTTP/1.1")},
+		v3Element{EXnokiaNatStringID, []byte("nat-1")},
+	)
+	withoutSampler[8] = 2
+	exporters := []Exporter{
+		{IP: net.IPv4(192, 0, 2, 1), SysId: 1, Version: 10, Id: 7, Packets: 100, Flows: 20,
+			SamplerList: []Sampler{{Id: 5, Algorithm: 1, PacketInterval: 100}}},
+		{IP: net.ParseIP("2001:db8::7"), SysId: 2, Version: 9, Id: 8, isV6: true},
+	}
+
+	dir := t.TempDir()
+	v2Path := filepath.Join(dir, "v2.nf")
+	writer, err := Create(v2Path, WithIdent("router-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.WriteExporters(exporters...); err != nil {
+		t.Fatal(err)
+	}
+	for _, raw := range [][]byte{built.raw, handmade} {
+		if err := writer.Write(FlowRecord{raw: raw, format: RecordFormatV3}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	transcode := func(t *testing.T, from, to string, layout FileLayout, options ...TranscodeOption) (*TranscodeReport, [][]byte, *NfFile) {
+		t.Helper()
+		src := New()
+		if err := src.Open(from); err != nil {
+			t.Fatal(err)
+		}
+		defer src.Close()
+		dst, err := Create(to, WithLayout(layout), WithCompression(CompressionLZ4))
+		if err != nil {
+			t.Fatal(err)
+		}
+		report, err := Transcode(context.Background(), src, dst, options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := dst.Close(); err != nil {
+			t.Fatal(err)
+		}
+		nf := New()
+		if err := nf.Open(to); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { nf.Close() })
+		var records [][]byte
+		if err := nf.Walk(context.Background(), func(record FlowRecord) error {
+			records = append(records, record.Clone().raw)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if verify, err := nf.Verify(context.Background()); err != nil || !verify.OK() {
+			t.Fatalf("verify: %v %v", verify, err)
+		}
+		return report, records, nf
+	}
+
+	v3Path := filepath.Join(dir, "v3.nf")
+	report, v4Records, nf := transcode(t, v2Path, v3Path, FileLayoutV3)
+	if report.Records != 2 || report.Exporters != 2 || report.Samplers != 1 || report.Skipped != 0 ||
+		len(report.Dropped) != 1 || report.Dropped["V3 extension 18"] != 1 || report.Lossless() {
+		t.Fatalf("got report %v", report)
+	}
+	if nf.Ident() != "router-1" || nf.Info().Layout != FileLayoutV3 || len(v4Records) != 2 {
+		t.Fatalf("got ident %q, info %+v, %d records", nf.Ident(), nf.Info(), len(v4Records))
+	}
+	list := nf.GetExporterList()
+	if len(list) < 3 || !list[1].IP.Equal(exporters[0].IP) || list[1].Id != 7 || list[1].Packets != 100 || list[1].Flows != 20 ||
+		len(list[1].SamplerList) != 1 || list[1].SamplerList[0] != exporters[0].SamplerList[0] ||
+		!list[2].IP.Equal(exporters[1].IP) || list[2].Version != 9 {
+		t.Fatalf("got exporters %+v", list)
+	}
+	record := FlowRecord{raw: v4Records[0], format: RecordFormatV4}
+	if src, _, ok := record.IP(); !ok || src.String() != "10.0.0.1" || record.ExporterID() != 1 || record.Flags() != 2 {
+		t.Fatalf("got V4 record %x", record.raw)
+	}
+	if data := record.Extension(ExtensionFlowMisc); len(data) != 8 || data[0] != 24 || data[1] != 16 {
+		t.Fatalf("got V4 flow misc %x", data)
+	}
+	record = FlowRecord{raw: v4Records[1], format: RecordFormatV4}
+	if payload := record.Extension(ExtensionInPayload); string(payload) != "GET / HTTP/1.1" {
+		t.Fatalf("got V4 payload %q", payload)
+	}
+	if data := record.Extension(EXmacAddrID); !bytes.Equal(data, mac) {
+		t.Fatalf("got V4 MAC addresses %x", data)
+	}
+
+	// Back to V2, every field but the sampler info survives.
+	report, v3Records, nf := transcode(t, v3Path, filepath.Join(dir, "back.nf"), FileLayoutV2, WithStrict())
+	if report.Records != 2 || !report.Lossless() || len(v3Records) != 2 || nf.Ident() != "router-1" {
+		t.Fatalf("got report %v and %d records", report, len(v3Records))
+	}
+	if !bytes.Equal(v3Records[0], built.raw) || !bytes.Equal(v3Records[1], withoutSampler) {
+		t.Fatalf("round trip changed records:\n%x\n%x", v3Records, [][]byte{built.raw, withoutSampler})
+	}
+
+	// Strict transcoding refuses to lose the sampler info.
+	src := New()
+	if err := src.Open(v2Path); err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	dst, err := Create(filepath.Join(dir, "strict.nf"), WithLayout(FileLayoutV3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if _, err := Transcode(context.Background(), src, dst, WithStrict()); err == nil || !strings.Contains(err.Error(), "V3 extension 18") {
+		t.Fatalf("strict transcode: %v", err)
+	}
+
+	// A RotatingWriter is a Writer as well; the exporters and their
+	// counters go to its current file.
+	rotateDir := filepath.Join(dir, "rotate")
+	if err := os.Mkdir(rotateDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 16, 12, 1, 0, 0, time.UTC)
+	rotatingWriter, err := NewRotatingWriter(rotateDir, WithRotateClock(func() time.Time { return now }),
+		WithRotateLocation(time.UTC), WithRotateFileOptions(WithLayout(FileLayoutV3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err = Transcode(context.Background(), src, rotatingWriter)
+	if err != nil || report.Records != 2 || report.Exporters != 2 || report.Samplers != 1 {
+		t.Fatalf("got report %v: %v", report, err)
+	}
+	if err := rotatingWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rotated := New()
+	if err := rotated.Open(filepath.Join(rotateDir, "nfcapd.202610161200")); err != nil {
+		t.Fatal(err)
+	}
+	defer rotated.Close()
+	if verify, err := rotated.Verify(context.Background()); err != nil || !verify.OK() || verify.Records != 2 {
+		t.Fatalf("verify: %v %v", verify, err)
+	}
+	list = rotated.GetExporterList()
+	if len(list) < 3 || !list[1].IP.Equal(exporters[0].IP) || list[1].Packets != 100 || len(list[1].SamplerList) != 1 ||
+		!list[2].IP.Equal(exporters[1].IP) {
+		t.Fatalf("got exporters %+v", list)
+	}
+
+	// A V4 exporter ID beyond 16 bits does not fit into a V3 record.
+	var converter transcoder
+	converter.format = RecordFormatV3
+	wide, err := builder.Reset().SetExporter(0x12345).Build(RecordFormatV4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if converted, err := converter.convert(wide); err != nil || converted.ExporterID() != 0 || !slices.Equal(converter.lost, []string{"exporter ID"}) {
+		t.Fatalf("got exporter %d, lost %v: %v", converted.ExporterID(), converter.lost, err)
+	}
+}
+
+func TestRecompress(t *testing.T) {
+	exporter := Exporter{IP: net.IPv4(192, 0, 2, 1), SysId: 1, Version: 10, Id: 7, Packets: 100, Flows: 20,
+		SamplerList: []Sampler{{Id: 5, Algorithm: 1, PacketInterval: 100}}}
+	for _, test := range []struct {
+		layout  FileLayout
+		records []FlowRecord
+	}{
+		{FileLayoutV2, writerTestRecords(t)},
+		{FileLayoutV3, writerTestV4Records(t)},
+	} {
+		t.Run(fmt.Sprint(test.layout), func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "nfcapd.202610161230")
+			writer, err := Create(path, WithLayout(test.layout), WithCompression(CompressionLZO), WithBlockSize(4096), WithIdent("router-1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := writer.WriteExporters(exporter); err != nil {
+				t.Fatal(err)
+			}
+			for _, record := range test.records {
+				if err := writer.Write(record); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatal(err)
+			}
+			modTime := time.Date(2026, 10, 16, 12, 35, 0, 0, time.UTC)
+			if err := os.Chmod(path, 0640); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+			before, _, err := Probe(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			original, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// An invalid block size leaves the file alone.
+			if err := Recompress(context.Background(), path, WithBlockSize(1)); err == nil {
+				t.Fatal("recompressed with invalid block size")
+			}
+			if data, err := os.ReadFile(path); err != nil || !bytes.Equal(data, original) {
+				t.Fatalf("failed recompression changed the file: %v", err)
+			}
+
+			if err := Recompress(context.Background(), path, WithCompression(CompressionZSTD), WithBlockSize(1<<20)); err != nil {
+				t.Fatal(err)
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil || len(entries) != 1 {
+				t.Fatalf("got directory entries %v: %v", entries, err)
+			}
+			fileInfo, err := os.Stat(path)
+			if err != nil || fileInfo.Mode().Perm() != 0640 || !fileInfo.ModTime().Equal(modTime) {
+				t.Fatalf("got file info %v: %v", fileInfo, err)
+			}
+			nf := New()
+			if err := nf.Open(path); err != nil {
+				t.Fatal(err)
+			}
+			defer nf.Close()
+			info := nf.Info()
+			if info.Layout != test.layout || info.Compression != CompressionZSTD || info.BlockSize != 1<<20 ||
+				info.FlowBlocks != 1 || info.Created != before.Created {
+				t.Fatalf("got info %+v, was %+v", info, before)
+			}
+			if nf.Ident() != "router-1" || nf.Stat() != writer.Stat() {
+				t.Fatalf("got ident %q and stat %+v", nf.Ident(), nf.Stat())
+			}
+			i := 0
+			if err := nf.Walk(context.Background(), func(record FlowRecord) error {
+				if i >= len(test.records) || !bytes.Equal(record.raw, test.records[i].raw) {
+					return fmt.Errorf("record %d differs", i)
+				}
+				i++
+				return nil
+			}); err != nil || i != len(test.records) {
+				t.Fatalf("read %d records: %v", i, err)
+			}
+			list := nf.GetExporterList()
+			if len(list) < 2 || list[1].Id != 7 || list[1].Packets != 100 || len(list[1].SamplerList) != 1 {
+				t.Fatalf("got exporters %+v", list)
+			}
+
+			// Re-blocking keeps the codec, and no options keep both.
+			if err := Recompress(context.Background(), path, WithBlockSize(8192)); err != nil {
+				t.Fatal(err)
+			}
+			if err := Recompress(context.Background(), path); err != nil {
+				t.Fatal(err)
+			}
+			after, _, err := Probe(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.Layout != test.layout || after.Compression != CompressionZSTD || after.BlockSize != 8192 {
+				t.Fatalf("got info %+v after re-blocking", after)
+			}
+		})
+	}
+
+	// V3 blocks may be larger than the 5 MiB of V2 blocks.
+	path := filepath.Join(t.TempDir(), "nfcapd.202610161235")
+	writer, err := Create(path, WithLayout(FileLayoutV3), WithBlockSize(8<<20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range writerTestV4Records(t) {
+		if err := writer.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Recompress(context.Background(), path, WithCompression(CompressionLZ4)); err != nil {
+		t.Fatal(err)
+	}
+	info, _, err := Probe(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Compression != CompressionLZ4 || info.BlockSize != 8<<20 {
+		t.Fatalf("got info %+v", info)
+	}
+}
+
+func TestSplitAndMerge(t *testing.T) {
+	for date, want := range map[string]string{"2026-10-16": "2026/41/5-41/5-289", "2026-01-04": "2026/00/7-01/0-004"} {
+		day, _ := time.Parse(time.DateOnly, date)
+		if got := strftime("%Y/%W/%u-%U/%w-%j", day); got != want {
+			t.Fatalf("%s: got %q, want %q", date, got, want)
+		}
+	}
+
+	exporters := []Exporter{
+		{IP: net.IPv4(192, 0, 2, 1), SysId: 1, Version: 10, Id: 7,
+			SamplerList: []Sampler{{Id: 5, Algorithm: 1, PacketInterval: 100}}},
+		{IP: net.ParseIP("2001:db8::7"), SysId: 2, Version: 9, Id: 8, isV6: true},
+	}
+	start := uint64(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC).UnixMilli())
+	var builder RecordBuilder
+	flow := func(t *testing.T, exporterID uint32, minute uint64, timed bool) FlowRecord {
+		t.Helper()
+		builder.Reset().SetExporter(exporterID).
+			SetAddrs(netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2"))
+		if timed {
+			msec := start + minute*60000
+			builder.SetGeneric(GenericFlow{MsecFirst: msec, MsecLast: msec + 1000, MsecReceived: msec + 30*60000,
+				InPackets: 2, InBytes: 100, Proto: 6})
+		}
+		record, err := builder.Build(RecordFormatV3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return record.Clone()
+	}
+	writeFile := func(t *testing.T, path string, exporters []Exporter, records ...FlowRecord) {
+		t.Helper()
+		writer, err := Create(path, WithIdent("router-1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.WriteExporters(exporters...); err != nil {
+			t.Fatal(err)
+		}
+		for _, record := range records {
+			if err := writer.Write(record); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	open := func(t *testing.T, path string) *NfFile {
+		t.Helper()
+		nf := New()
+		if err := nf.Open(path); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { nf.Close() })
+		return nf
+	}
+	readAll := func(t *testing.T, nf *NfFile) []FlowRecord {
+		t.Helper()
+		var records []FlowRecord
+		if err := nf.Walk(context.Background(), func(record FlowRecord) error {
+			records = append(records, record.Clone())
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return records
+	}
+
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "source.nf")
+	writeFile(t, srcPath, exporters,
+		flow(t, 1, 0, true), flow(t, 1, 2, true), flow(t, 2, 6, true), flow(t, 1, 11, true), flow(t, 1, 0, false))
+
+	outDir := filepath.Join(dir, "flows")
+	split := func() ([]string, error) {
+		return Split(context.Background(), outDir, []*NfFile{open(t, srcPath)}, WithSubdirs(SubdirLayouts[1]),
+			WithLocation(time.UTC), WithOutputOptions(WithLayout(FileLayoutV3)))
+	}
+	paths, err := split()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(outDir, "2026/10/16/nfcapd.202610161200"),
+		filepath.Join(outDir, "2026/10/16/nfcapd.202610161205"),
+		filepath.Join(outDir, "2026/10/16/nfcapd.202610161210"),
+	}
+	if !slices.Equal(paths, want) {
+		t.Fatalf("got files %v", paths)
+	}
+	for i, test := range []struct {
+		records  int
+		exporter int
+		minute   uint64
+	}{{2, 1, 0}, {1, 2, 6}, {2, 1, 11}} {
+		nf := open(t, paths[i])
+		records := readAll(t, nf)
+		stat := nf.Stat()
+		if len(records) != test.records || stat.Numflows != uint64(test.records) || nf.Ident() != "router-1" ||
+			nf.Info().Layout != FileLayoutV3 || records[0].format != RecordFormatV4 {
+			t.Fatalf("%s: got %d records, stat %+v, ident %q", paths[i], len(records), stat, nf.Ident())
+		}
+		if stat.FirstSeen != start+test.minute*60000 {
+			t.Fatalf("%s: got first seen %d", paths[i], stat.FirstSeen)
+		}
+		list := nf.GetExporterList()
+		for sysID, exporter := range list {
+			if (exporter.IP != nil) != (sysID == test.exporter) {
+				t.Fatalf("%s: got exporters %+v", paths[i], list)
+			}
+		}
+		if test.exporter == 1 && (len(list[1].SamplerList) != 1 || list[1].SamplerList[0] != exporters[0].SamplerList[0]) {
+			t.Fatalf("%s: got samplers %+v", paths[i], list[1].SamplerList)
+		}
+	}
+
+	// Split does not overwrite existing files.
+	if _, err := split(); err == nil {
+		t.Fatal("split overwrote files")
+	}
+	if _, err := os.Stat(paths[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Received times put all flows into one slot of an hour.
+	hourly, err := Split(context.Background(), filepath.Join(dir, "hourly"), []*NfFile{open(t, srcPath)},
+		WithReceivedTime(), WithInterval(time.Hour), WithLocation(time.UTC))
+	if err != nil || len(hourly) != 1 || filepath.Base(hourly[0]) != "nfcapd.202610161200" {
+		t.Fatalf("got files %v: %v", hourly, err)
+	}
+
+	// Merging assigns a new ID to another exporter with the same ID.
+	otherPath := filepath.Join(dir, "other.nf")
+	other := Exporter{IP: net.IPv4(192, 0, 2, 99), SysId: 1, Version: 10, Id: 9}
+	writeFile(t, otherPath, []Exporter{other}, flow(t, 1, 15, true))
+	mergedPath := filepath.Join(dir, "merged.nf")
+	merged, err := Create(mergedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srcs := []*NfFile{open(t, paths[0]), open(t, paths[1]), open(t, paths[2]), open(t, otherPath)}
+	if err := Merge(context.Background(), merged, srcs...); err != nil {
+		t.Fatal(err)
+	}
+	if err := merged.Close(); err != nil {
+		t.Fatal(err)
+	}
+	nf := open(t, mergedPath)
+	records := readAll(t, nf)
+	if len(records) != 6 || nf.Stat().Numflows != 6 || nf.Ident() != "router-1" || records[0].format != RecordFormatV3 {
+		t.Fatalf("got %d records, stat %+v, ident %q", len(records), nf.Stat(), nf.Ident())
+	}
+	list := nf.GetExporterList()
+	if len(list) < 4 || !list[1].IP.Equal(exporters[0].IP) || len(list[1].SamplerList) != 1 ||
+		!list[2].IP.Equal(exporters[1].IP) || !list[3].IP.Equal(other.IP) || list[3].Id != 9 {
+		t.Fatalf("got exporters %+v", list)
+	}
+	for i, want := range []uint32{1, 1, 2, 1, 1, 3} {
+		if records[i].ExporterID() != want {
+			t.Fatalf("record %d: got exporter %d, want %d", i, records[i].ExporterID(), want)
+		}
+	}
+
+	// The ID of an exporter without an exporter record is not given to
+	// another exporter, and may not be used once it was.
+	unknownPath := filepath.Join(dir, "unknown.nf")
+	writeFile(t, unknownPath, nil, flow(t, 1, 20, true))
+	mergedPath = filepath.Join(dir, "unknown-merged.nf")
+	merged, err = Create(mergedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Merge(context.Background(), merged, open(t, unknownPath), open(t, otherPath)); err != nil {
+		t.Fatal(err)
+	}
+	if err := merged.Close(); err != nil {
+		t.Fatal(err)
+	}
+	nf = open(t, mergedPath)
+	records = readAll(t, nf)
+	if len(records) != 2 || records[0].ExporterID() != 1 || records[1].ExporterID() != 2 ||
+		nf.GetExporterList()[1].IP != nil || !nf.GetExporterList()[2].IP.Equal(other.IP) {
+		t.Fatalf("got %d records, exporters %+v", len(records), nf.GetExporterList())
+	}
+	merged, err = Create(filepath.Join(dir, "unknown-last.nf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer merged.Close()
+	if err := Merge(context.Background(), merged, open(t, otherPath), open(t, unknownPath)); err == nil {
+		t.Fatal("merged an unknown exporter ID used by another exporter")
+	}
+}
+
+func TestRotatingWriter(t *testing.T) {
+	dir := t.TempDir()
+	// A statistics file of nfexpire keeps its limits.
+	if err := os.WriteFile(filepath.Join(dir, ".nfstat"), []byte("# Last update\nmaxsize=1000000\nlifetime=86400\nwatermark=90\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 16, 12, 1, 0, 0, time.UTC)
+	rotatingWriter, err := NewRotatingWriter(dir, WithRotateClock(func() time.Time { return now }),
+		WithRotateSubdirs(SubdirLayouts[1]), WithRotateLocation(time.UTC),
+		WithRotateFileOptions(WithLayout(FileLayoutV3), WithIdent("collector")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := filepath.Join(dir, fmt.Sprintf("nfcapd.current.%d", os.Getpid()))
+	if _, err := os.Stat(current); err != nil || rotatingWriter.RecordFormat() != RecordFormatV4 {
+		t.Fatalf("no current file: %v", err)
+	}
+	exporter := Exporter{IP: net.IPv4(192, 0, 2, 1), SysId: 1, Version: 10, Id: 7, Packets: 100, Flows: 20,
+		SamplerList: []Sampler{{Id: 5, Algorithm: 1, PacketInterval: 100}}}
+	if err := rotatingWriter.WriteExporters(exporter); err != nil {
+		t.Fatal(err)
+	}
+	records := writerTestV4Records(t)
+	write := func(t *testing.T, minute int, records ...FlowRecord) {
+		t.Helper()
+		now = time.Date(2026, 10, 16, 12, minute, 0, 0, time.UTC)
+		for _, record := range records {
+			if err := rotatingWriter.Write(record); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	write(t, 1, records...)
+	write(t, 4, records[0])
+	write(t, 6, records[0])
+	now = time.Date(2026, 10, 16, 12, 9, 59, 0, time.UTC)
+	if err := rotatingWriter.Tick(); err != nil {
+		t.Fatal(err)
+	}
+	now = time.Date(2026, 10, 16, 12, 13, 0, 0, time.UTC)
+	if err := rotatingWriter.Tick(); err != nil {
+		t.Fatal(err)
+	}
+	now = time.Date(2026, 10, 16, 12, 14, 0, 0, time.UTC)
+	if err := rotatingWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rotatingWriter.Write(records[0]); err == nil {
+		t.Fatal("write after close succeeded")
+	}
+	if _, err := os.Stat(current); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("current file left: %v", err)
+	}
+
+	var size uint64
+	for _, test := range []struct {
+		name     string
+		records  int
+		counters bool
+	}{
+		{"nfcapd.202610161200", len(records) + 1, true},
+		{"nfcapd.202610161205", 1, false},
+		{"nfcapd.202610161210", 0, false},
+	} {
+		path := filepath.Join(dir, "2026/10/16", test.name)
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += diskUsage(fileInfo)
+		nf := New()
+		if err := nf.Open(path); err != nil {
+			t.Fatal(err)
+		}
+		defer nf.Close()
+		count := 0
+		if err := nf.Walk(context.Background(), func(record FlowRecord) error {
+			count++
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		list := nf.GetExporterList()
+		if count != test.records || nf.Stat().Numflows != uint64(count) || nf.Ident() != "collector" ||
+			len(list) < 2 || list[1].Id != 7 || len(list[1].SamplerList) != 1 || (list[1].Packets == 100) != test.counters {
+			t.Fatalf("%s: got %d records, stat %+v, exporters %+v", test.name, count, nf.Stat(), list)
+		}
+	}
+
+	stat, err := readDirStat(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := uint64(time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC).Unix())
+	want := dirStat{first: first, last: first + 600, size: size, maxSize: 1000000, numFiles: 3, lifetime: 86400, watermark: 90}
+	if stat != want {
+		t.Fatalf("got dirstat %+v, want %+v", stat, want)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	exporters := []Exporter{
+		{IP: net.IPv4(192, 0, 2, 1), SysId: 1, Version: 10, Id: 7, Packets: 100, Flows: 20},
+		{IP: net.IPv4(192, 0, 2, 2), SysId: 2, Version: 9, Id: 8,
+			SamplerList: []Sampler{{Id: 5, Algorithm: 1, PacketInterval: 100}}},
+	}
+	var builder RecordBuilder
+	var records []FlowRecord
+	for i := range 6 {
+		record, err := builder.Reset().SetExporter(uint32(1+i%2)).
+			SetGeneric(GenericFlow{MsecFirst: uint64(1000 * i), InPackets: 1, InBytes: uint64(100 * i), Proto: 17}).
+			SetAddrs(netip.AddrFrom4([4]byte{10, 0, 0, byte(i)}), netip.MustParseAddr("10.1.0.1")).
+			Build(RecordFormatV4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record.Clone())
+	}
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "source.nf")
+	writer, err := Create(srcPath, WithLayout(FileLayoutV3), WithIdent("router-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.WriteExporters(exporters...); err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if err := writer.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	src := New()
+	if err := src.Open(srcPath); err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+
+	// Every flow of exporter 2 after the first second.
+	keep := func(record FlowRecord) bool {
+		generic, _ := record.Generic()
+		return record.ExporterID() == 2 && generic.MsecFirst > 1000
+	}
+	dstPath := filepath.Join(dir, "extract.nf")
+	dst, err := Create(dstPath, WithLayout(FileLayoutV3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Extract(context.Background(), src, dst, nil); err == nil || !strings.Contains(err.Error(), "nil keep") {
+		t.Fatalf("extracted with nil keep function: %v", err)
+	}
+	written, err := Extract(context.Background(), src, dst, keep)
+	if err != nil || written != 2 {
+		t.Fatalf("extracted %d flows: %v", written, err)
+	}
+	if err := dst.Close(); err != nil {
+		t.Fatal(err)
+	}
+	nf := New()
+	if err := nf.Open(dstPath); err != nil {
+		t.Fatal(err)
+	}
+	defer nf.Close()
+	var extracted []FlowRecord
+	if err := nf.Walk(context.Background(), func(record FlowRecord) error {
+		extracted = append(extracted, record.Clone())
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(extracted) != 2 || !bytes.Equal(extracted[0].raw, records[3].raw) || !bytes.Equal(extracted[1].raw, records[5].raw) {
+		t.Fatalf("got records %v", extracted)
+	}
+	stat := nf.Stat()
+	if stat.Numflows != 2 || stat.Numbytes != 800 || stat.FirstSeen != 3000 || nf.Ident() != "router-1" {
+		t.Fatalf("got stat %+v, ident %q", stat, nf.Ident())
+	}
+	list := nf.GetExporterList()
+	if len(list) < 3 || list[1].IP != nil || !list[2].IP.Equal(exporters[1].IP) || len(list[2].SamplerList) != 1 {
+		t.Fatalf("got exporters %+v", list)
+	}
+
+	// Any Writer can be extracted to.
+	rotateDir := filepath.Join(dir, "rotate")
+	if err := os.Mkdir(rotateDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	rotatingWriter, err := NewRotatingWriter(rotateDir, WithRotateLocation(time.UTC),
+		WithRotateClock(func() time.Time { return time.Date(2026, 10, 16, 12, 1, 0, 0, time.UTC) }),
+		WithRotateFileOptions(WithLayout(FileLayoutV3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err = Extract(context.Background(), src, rotatingWriter, keep)
+	if err != nil || written != 2 {
+		t.Fatalf("extracted %d flows: %v", written, err)
+	}
+	if err := rotatingWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	rotated := New()
+	if err := rotated.Open(filepath.Join(rotateDir, "nfcapd.202610161200")); err != nil {
+		t.Fatal(err)
+	}
+	defer rotated.Close()
+	// Exporter blocks are read by the walk.
+	if err := rotated.Walk(context.Background(), func(FlowRecord) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if stat := rotated.Stat(); stat.Numflows != 2 || !rotated.GetExporterList()[2].IP.Equal(exporters[1].IP) {
+		t.Fatalf("got stat %+v, exporters %+v", stat, rotated.GetExporterList())
+	}
+
+	// The records of a V3 file do not fit into a V2 file.
+	dst, err = Create(filepath.Join(dir, "v2.nf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if _, err := Extract(context.Background(), src, dst, keep); err == nil {
+		t.Fatal("extracted V4 records into a V2 file")
+	}
+}
+
+func TestTypedExtensionAccessors(t *testing.T) {
+	flowMisc := FlowMisc{Input: 3, Output: 7, SrcMask: 24, DstMask: 16, Dir: 1, DstTos: 8, BiFlowDir: 2, FlowEndReason: 3}
+	counters := Counters{Flows: 1, OutPackets: 8, OutBytes: 900}
+	vlan := VLAN{Src: 10, Dst: 20}
+	as := AS{Src: 64500, Dst: 64501}
+	ipInfo := IPInfo{FragmentFlags: 2, MinTTL: 60, MaxTTL: 64}
+	var builder RecordBuilder
+	builder.SetFlowMisc(flowMisc).SetCounters(counters).SetVLAN(vlan).SetAS(as).SetIPInfo(ipInfo)
+	for _, format := range []RecordFormat{RecordFormatV3, RecordFormatV4} {
+		record, err := builder.Build(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := record.FlowMisc(); !ok || got != flowMisc {
+			t.Fatalf("format %d: got flow misc %+v", format, got)
+		}
+		if got, ok := record.Counters(); !ok || got != counters {
+			t.Fatalf("format %d: got counters %+v", format, got)
+		}
+		if got, ok := record.VLAN(); !ok || got != vlan {
+			t.Fatalf("format %d: got VLAN %+v", format, got)
+		}
+		if got, ok := record.AS(); !ok || got != as {
+			t.Fatalf("format %d: got AS %+v", format, got)
+		}
+		if got, ok := record.IPInfo(); !ok || got != ipInfo {
+			t.Fatalf("format %d: got IP info %+v", format, got)
+		}
+	}
+
+	// A V4 record with interfaces only.
+	record, err := newFlowRecordV4(v4RecordWithElements(t, 0, 1, v4Element{v4ExInterface, []byte{5, 0, 0, 0, 6, 0, 0, 0}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := record.FlowMisc(); !ok || got != (FlowMisc{Input: 5, Output: 6}) {
+		t.Fatalf("got flow misc %+v", got)
+	}
+	// A V3 record without any of them.
+	record, err = newFlowRecordV3(v3RecordWithElements(v3Element{EXgenericFlowID, make([]byte, 48)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := record.FlowMisc(); ok {
+		t.Fatal("got flow misc from a record without it")
+	}
+	if _, ok := record.Counters(); ok {
+		t.Fatal("got counters from a record without them")
+	}
+	if _, ok := record.VLAN(); ok {
+		t.Fatal("got VLAN from a record without it")
+	}
+	if _, ok := record.AS(); ok {
+		t.Fatal("got AS from a record without it")
+	}
+	if _, ok := record.IPInfo(); ok {
+		t.Fatal("got IP info from a record without it")
+	}
+}
+
+func TestNextHopAccessors(t *testing.T) {
+	ipv6 := func(addr string) []byte {
+		b := netip.MustParseAddr(addr).As16()
+		data := binary.LittleEndian.AppendUint64(nil, binary.BigEndian.Uint64(b[0:8]))
+		return binary.LittleEndian.AppendUint64(data, binary.BigEndian.Uint64(b[8:16]))
+	}
+	v4Addrs, err := newFlowRecordV3(v3RecordWithElements(
+		v3Element{EXbgpNextHopV4ID, []byte{4, 3, 2, 1}},
+		v3Element{EXipNextHopV4ID, []byte{1, 0, 0, 10}},
+		v3Element{EXipReceivedV4ID, []byte{1, 2, 0, 192}},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v6Addrs, err := newFlowRecordV3(v3RecordWithElements(
+		v3Element{EXbgpNextHopV6ID, ipv6("2001:db8::1")},
+		v3Element{EXipNextHopV6ID, ipv6("fe80::1")},
+		v3Element{EXipReceivedV6ID, ipv6("2001:db8::7")},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	converter := transcoder{format: RecordFormatV4}
+	for _, test := range []struct {
+		record                        FlowRecord
+		bgpNextHop, nextHop, received string
+	}{
+		{v4Addrs, "1.2.3.4", "10.0.0.1", "192.0.2.1"},
+		{v6Addrs, "2001:db8::1", "fe80::1", "2001:db8::7"},
+	} {
+		converted, err := converter.convert(test.record)
+		if err != nil || len(converter.lost) > 0 {
+			t.Fatalf("convert: %v, lost %v", err, converter.lost)
+		}
+		for _, record := range []FlowRecord{test.record, converted.Clone()} {
+			if addr, ok := record.BGPNextHop(); !ok || addr.String() != test.bgpNextHop {
+				t.Fatalf("format %d: got BGP next hop %v", record.format, addr)
+			}
+			if addr, ok := record.IPNextHop(); !ok || addr.String() != test.nextHop {
+				t.Fatalf("format %d: got IP next hop %v", record.format, addr)
+			}
+			if addr, ok := record.Received(); !ok || addr.String() != test.received {
+				t.Fatalf("format %d: got received %v", record.format, addr)
+			}
+		}
+	}
+	record, err := newFlowRecordV3(v3RecordWithElements(v3Element{EXgenericFlowID, make([]byte, 48)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := record.BGPNextHop(); ok {
+		t.Fatal("got BGP next hop from a record without it")
+	}
+	if _, ok := record.IPNextHop(); ok {
+		t.Fatal("got IP next hop from a record without it")
+	}
+	if _, ok := record.Received(); ok {
+		t.Fatal("got received address from a record without it")
+	}
+}
+
+func TestNATAccessors(t *testing.T) {
+	event := NATEvent{MsecEvent: 1792152000123, Event: NATEventPortBlockAllocation, PoolID: 42}
+	portBlock := NATPortBlock{Start: 1024, End: 2047, Step: 1, Size: 1024}
+	var builder RecordBuilder
+	builder.SetNATPorts(40000, 443).SetNATEvent(event).SetNATPortBlock(portBlock)
+	for _, addrs := range [][2]string{{"198.51.100.1", "203.0.113.9"}, {"2001:db8::1", "64:ff9b::cb00:7109"}} {
+		builder.SetNATAddrs(netip.MustParseAddr(addrs[0]), netip.MustParseAddr(addrs[1]))
+		for _, format := range []RecordFormat{RecordFormatV3, RecordFormatV4} {
+			record, err := builder.Build(format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if src, dst, ok := record.NATAddrs(); !ok || src.String() != addrs[0] || dst.String() != addrs[1] {
+				t.Fatalf("format %d: got NAT addresses %v %v", format, src, dst)
+			}
+			if src, dst, ok := record.NATPorts(); !ok || src != 40000 || dst != 443 {
+				t.Fatalf("format %d: got NAT ports %d %d", format, src, dst)
+			}
+			if got, ok := record.NATEvent(); !ok || got != event {
+				t.Fatalf("format %d: got NAT event %+v", format, got)
+			}
+			if got, ok := record.NATPortBlock(); !ok || got != portBlock {
+				t.Fatalf("format %d: got NAT port block %+v", format, got)
+			}
+		}
+	}
+
+	record, err := newFlowRecordV3(v3RecordWithElements(v3Element{EXgenericFlowID, make([]byte, 48)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok := record.NATAddrs(); ok {
+		t.Fatal("got NAT addresses from a record without them")
+	}
+	if _, _, ok := record.NATPorts(); ok {
+		t.Fatal("got NAT ports from a record without them")
+	}
+	if _, ok := record.NATEvent(); ok {
+		t.Fatal("got NAT event from a record without it")
+	}
+	if _, ok := record.NATPortBlock(); ok {
+		t.Fatal("got NAT port block from a record without it")
+	}
+
+	for event, want := range map[NATEventType]string{
+		NATEventNAT44SessionCreate:  "NAT44 session create",
+		NATEventPortBlockAllocation: "port block allocation",
+		0:                           "NAT event 0",
+		200:                         "NAT event 200",
+	} {
+		if got := event.String(); got != want {
+			t.Fatalf("event %d: got %q, want %q", uint8(event), got, want)
+		}
+	}
+}
+
+func TestMACAndLayer2Accessors(t *testing.T) {
+	mac := func(addr string) []byte {
+		hw, err := net.ParseMAC(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return binary.LittleEndian.AppendUint64(nil, uint64(hw[0])<<40|uint64(hw[1])<<32|uint64(hw[2])<<24|
+			uint64(hw[3])<<16|uint64(hw[4])<<8|uint64(hw[5]))
+	}
+	macs := slices.Concat(mac("00:1b:21:3a:4f:01"), make([]byte, 8), mac("3c:fd:fe:9e:7a:02"), mac("00:00:5e:00:53:af"))
+	layer2 := Layer2{VLAN: 100, CustomerVLAN: 200, PostVLAN: 101, PostCustomerVLAN: 201,
+		Ingress: 7, Egress: 9, VXLAN: 5001, EtherType: 0x86dd, IPVersion: 6}
+	data := binary.LittleEndian.AppendUint16(nil, layer2.VLAN)
+	data = binary.LittleEndian.AppendUint16(data, layer2.CustomerVLAN)
+	data = binary.LittleEndian.AppendUint16(data, layer2.PostVLAN)
+	data = binary.LittleEndian.AppendUint16(data, layer2.PostCustomerVLAN)
+	data = binary.LittleEndian.AppendUint32(data, layer2.Ingress)
+	data = binary.LittleEndian.AppendUint32(data, layer2.Egress)
+	data = binary.LittleEndian.AppendUint64(data, layer2.VXLAN)
+	data = binary.LittleEndian.AppendUint16(data, layer2.EtherType)
+	data = append(data, layer2.IPVersion, 0, 0, 0, 0, 0)
+
+	v3, err := newFlowRecordV3(v3RecordWithElements(v3Element{EXmacAddrID, macs}, v3Element{EXlayer2ID, data}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	converter := transcoder{format: RecordFormatV4}
+	v4, err := converter.convert(v3)
+	if err != nil || len(converter.lost) > 0 {
+		t.Fatalf("convert: %v, lost %v", err, converter.lost)
+	}
+	for _, record := range []FlowRecord{v3, v4} {
+		got, ok := record.MAC()
+		if !ok || got.InSrc.String() != "00:1b:21:3a:4f:01" || got.OutDst != nil ||
+			got.InDst.String() != "3c:fd:fe:9e:7a:02" || got.OutSrc.String() != "00:00:5e:00:53:af" {
+			t.Fatalf("format %d: got MAC %+v", record.format, got)
+		}
+		if got, ok := record.Layer2(); !ok || got != layer2 {
+			t.Fatalf("format %d: got layer 2 %+v", record.format, got)
+		}
+	}
+
+	record, err := newFlowRecordV3(v3RecordWithElements(v3Element{EXgenericFlowID, make([]byte, 48)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := record.MAC(); ok {
+		t.Fatal("got MAC from a record without it")
+	}
+	if _, ok := record.Layer2(); ok {
+		t.Fatal("got layer 2 from a record without it")
+	}
+}
+
 func TestV4RecordRejectsInvalidExtensionOffset(t *testing.T) {
 	record := v4RecordWithElements(t, 0, 1, v4Element{id: 1, data: make([]byte, 48)})
 	binary.LittleEndian.PutUint16(record[v4RecordHeaderSize:], 24)
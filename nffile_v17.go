@@ -12,9 +12,6 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"os"
-
-	zstd "github.com/klauspost/compress/zstd"
 )
 
 // v17Reader handles the V1/V2 containers and V3 flow records emitted by
@@ -22,23 +19,34 @@ import (
 // file-format contract.
 type v17Reader struct {
 	owner       *NfFile
-	file        *os.File
+	src         source
 	header      NfFileHeader
-	zstdDecoder *zstd.Decoder
+	dataOffset  int64 // file offset of the first data block
+	order       binary.ByteOrder
+	zstdDecoder sharedZstd
 	// readBuf is a scratch buffer for a block's on-disk (possibly compressed)
 	// bytes. Blocks are always read sequentially by a single goroutine (the
 	// producer in walk, or the caller of ReadDataBlocks), and readBuf's
-	// contents are never referenced once uncompressBlock returns, so reusing
-	// it across blocks is safe and avoids a fresh allocation per block.
+	// contents are never referenced once the block is decoded, so reusing it
+	// across blocks is safe and avoids a fresh allocation per block. Walks
+	// with decode workers read into owned buffers instead.
 	readBuf []byte
+	// extensionMaps and v1Record carry the V1 record decoding state. They are
+	// only used by processDataBlock, which runs sequentially in file order.
+	extensionMaps v1ExtensionMaps
+	v1Record      []byte
+	// appendixErr is the error of an appendix that could not be read when
+	// the file was opened for VerifyFile.
+	appendixErr error
 }
 
-func openV17ReaderV2(owner *NfFile, file *os.File, fileName string) (*v17Reader, error) {
+func openV17ReaderV2(owner *NfFile, src source, fileName string, order binary.ByteOrder) (*v17Reader, error) {
 	var header NfFileHeader
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return nil, fmt.Errorf("nfFile seek header: %w", err)
+	file, err := src.reader(0)
+	if err != nil {
+		return nil, fmt.Errorf("nfFile read V2 header on %s: %w", fileName, err)
 	}
-	if err := binary.Read(file, binary.LittleEndian, &header); err != nil {
+	if err := binary.Read(file, order, &header); err != nil {
 		return nil, fmt.Errorf("nfFile read V2 header on %s: %w", fileName, err)
 	}
 	if header.BlockSize > BUFFSIZE {
@@ -50,17 +58,11 @@ func openV17ReaderV2(owner *NfFile, file *os.File, fileName string) (*v17Reader,
 	if header.Encryption != 0 {
 		return nil, fmt.Errorf("nfFile encrypted files are not supported")
 	}
-	if header.AppendixBlocks > 0 {
-		info, err := file.Stat()
-		if err != nil {
-			return nil, fmt.Errorf("nfFile stat %s: %w", fileName, err)
-		}
-		if header.OffAppendix >= uint64(info.Size()) {
-			return nil, fmt.Errorf("nfFile invalid appendix offset: %d", header.OffAppendix)
-		}
+	if src.stream == nil && header.AppendixBlocks > 0 && header.OffAppendix >= uint64(src.size) {
+		return nil, fmt.Errorf("nfFile invalid appendix offset: %d", header.OffAppendix)
 	}
 
-	reader := &v17Reader{owner: owner, file: file, header: header}
+	reader := &v17Reader{owner: owner, src: src, header: header, dataOffset: int64(binary.Size(header)), order: order}
 	owner.Header = header // Deprecated V1/V2 compatibility field.
 	owner.info = FileInfo{
 		Layout:        FileLayoutV2,
@@ -70,10 +72,18 @@ func openV17ReaderV2(owner *NfFile, file *os.File, fileName string) (*v17Reader,
 		Encrypted:     header.Encryption != 0,
 		BlockSize:     header.BlockSize,
 		FlowBlocks:    header.NumBlocks,
+		BigEndian:     order == binary.BigEndian,
 	}
-	if err := reader.readAppendix(); err != nil {
-		reader.close()
-		return nil, err
+	// A stream cannot seek ahead to the appendix; readDataBlocks reads it
+	// once the data blocks have been passed.
+	if src.stream == nil {
+		if err := reader.readAppendix(); err != nil {
+			if !owner.options.verify {
+				reader.releaseDecoders()
+				return nil, err
+			}
+			reader.appendixErr = err
+		}
 	}
 	return reader, nil
 }
@@ -87,119 +97,345 @@ func (reader *v17Reader) blockSizeLimit() uint32 {
 	return reader.header.BlockSize
 }
 
+func (reader *v17Reader) releaseDecoders() {
+	reader.zstdDecoder.close()
+}
+
 func (reader *v17Reader) close() error {
-	if reader.zstdDecoder != nil {
-		reader.zstdDecoder.Close()
-		reader.zstdDecoder = nil
-	}
-	if reader.file == nil {
-		return nil
-	}
-	err := reader.file.Close()
-	reader.file = nil
+	reader.releaseDecoders()
+	err := reader.src.close()
+	reader.src = source{}
 	return err
 }
 
 // readAppendix reads a V2 appendix and updates the owner metadata.
 func (reader *v17Reader) readAppendix() error {
-	currentPos, err := reader.file.Seek(0, io.SeekCurrent)
+	appendix, err := reader.src.reader(int64(reader.header.OffAppendix))
 	if err != nil {
-		return fmt.Errorf("nfFile seek appendix: %w", err)
+		return fmt.Errorf("nfFile read appendix: %w", err)
 	}
-	defer reader.file.Seek(currentPos, io.SeekStart)
-
-	if _, err = reader.file.Seek(int64(reader.header.OffAppendix), io.SeekStart); err != nil {
-		return fmt.Errorf("nfFile seek appendix: %w", err)
+	blocks, err := reader.readAppendixBlocks(appendix, reader.header.AppendixBlocks)
+	if err != nil {
+		return err
 	}
+	return reader.applyAppendix(blocks)
+}
 
-	var blockHeader DataBlockHeader
-	for i := 0; i < int(reader.header.AppendixBlocks); i++ {
-		if err := binary.Read(reader.file, binary.LittleEndian, &blockHeader); err != nil {
-			return fmt.Errorf("nfFile read appendix block: %w", err)
+// readAppendixBlocks reads and decodes numBlocks appendix blocks from
+// appendix.
+func (reader *v17Reader) readAppendixBlocks(appendix io.Reader, numBlocks uint16) ([]DataBlock, error) {
+	var blocks []DataBlock
+	for i := 0; i < int(numBlocks); i++ {
+		var blockHeader DataBlockHeader
+		if err := binary.Read(appendix, reader.order, &blockHeader); err != nil {
+			return nil, fmt.Errorf("nfFile read appendix block: %w", err)
 		}
-		dataBlock, err := reader.uncompressBlock(&blockHeader)
+		data, err := reader.uncompressBlock(appendix, &blockHeader)
 		if err != nil {
-			return fmt.Errorf("nfFile read appendix block: %w", err)
+			return nil, fmt.Errorf("nfFile read appendix block: %w", err)
 		}
-		b := bytes.NewBuffer(dataBlock)
-		for j := 0; j < int(blockHeader.NumRecords); j++ {
-			var record recordHeader
-			if err := binary.Read(b, binary.LittleEndian, &record); err != nil {
-				return fmt.Errorf("read appendix record %d: %w", j, err)
-			}
-			if record.Size < uint16(binary.Size(recordHeader{})) || int(record.Size) > b.Len()+binary.Size(recordHeader{}) {
-				return fmt.Errorf("read appendix record %d: invalid size %d", j, record.Size)
-			}
-			payload := make([]byte, int(record.Size)-binary.Size(recordHeader{}))
-			if _, err := io.ReadFull(b, payload); err != nil {
-				return fmt.Errorf("read appendix record %d: %w", j, err)
-			}
-			switch record.Type {
-			case TYPE_IDENT:
-				reader.owner.ident = string(payload)
-			case TYPE_STAT:
-				if len(payload) != binary.Size(StatRecord{}) {
-					return fmt.Errorf("read appendix record %d: invalid stat size %d", j, record.Size)
-				}
-				if err := binary.Read(bytes.NewReader(payload), binary.LittleEndian, &reader.owner.StatRecord); err != nil {
-					return fmt.Errorf("read appendix record %d: %w", j, err)
-				}
-			}
+		if reader.order == binary.BigEndian {
+			swapRecords(data, blockHeader.NumRecords)
+		}
+		blocks = append(blocks, DataBlock{Header: blockHeader, Data: data})
+	}
+	return blocks, nil
+}
+
+// applyAppendix applies appendix blocks read by readAppendixBlocks to the
+// file metadata.
+func (reader *v17Reader) applyAppendix(blocks []DataBlock) error {
+	for _, block := range blocks {
+		if err := reader.owner.processMetadataRecords(block.Data, block.Header.NumRecords); err != nil {
+			return fmt.Errorf("read appendix: %w", err)
 		}
 	}
 	return nil
 }
 
+// readDataBlocks delivers the decoded data blocks of the legacy
+// ReadDataBlocks API in file order.
 func (reader *v17Reader) readDataBlocks(ctx context.Context, blockChannel chan<- DataBlock) error {
+	appendix, err := reader.produceDataBlocks(ctx, walkConfig{}, func(_ blockRef, decode func() (DataBlock, error)) error {
+		dataBlock, err := decode()
+		if err != nil {
+			return err
+		}
+		select {
+		case blockChannel <- dataBlock:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return reader.applyAppendix(appendix)
+}
+
+// produceDataBlocks reads the data blocks from the start of the file on every
+// call, so a file can be walked more than once, and emits a decode step for
+// each of them. For a parallel cfg the payloads get their own buffers, so the
+// decode steps may run concurrently. A stream is read once, and its appendix
+// is read after the last data block and returned. It is not applied here, as
+// the blocks emitted before it may still be processed.
+//
+// A salvaging walk emits read errors as failing decode steps. After an
+// invalid block header it searches the file for the next plausible one;
+// a stream or a truncated file ends the walk there.
+func (reader *v17Reader) produceDataBlocks(ctx context.Context, cfg walkConfig, emit func(blockRef, func() (DataBlock, error)) error) ([]DataBlock, error) {
+	offset := reader.dataOffset
+	file, err := reader.src.reader(offset)
+	if err != nil {
+		return nil, fmt.Errorf("read data blocks: %w", err)
+	}
+	salvage := cfg.salvage != nil
+	fail := func(ref blockRef, err error) ([]DataBlock, error) {
+		return nil, emit(ref, func() (DataBlock, error) { return DataBlock{}, err })
+	}
+	end := reader.dataEnd()
+	headerSize := int64(binary.Size(DataBlockHeader{}))
 	for i := 0; i < int(reader.header.NumBlocks); i++ {
 		if err := ctx.Err(); err != nil {
-			return err
+			return nil, err
 		}
-		dataBlock := DataBlock{}
-		if err := binary.Read(reader.file, binary.LittleEndian, &dataBlock.Header); err != nil {
-			return fmt.Errorf("read data block %d header: %w", i, err)
+		if salvage && end >= 0 && offset+headerSize > end {
+			break
 		}
-		if dataBlock.Header.Size > reader.blockSizeLimit() {
-			return fmt.Errorf("read data block %d: size %d exceeds block size %d", i, dataBlock.Header.Size, reader.blockSizeLimit())
+		ref := blockRef{index: i, offset: offset}
+		var header DataBlockHeader
+		if err := binary.Read(file, reader.order, &header); err != nil {
+			err = fmt.Errorf("read data block %d header: %w", i, err)
+			if salvage {
+				return fail(ref, err)
+			}
+			return nil, err
 		}
-		if dataBlock.Header.Type != 3 {
-			if _, err := reader.file.Seek(int64(dataBlock.Header.Size), io.SeekCurrent); err != nil {
-				return fmt.Errorf("skip data block %d: %w", i, err)
+		if header.Size > reader.blockSizeLimit() {
+			err := fmt.Errorf("read data block %d: size %d exceeds block size %d", i, header.Size, reader.blockSizeLimit())
+			if !salvage {
+				return nil, err
+			}
+			if _, err := fail(ref, err); err != nil {
+				return nil, err
 			}
+			next, ok := reader.resync(offset+1, end)
+			if !ok {
+				return nil, nil
+			}
+			cfg.salvage.Resyncs++
+			offset = next
+			file = reader.src.section(offset)
 			continue
 		}
-		var err error
-		dataBlock.Data, err = reader.uncompressBlock(&dataBlock.Header)
+		offset += headerSize + int64(header.Size)
+		if header.Type != 3 && (header.Type != 2 || reader.header.Version != 1) {
+			if err := skip(file, int64(header.Size)); err != nil {
+				return nil, fmt.Errorf("skip data block %d: %w", i, err)
+			}
+			continue
+		}
+		payload, err := reader.readBlockPayload(file, &header, cfg.parallel())
 		if err != nil {
-			return fmt.Errorf("read data block %d: %w", i, err)
+			err = fmt.Errorf("read data block %d: %w", i, err)
+			if salvage {
+				return fail(ref, err)
+			}
+			return nil, err
 		}
-		select {
-		case blockChannel <- dataBlock:
-		case <-ctx.Done():
-			return ctx.Err()
+		if err := emit(ref, func() (DataBlock, error) {
+			data, err := reader.decompressBlock(payload, &header, cfg.parallel())
+			if err != nil {
+				return DataBlock{}, fmt.Errorf("read data block %d: %w", ref.index, err)
+			}
+			return DataBlock{Header: header, Data: data}, nil
+		}); err != nil {
+			return nil, err
 		}
 	}
-	return nil
+	if reader.src.stream != nil && reader.header.AppendixBlocks > 0 {
+		// An appendix written ahead of the data blocks has already been
+		// passed and cannot be read from a stream.
+		if int64(reader.header.OffAppendix) < reader.src.stream.offset {
+			return nil, nil
+		}
+		appendix, err := reader.src.reader(int64(reader.header.OffAppendix))
+		if err != nil {
+			return nil, fmt.Errorf("nfFile read appendix: %w", err)
+		}
+		return reader.readAppendixBlocks(appendix, reader.header.AppendixBlocks)
+	}
+	return nil, nil
 }
 
-func (reader *v17Reader) walk(ctx context.Context, cancel context.CancelFunc, fn func(FlowRecord) error) error {
-	checkEvery := reader.owner.walkContextCheckEvery
-	blockChannel := make(chan DataBlock, 2)
-	producerDone := make(chan error, 1)
-	go func() {
-		defer close(blockChannel)
-		producerDone <- reader.readDataBlocks(ctx, blockChannel)
-	}()
-
-	var walkErr error
-	for dataBlock := range blockChannel {
+// followDataBlocks emits the data blocks of a file that is still being
+// written, in file order and as soon as they are complete. The writer leaves
+// NumBlocks at zero until it closes the file; it then appends the appendix
+// and rewrites the header. followDataBlocks ends after the last data block
+// and the appendix of a closed file, or once the file has been renamed and no
+// complete block is left. Like produceDataBlocks it returns the appendix
+// rather than applying it.
+func (reader *v17Reader) followDataBlocks(ctx context.Context, cfg walkConfig, emit func(blockRef, func() (DataBlock, error)) error) ([]DataBlock, error) {
+	follow := cfg.follow
+	// closed is the header rewritten by the writer. The header of the reader
+	// is left alone, as decode workers read it concurrently.
+	var closed *NfFileHeader
+	if header := reader.header; v17HeaderClosed(header) {
+		closed = &header
+	}
+	headerSize := int64(binary.Size(DataBlockHeader{}))
+	raw := make([]byte, headerSize)
+	offset := reader.dataOffset
+	for i := 0; ; {
 		if err := ctx.Err(); err != nil {
-			walkErr = err
-			cancel()
-			break
+			return nil, err
 		}
+		if closed != nil && i >= int(closed.NumBlocks) {
+			if closed.AppendixBlocks == 0 {
+				return nil, nil
+			}
+			size, err := follow.size()
+			if err != nil {
+				return nil, fmt.Errorf("follow V2 file: %w", err)
+			}
+			appendix := io.NewSectionReader(reader.src, int64(closed.OffAppendix), size-int64(closed.OffAppendix))
+			return reader.readAppendixBlocks(appendix, closed.AppendixBlocks)
+		}
+		ended := follow.ended()
+		size, err := follow.size()
+		if err != nil {
+			return nil, fmt.Errorf("follow V2 file: %w", err)
+		}
+		if offset+headerSize <= size {
+			if err := reader.src.readAt(raw, offset); err != nil {
+				return nil, fmt.Errorf("read data block %d header: %w", i, err)
+			}
+			var header DataBlockHeader
+			if err := binary.Read(bytes.NewReader(raw), reader.order, &header); err != nil {
+				return nil, fmt.Errorf("read data block %d header: %w", i, err)
+			}
+			if header.Size > reader.blockSizeLimit() {
+				return nil, fmt.Errorf("read data block %d: size %d exceeds block size %d", i, header.Size, reader.blockSizeLimit())
+			}
+			if end := offset + headerSize + int64(header.Size); end <= size {
+				ref := blockRef{index: i, offset: offset}
+				block := io.NewSectionReader(reader.src, offset+headerSize, int64(header.Size))
+				offset = end
+				i++
+				if header.Type != 3 {
+					continue
+				}
+				payload, err := reader.readBlockPayload(block, &header, cfg.parallel())
+				if err != nil {
+					return nil, fmt.Errorf("read data block %d: %w", ref.index, err)
+				}
+				if err := emit(ref, func() (DataBlock, error) {
+					data, err := reader.decompressBlock(payload, &header, cfg.parallel())
+					if err != nil {
+						return DataBlock{}, fmt.Errorf("read data block %d: %w", ref.index, err)
+					}
+					return DataBlock{Header: header, Data: data}, nil
+				}); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+		if closed == nil {
+			var header NfFileHeader
+			if err := binary.Read(io.NewSectionReader(reader.src, 0, int64(binary.Size(header))), reader.order, &header); err != nil {
+				return nil, fmt.Errorf("read V2 header: %w", err)
+			}
+			if v17HeaderClosed(header) {
+				closed = &header
+				continue
+			}
+		} else {
+			return nil, fmt.Errorf("read data block %d: file truncated at offset %d", i, offset)
+		}
+		if ended {
+			return nil, nil
+		}
+		if err := follow.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// v17HeaderClosed reports whether the writer has finalised header. The block
+// count and the appendix are only filled in when the file is closed.
+func v17HeaderClosed(header NfFileHeader) bool {
+	return header.NumBlocks != 0 || header.AppendixBlocks != 0
+}
+
+// dataEnd returns the file offset where the data blocks end: the appendix
+// if it follows them, or the end of the file. It is -1 for a stream.
+func (reader *v17Reader) dataEnd() int64 {
+	if reader.src.stream != nil {
+		return -1
+	}
+	if reader.header.AppendixBlocks > 0 && int64(reader.header.OffAppendix) >= reader.dataOffset {
+		return int64(reader.header.OffAppendix)
+	}
+	return reader.src.size
+}
+
+// resync searches from..end for the next plausible data block header. A
+// stream cannot be searched.
+func (reader *v17Reader) resync(from, end int64) (int64, bool) {
+	if reader.src.stream != nil {
+		return 0, false
+	}
+	const window = 64 * 1024
+	headerSize := int64(binary.Size(DataBlockHeader{}))
+	buf := make([]byte, window+headerSize)
+	for start := from; start+headerSize <= end; start += window {
+		n := min(int64(len(buf)), end-start)
+		if err := reader.src.readAt(buf[:n], start); err != nil {
+			return 0, false
+		}
+		for pos := int64(0); pos+headerSize <= n && pos < window; pos++ {
+			if reader.plausibleBlockHeader(buf[pos:pos+headerSize], end-start-pos-headerSize) {
+				return start + pos, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// plausibleBlockHeader reports whether raw looks like the header of a data
+// block whose payload fits into the remaining bytes.
+func (reader *v17Reader) plausibleBlockHeader(raw []byte, remaining int64) bool {
+	numRecords := reader.order.Uint32(raw[0:4])
+	size := reader.order.Uint32(raw[4:8])
+	blockType := reader.order.Uint16(raw[8:10])
+	flags := reader.order.Uint16(raw[10:12])
+	if blockType != 3 && (blockType != 2 || reader.header.Version != 1) {
+		return false
+	}
+	limit := reader.blockSizeLimit()
+	return size > 0 && size <= limit && int64(size) <= remaining &&
+		numRecords > 0 && numRecords <= limit/4 && flags&^0x7 == 0
+}
+
+// walk reads every block in file order. V1/V2 blocks carry no time bounds,
+// so a time window in cfg is left to the record filter of the caller.
+func (reader *v17Reader) walk(ctx context.Context, cancel context.CancelFunc, cfg walkConfig, fn func(FlowRecord) error) error {
+	checkEvery := reader.owner.walkContextCheckEvery
+	var appendix []DataBlock
+	produce := func(emit func(blockRef, func() (DataBlock, error)) error) error {
+		var err error
+		if cfg.follow != nil {
+			appendix, err = reader.followDataBlocks(ctx, cfg, emit)
+		} else {
+			appendix, err = reader.produceDataBlocks(ctx, cfg, emit)
+		}
+		return err
+	}
+	err := runPipeline(ctx, cancel, cfg, produce, func(dataBlock DataBlock) error {
 		flowCount, nextCheck := uint32(0), uint32(0)
-		walkErr = reader.processDataBlock(dataBlock, func(raw []byte) error {
+		return reader.processDataBlock(dataBlock, func(raw []byte) error {
 			if checkEvery != 0 && flowCount == nextCheck {
 				if err := ctx.Err(); err != nil {
 					return err
@@ -213,18 +449,19 @@ func (reader *v17Reader) walk(ctx context.Context, cancel context.CancelFunc, fn
 			}
 			return fn(record)
 		})
-		if walkErr != nil {
-			cancel()
-			break
-		}
-	}
-	producerErr := <-producerDone
-	if walkErr != nil {
-		return walkErr
+	})
+	if err != nil {
+		return err
 	}
-	return producerErr
+	// The appendix of a stream or a followed file is applied once fn has
+	// seen every record; the producer reads it while fn may still run on
+	// earlier blocks.
+	return reader.applyAppendix(appendix)
 }
 
+// processDataBlock hands the flow records of dataBlock to handleFlow and
+// applies its metadata records. The records of a big-endian file are
+// converted to little-endian order in place.
 func (reader *v17Reader) processDataBlock(dataBlock DataBlock, handleFlow func([]byte) error) error {
 	if int(dataBlock.Header.Size) != len(dataBlock.Data) {
 		return fmt.Errorf("data block size mismatch: header %d, data %d", dataBlock.Header.Size, len(dataBlock.Data))
@@ -234,17 +471,44 @@ func (reader *v17Reader) processDataBlock(dataBlock DataBlock, handleFlow func([
 		if len(dataBlock.Data)-offset < binary.Size(recordHeader{}) {
 			return fmt.Errorf("data block record %d: truncated record header", i)
 		}
+		if reader.order == binary.BigEndian {
+			swapRecordHeader(dataBlock.Data[offset:])
+		}
 		recordType := binary.LittleEndian.Uint16(dataBlock.Data[offset : offset+2])
 		recordSize := binary.LittleEndian.Uint16(dataBlock.Data[offset+2 : offset+4])
 		if recordSize < uint16(binary.Size(recordHeader{})) || int(recordSize) > len(dataBlock.Data)-offset {
 			return fmt.Errorf("data block record %d: invalid size %d", i, recordSize)
 		}
 		recordData := dataBlock.Data[offset : offset+int(recordSize)]
+		if reader.order == binary.BigEndian {
+			swapRecordBody(recordType, recordData, reader.extensionMaps)
+		}
 		switch recordType {
 		case V3Record:
 			if err := handleFlow(recordData); err != nil {
 				return fmt.Errorf("data block record %d: %w", i, err)
 			}
+		case v1CommonRecordType:
+			if reader.header.Version != 1 {
+				break
+			}
+			var err error
+			if reader.v1Record, err = appendV3FromV1(reader.v1Record[:0], recordData, reader.extensionMaps); err != nil {
+				return fmt.Errorf("data block record %d: %w", i, err)
+			}
+			if err := handleFlow(reader.v1Record); err != nil {
+				return fmt.Errorf("data block record %d: %w", i, err)
+			}
+		case v1ExtensionMapType:
+			if reader.header.Version != 1 {
+				break
+			}
+			if reader.extensionMaps == nil {
+				reader.extensionMaps = make(v1ExtensionMaps)
+			}
+			if err := reader.extensionMaps.add(recordData); err != nil {
+				return fmt.Errorf("data block record %d: %w", i, err)
+			}
 		case ExporterInfoRecordType:
 			if err := reader.owner.addExporterInfo(recordData); err != nil {
 				return fmt.Errorf("data block record %d: %w", i, err)
@@ -257,6 +521,10 @@ func (reader *v17Reader) processDataBlock(dataBlock DataBlock, handleFlow func([
 			if err := reader.owner.addSampler(recordData); err != nil {
 				return fmt.Errorf("data block record %d: %w", i, err)
 			}
+		case SamplerLegacyRecordType:
+			if err := reader.owner.addLegacySampler(recordData); err != nil {
+				return fmt.Errorf("data block record %d: %w", i, err)
+			}
 		}
 		offset += int(recordSize)
 	}
@@ -265,3 +533,36 @@ func (reader *v17Reader) processDataBlock(dataBlock DataBlock, handleFlow func([
 	}
 	return nil
 }
+
+// verifyContainer reports an appendix Open could not read, follows the
+// chain of data block headers and checks that the announced number of blocks
+// ends exactly where the appendix or the file begins. Only the headers are
+// read.
+func (reader *v17Reader) verifyContainer(report *VerifyReport) {
+	if reader.appendixErr != nil {
+		report.addProblem(VerifyContainer, reader.appendixErr)
+	}
+	end := reader.dataEnd()
+	if end < 0 {
+		return
+	}
+	if reader.header.AppendixBlocks > 0 && int64(reader.header.OffAppendix) < reader.dataOffset {
+		report.addProblem(VerifyContainer, fmt.Errorf("appendix offset %d inside the file header", reader.header.OffAppendix))
+	}
+	raw := make([]byte, binary.Size(DataBlockHeader{}))
+	offset := reader.dataOffset
+	for i := 0; i < int(reader.header.NumBlocks); i++ {
+		if offset+int64(len(raw)) > end {
+			report.addProblem(VerifyContainer, fmt.Errorf("file ends after %d of %d data blocks", i, reader.header.NumBlocks))
+			return
+		}
+		if err := reader.src.readAt(raw, offset); err != nil {
+			report.addProblem(VerifyContainer, fmt.Errorf("read data block %d header: %w", i, err))
+			return
+		}
+		offset += int64(len(raw)) + int64(reader.order.Uint32(raw[4:8]))
+	}
+	if offset != end {
+		report.addProblem(VerifyContainer, fmt.Errorf("data blocks end at offset %d, want %d", offset, end))
+	}
+}
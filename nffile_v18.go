@@ -8,11 +8,12 @@ package nfdump
 
 import (
 	"context"
+	"crypto/cipher"
 	"encoding/binary"
 	"fmt"
-	"os"
-
-	zstd "github.com/klauspost/compress/zstd"
+	"io"
+	"slices"
+	"sort"
 )
 
 const (
@@ -22,13 +23,45 @@ const (
 	v18DirectoryEnt  = 16
 	v18BlockHeader   = 24
 	v18FlowBlockHead = 56
+	v18MetaBlockHead = 32
+
+	// A flow block head holds the number of records at offset 24. The V3
+	// writer of this package stores the first and last flow time of the
+	// block, in msec, at offsets 32 and 40; zero times mean the bounds were
+	// not recorded. These two fields are not taken from an nfdump 1.8 source,
+	// so they are only trusted in files carrying v18FlagPackageLayout.
+	v18FlowBlockFirst = 32
+	v18FlowBlockLast  = 40
 
 	v18DirectoryMagic = 0xB10CB10C
 	v18FooterMagic    = 0xA50F
-	v18BlockFlow      = 1
 	v18MaxBlockSize   = 64 << 20
 )
 
+// V3 directory block types. Flow blocks carry V4 flow records after the
+// 56-byte flow-block header. The other block types are metadata blocks: a
+// 24-byte block header, the number of records as uint32 and 4 reserved bytes,
+// followed by records using the same type/size record header and payloads as
+// the V2 appendix and exporter records.
+//
+// The metadata block types and their layout are those of the V3 writer of
+// this package; no file written by nfdump 1.8 is at hand to check them
+// against. They are therefore only interpreted in files whose header carries
+// v18FlagPackageLayout. In other files every block but the flow blocks is
+// skipped, like any unknown block type.
+const (
+	v18BlockFlow         = 1
+	v18BlockIdent        = 2
+	v18BlockStat         = 3
+	v18BlockExporter     = 4
+	v18BlockExporterStat = 5
+	v18BlockSampler      = 6
+)
+
+// v18FlagPackageLayout is set in the header flags by the V3 writer of this
+// package.
+const v18FlagPackageLayout = 0x2
+
 type v18Header struct {
 	nfdVersion  uint32
 	created     uint64
@@ -37,6 +70,7 @@ type v18Header struct {
 	blockSize   uint32
 	dirSize     uint32
 	dirOffset   uint64
+	keyCheck    uint64
 }
 
 type v18DirectoryEntry struct {
@@ -47,64 +81,67 @@ type v18DirectoryEntry struct {
 
 type v18Reader struct {
 	owner       *NfFile
-	file        *os.File
+	src         source
 	header      v18Header
 	entries     []v18DirectoryEntry
-	zstdDecoder *zstd.Decoder
+	order       binary.ByteOrder
+	zstdDecoder sharedZstd
+	// aead decrypts blocks of an encrypted file. It is nil for unencrypted
+	// files.
+	aead cipher.AEAD
 	// readBuf is a scratch buffer for the on-disk (possibly compressed) bytes
-	// of the block currently being read. readBlock is only ever called
-	// sequentially from the single producer goroutine in walk, and readBuf's
-	// contents are never referenced once readBlock returns (the decompressed
-	// result lives in its own freshly allocated slice), so reusing it across
-	// blocks is safe and avoids a fresh multi-megabyte allocation per block.
+	// of the block currently being read. Blocks are only ever read
+	// sequentially from the single producer goroutine in walk, and without
+	// decode workers readBuf's contents are never referenced once the block
+	// is decoded (the decompressed result lives in its own freshly allocated
+	// slice), so reusing it across blocks is safe and avoids a fresh
+	// multi-megabyte allocation per block. Walks with decode workers read
+	// into owned buffers instead.
 	readBuf []byte
+	// metadataErrs holds the ident and stat blocks that could not be read
+	// when the file was opened for VerifyFile.
+	metadataErrs []error
 }
 
-func openV18Reader(owner *NfFile, file *os.File, fileName string) (*v18Reader, error) {
-	info, err := file.Stat()
+func openV18Reader(owner *NfFile, src source, order binary.ByteOrder) (*v18Reader, error) {
+	if src.stream != nil {
+		return openV18StreamReader(owner, src, order)
+	}
+	reader, err := openV18Directory(owner, src, order)
 	if err != nil {
-		return nil, fmt.Errorf("nfFile stat %s: %w", fileName, err)
+		return nil, err
+	}
+	if err := reader.readAppendix(); err != nil {
+		reader.releaseDecoders()
+		return nil, err
 	}
-	fileSize := info.Size()
+	return reader, nil
+}
+
+// openV18Directory reads the header, footer and block directory of a V3 file.
+func openV18Directory(owner *NfFile, src source, order binary.ByteOrder) (*v18Reader, error) {
+	fileSize := src.size
 	if fileSize < v18HeaderSize+v18FooterSize {
 		return nil, fmt.Errorf("nfFile V3 file too short: %d bytes", fileSize)
 	}
 
 	headerBytes := make([]byte, v18HeaderSize)
-	if _, err := file.ReadAt(headerBytes, 0); err != nil {
+	if err := src.readAt(headerBytes, 0); err != nil {
 		return nil, fmt.Errorf("nfFile read V3 header: %w", err)
 	}
-	if binary.LittleEndian.Uint16(headerBytes[0:2]) != 0xA50C || binary.LittleEndian.Uint16(headerBytes[2:4]) != 3 {
-		return nil, fmt.Errorf("nfFile invalid V3 header")
-	}
-	header := v18Header{
-		nfdVersion:  binary.LittleEndian.Uint32(headerBytes[4:8]),
-		created:     binary.LittleEndian.Uint64(headerBytes[8:16]),
-		compression: binary.LittleEndian.Uint16(headerBytes[18:20]),
-		flags:       binary.LittleEndian.Uint32(headerBytes[20:24]),
-		blockSize:   binary.LittleEndian.Uint32(headerBytes[24:28]),
-		dirSize:     binary.LittleEndian.Uint32(headerBytes[28:32]),
-		dirOffset:   binary.LittleEndian.Uint64(headerBytes[32:40]),
-	}
-	if header.blockSize == 0 || header.blockSize > v18MaxBlockSize {
-		return nil, fmt.Errorf("nfFile invalid V3 block size: %d", header.blockSize)
-	}
-	if header.compression > 5 {
-		return nil, fmt.Errorf("nfFile unknown V3 compression: %d", header.compression)
-	}
-	if header.flags&1 != 0 {
-		return nil, fmt.Errorf("nfFile encrypted V3 files are not supported")
+	header, err := parseV18Header(headerBytes, order)
+	if err != nil {
+		return nil, err
 	}
-
 	footerBytes := make([]byte, v18FooterSize)
-	if _, err := file.ReadAt(footerBytes, fileSize-v18FooterSize); err != nil {
+	if err := src.readAt(footerBytes, fileSize-v18FooterSize); err != nil {
 		return nil, fmt.Errorf("nfFile read V3 footer: %w", err)
 	}
-	if binary.LittleEndian.Uint32(footerBytes[0:4]) != v18FooterMagic {
+	if order.Uint32(footerBytes[0:4]) != v18FooterMagic {
 		return nil, fmt.Errorf("nfFile invalid V3 footer magic")
 	}
-	footerDirSize := binary.LittleEndian.Uint32(footerBytes[4:8])
-	footerDirOffset := binary.LittleEndian.Uint64(footerBytes[8:16])
+	footerDirSize := order.Uint32(footerBytes[4:8])
+	footerDirOffset := order.Uint64(footerBytes[8:16])
 	if header.dirSize != footerDirSize || header.dirOffset != footerDirOffset {
 		return nil, fmt.Errorf("nfFile V3 header and footer directory disagree")
 	}
@@ -114,17 +151,17 @@ func openV18Reader(owner *NfFile, file *os.File, fileName string) (*v18Reader, e
 	}
 
 	directory := make([]byte, header.dirSize)
-	if _, err := file.ReadAt(directory, int64(header.dirOffset)); err != nil {
+	if err := src.readAt(directory, int64(header.dirOffset)); err != nil {
 		return nil, fmt.Errorf("nfFile read V3 directory: %w", err)
 	}
-	checksum := binary.LittleEndian.Uint64(footerBytes[16:24])
+	checksum := order.Uint64(footerBytes[16:24])
 	if checksum != 0 && v3Checksum64(directory) != checksum {
 		return nil, fmt.Errorf("nfFile V3 directory checksum mismatch")
 	}
-	if binary.LittleEndian.Uint32(directory[0:4]) != v18DirectoryMagic {
+	if order.Uint32(directory[0:4]) != v18DirectoryMagic {
 		return nil, fmt.Errorf("nfFile invalid V3 directory magic")
 	}
-	numEntries := binary.LittleEndian.Uint32(directory[4:8])
+	numEntries := order.Uint32(directory[4:8])
 	if uint64(numEntries) > uint64((len(directory)-v18DirectoryHead)/v18DirectoryEnt) ||
 		v18DirectoryHead+int(numEntries)*v18DirectoryEnt != len(directory) {
 		return nil, fmt.Errorf("nfFile invalid V3 directory entry count")
@@ -134,9 +171,9 @@ func openV18Reader(owner *NfFile, file *os.File, fileName string) (*v18Reader, e
 	for i := range entries {
 		offset := v18DirectoryHead + i*v18DirectoryEnt
 		entry := v18DirectoryEntry{
-			typeID: binary.LittleEndian.Uint32(directory[offset : offset+4]),
-			size:   binary.LittleEndian.Uint32(directory[offset+4 : offset+8]),
-			offset: binary.LittleEndian.Uint64(directory[offset+8 : offset+16]),
+			typeID: order.Uint32(directory[offset : offset+4]),
+			size:   order.Uint32(directory[offset+4 : offset+8]),
+			offset: order.Uint64(directory[offset+8 : offset+16]),
 		}
 		if entry.size < v18BlockHeader || entry.size > v18MaxBlockSize || entry.offset < v18HeaderSize || entry.offset > uint64(fileSize-v18FooterSize) ||
 			uint64(entry.size) > uint64(fileSize-v18FooterSize)-entry.offset {
@@ -148,19 +185,150 @@ func openV18Reader(owner *NfFile, file *os.File, fileName string) (*v18Reader, e
 		entries[i] = entry
 	}
 
-	reader := &v18Reader{owner: owner, file: file, header: header, entries: entries}
+	reader, err := newV18Reader(owner, src, header, order, flowBlocks)
+	if err != nil {
+		return nil, err
+	}
+	reader.entries = entries
+	return reader, nil
+}
+
+// openV18StreamReader reads only the file header of a V3 stream. The
+// directory and footer at the end of the file are never read; walk visits
+// the blocks in file order instead, so the number of flow blocks is unknown.
+func openV18StreamReader(owner *NfFile, src source, order binary.ByteOrder) (*v18Reader, error) {
+	input, err := src.reader(0)
+	if err != nil {
+		return nil, fmt.Errorf("nfFile read V3 header: %w", err)
+	}
+	headerBytes := make([]byte, v18HeaderSize)
+	if _, err := io.ReadFull(input, headerBytes); err != nil {
+		return nil, fmt.Errorf("nfFile read V3 header: %w", err)
+	}
+	header, err := parseV18Header(headerBytes, order)
+	if err != nil {
+		return nil, err
+	}
+	return newV18Reader(owner, src, header, order, 0)
+}
+
+// openV18FollowReader reads only the file header of a V3 file that is still
+// being written. Its directory and footer do not exist until the writer
+// closes the file, so walk reads the blocks in file order like a stream.
+func openV18FollowReader(owner *NfFile, src source, order binary.ByteOrder) (*v18Reader, error) {
+	headerBytes := make([]byte, v18HeaderSize)
+	if err := src.readAt(headerBytes, 0); err != nil {
+		return nil, fmt.Errorf("nfFile read V3 header: %w", err)
+	}
+	header, err := parseV18Header(headerBytes, order)
+	if err != nil {
+		return nil, err
+	}
+	return newV18Reader(owner, src, header, order, 0)
+}
+
+// parseV18Header decodes a V3 file header stored in order. The key check
+// value is a byte string rather than an integer and is always read
+// little-endian, the order v18KeyCheck uses.
+func parseV18Header(headerBytes []byte, order binary.ByteOrder) (v18Header, error) {
+	if order.Uint16(headerBytes[0:2]) != nfFileMagic || order.Uint16(headerBytes[2:4]) != 3 {
+		return v18Header{}, fmt.Errorf("nfFile invalid V3 header")
+	}
+	header := v18Header{
+		nfdVersion:  order.Uint32(headerBytes[4:8]),
+		created:     order.Uint64(headerBytes[8:16]),
+		compression: order.Uint16(headerBytes[18:20]),
+		flags:       order.Uint32(headerBytes[20:24]),
+		blockSize:   order.Uint32(headerBytes[24:28]),
+		dirSize:     order.Uint32(headerBytes[28:32]),
+		dirOffset:   order.Uint64(headerBytes[32:40]),
+		keyCheck:    binary.LittleEndian.Uint64(headerBytes[40:48]),
+	}
+	if header.blockSize == 0 || header.blockSize > v18MaxBlockSize {
+		return v18Header{}, fmt.Errorf("nfFile invalid V3 block size: %d", header.blockSize)
+	}
+	if header.compression > 5 {
+		return v18Header{}, fmt.Errorf("nfFile unknown V3 compression: %d", header.compression)
+	}
+	return header, nil
+}
+
+// newV18Reader publishes the file info and sets up decryption.
+func newV18Reader(owner *NfFile, src source, header v18Header, order binary.ByteOrder, flowBlocks uint32) (*v18Reader, error) {
+	reader := &v18Reader{owner: owner, src: src, header: header, order: order}
 	owner.info = FileInfo{
 		Layout:        FileLayoutV3,
 		NfdumpVersion: header.nfdVersion,
 		Created:       header.created,
 		Compression:   v18Compression(header.compression),
-		Encrypted:     header.flags&1 != 0,
+		Encrypted:     header.flags&v18FlagEncrypted != 0,
 		BlockSize:     header.blockSize,
 		FlowBlocks:    flowBlocks,
+		BigEndian:     order == binary.BigEndian,
+	}
+	if owner.info.Encrypted {
+		var err error
+		if reader.aead, err = newV18Cipher(owner.options.keyProvider, owner.info, header.keyCheck); err != nil {
+			return nil, err
+		}
 	}
 	return reader, nil
 }
 
+// packageLayout reports whether the file was written by the V3 writer of this
+// package, so that its metadata blocks can be interpreted.
+func (reader *v18Reader) packageLayout() bool {
+	return reader.header.flags&v18FlagPackageLayout != 0
+}
+
+// statReadable reports whether the stat block of the file is interpreted.
+func (reader *v18Reader) statReadable() bool {
+	return reader.packageLayout()
+}
+
+// knownBlock reports whether blocks of type typeID are read by walk.
+func (reader *v18Reader) knownBlock(typeID uint32) bool {
+	switch typeID {
+	case v18BlockFlow:
+		return true
+	case v18BlockIdent, v18BlockStat, v18BlockExporter, v18BlockExporterStat, v18BlockSampler:
+		return reader.packageLayout()
+	default:
+		return false
+	}
+}
+
+// readAppendix reads the ident and stat blocks listed in the directory. Like
+// the V2 appendix they describe the whole file and are available after Open.
+// Exporter and sampler blocks are processed in file order by walk, matching
+// the V2 behaviour of discovering them while records are read.
+func (reader *v18Reader) readAppendix() error {
+	return reader.readMetadataBlocks(v18BlockIdent, v18BlockStat)
+}
+
+// readMetadataBlocks processes the metadata blocks of the given types listed
+// in the directory. A file opened for VerifyFile keeps the errors in
+// metadataErrs and reads the remaining blocks.
+func (reader *v18Reader) readMetadataBlocks(typeIDs ...uint32) error {
+	for i, entry := range reader.entries {
+		if !slices.Contains(typeIDs, entry.typeID) || !reader.knownBlock(entry.typeID) {
+			continue
+		}
+		block, err := reader.readBlock(entry)
+		if err == nil {
+			err = reader.processMetadataBlock(block)
+		}
+		if err != nil {
+			err = fmt.Errorf("nfFile read V3 metadata block %d: %w", i, err)
+			if !reader.owner.options.verify {
+				return err
+			}
+			reader.metadataErrs = append(reader.metadataErrs, err)
+		}
+	}
+	return nil
+}
+
 func v18Compression(compression uint16) Compression {
 	switch compression {
 	case 2:
@@ -176,37 +344,33 @@ func v18Compression(compression uint16) Compression {
 	}
 }
 
+func (reader *v18Reader) releaseDecoders() {
+	reader.zstdDecoder.close()
+}
+
 func (reader *v18Reader) close() error {
-	if reader.zstdDecoder != nil {
-		reader.zstdDecoder.Close()
-		reader.zstdDecoder = nil
-	}
-	if reader.file == nil {
-		return nil
-	}
-	err := reader.file.Close()
-	reader.file = nil
+	reader.releaseDecoders()
+	err := reader.src.close()
+	reader.src = source{}
 	return err
 }
 
-func (reader *v18Reader) walk(ctx context.Context, cancel context.CancelFunc, fn func(FlowRecord) error) error {
+func (reader *v18Reader) walk(ctx context.Context, cancel context.CancelFunc, cfg walkConfig, fn func(FlowRecord) error) error {
 	checkEvery := reader.owner.walkContextCheckEvery
-	blocks := make(chan []byte, 2)
-	producerDone := make(chan error, 1)
-	go func() {
-		defer close(blocks)
-		producerDone <- reader.readFlowBlocks(ctx, blocks)
-	}()
-
-	var walkErr error
-	for block := range blocks {
-		if err := ctx.Err(); err != nil {
-			walkErr = err
-			cancel()
-			break
+	produce := func(emit func(blockRef, func() ([]byte, error)) error) error {
+		return reader.readBlocks(ctx, cfg, emit)
+	}
+	return runPipeline(ctx, cancel, cfg, produce, func(block []byte) error {
+		if binary.LittleEndian.Uint32(block[0:4]) != v18BlockFlow {
+			return reader.processMetadataBlock(block)
+		}
+		// Blocks whose bounds are hidden in a compressed or encrypted
+		// payload can only be skipped once they are decoded.
+		if cfg.window != nil && reader.packageLayout() && !v18FlowBlockInWindow(block, cfg.window) {
+			return nil
 		}
 		flowCount, nextCheck := uint32(0), uint32(0)
-		walkErr = reader.processFlowBlock(block, func(record FlowRecord) error {
+		return reader.processFlowBlock(block, func(record FlowRecord) error {
 			if checkEvery != 0 && flowCount == nextCheck {
 				if err := ctx.Err(); err != nil {
 					return err
@@ -216,75 +380,321 @@ func (reader *v18Reader) walk(ctx context.Context, cancel context.CancelFunc, fn
 			flowCount++
 			return fn(record)
 		})
-		if walkErr != nil {
-			cancel()
-			break
+	})
+}
+
+// readBlocks emits flow blocks and the exporter, exporter stat and sampler
+// blocks in directory order. Ident and stat blocks were consumed by Open, and
+// unknown block types are skipped for forward compatibility. Flow blocks
+// outside cfg.window are skipped without reading them when their bounds can
+// be read from the stored block and the file was written by this package. A salvaging walk emits read errors as
+// failing decode steps and continues with the next directory entry.
+func (reader *v18Reader) readBlocks(ctx context.Context, cfg walkConfig, emit func(blockRef, func() ([]byte, error)) error) error {
+	if reader.src.stream != nil {
+		return reader.readStreamBlocks(ctx, cfg.parallel(), emit)
+	}
+	if cfg.follow != nil {
+		return reader.followBlocks(ctx, cfg, emit)
+	}
+	for i, entry := range reader.entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		switch entry.typeID {
+		case v18BlockFlow:
+			if cfg.window != nil && !reader.entryInWindow(entry, cfg.window) {
+				continue
+			}
+		case v18BlockExporter, v18BlockExporterStat, v18BlockSampler:
+			if !reader.knownBlock(entry.typeID) {
+				continue
+			}
+		default:
+			continue
+		}
+		ref := blockRef{index: i, offset: int64(entry.offset)}
+		onDisk, err := reader.fetchBlock(entry, cfg.parallel())
+		if err != nil {
+			err = fmt.Errorf("read V3 block %d: %w", i, err)
+			if cfg.salvage == nil {
+				return err
+			}
+			if err := emit(ref, func() ([]byte, error) { return nil, err }); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := emit(ref, reader.decodeStep(i, onDisk)); err != nil {
+			return err
 		}
 	}
-	producerErr := <-producerDone
-	if walkErr != nil {
-		return walkErr
+	return nil
+}
+
+// decodeStep returns the decode step of the block with directory or file
+// index i.
+func (reader *v18Reader) decodeStep(i int, onDisk []byte) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		block, err := reader.decodeBlock(onDisk)
+		if err != nil {
+			return nil, fmt.Errorf("read V3 block %d: %w", i, err)
+		}
+		return block, nil
 	}
-	return producerErr
 }
 
-func (reader *v18Reader) readFlowBlocks(ctx context.Context, blocks chan<- []byte) error {
-	for i, entry := range reader.entries {
+// readStreamBlocks walks the block headers of a stream in file order. It
+// emits the same block types as readBlocks plus the ident and stat blocks,
+// which a stream cannot read ahead of time, and stops at the directory.
+func (reader *v18Reader) readStreamBlocks(ctx context.Context, owned bool, emit func(blockRef, func() ([]byte, error)) error) error {
+	input, err := reader.src.reader(v18HeaderSize)
+	if err != nil {
+		return fmt.Errorf("read V3 blocks: %w", err)
+	}
+	stream := reader.src.stream
+	blockHeader := make([]byte, v18BlockHeader)
+	for i := 0; ; i++ {
 		if err := ctx.Err(); err != nil {
 			return err
 		}
-		if entry.typeID != v18BlockFlow {
+		if reader.header.dirOffset != 0 && uint64(stream.offset) >= reader.header.dirOffset {
+			return nil
+		}
+		ref := blockRef{index: i, offset: stream.offset}
+		if _, err := io.ReadFull(input, blockHeader); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("read V3 block %d header: %w", i, err)
+		}
+		typeID := reader.order.Uint32(blockHeader[0:4])
+		if typeID == v18DirectoryMagic {
+			return nil
+		}
+		size := reader.order.Uint32(blockHeader[4:8])
+		if size < v18BlockHeader || size > v18MaxBlockSize {
+			return fmt.Errorf("read V3 block %d: invalid block size %d", i, size)
+		}
+		if !reader.knownBlock(typeID) {
+			if err := skip(input, int64(size-v18BlockHeader)); err != nil {
+				return fmt.Errorf("skip V3 block %d: %w", i, err)
+			}
 			continue
 		}
-		block, err := reader.readBlock(entry)
+		onDisk := reader.blockBuffer(size, owned)
+		copy(onDisk, blockHeader)
+		if _, err := io.ReadFull(input, onDisk[v18BlockHeader:]); err != nil {
+			return fmt.Errorf("read V3 block %d: %w", i, err)
+		}
+		if err := emit(ref, reader.decodeStep(i, onDisk)); err != nil {
+			return err
+		}
+	}
+}
+
+// followBlocks emits the blocks of a file that is still being written, in
+// file order and as soon as they are complete. The writer appends the
+// directory and rewrites the header with its offset when it closes the file;
+// followBlocks ends there, or once the file has been renamed and no complete
+// block is left.
+func (reader *v18Reader) followBlocks(ctx context.Context, cfg walkConfig, emit func(blockRef, func() ([]byte, error)) error) error {
+	follow := cfg.follow
+	offset := int64(v18HeaderSize)
+	// dirOffset is zero until the writer has closed the file. The header of
+	// the reader is left alone, as decode workers read it concurrently.
+	dirOffset := reader.header.dirOffset
+	blockHeader := make([]byte, v18BlockHeader)
+	headerBytes := make([]byte, v18HeaderSize)
+	for i := 0; ; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if dirOffset != 0 && uint64(offset) >= dirOffset {
+			return nil
+		}
+		ended := follow.ended()
+		size, err := follow.size()
 		if err != nil {
-			return fmt.Errorf("read V3 flow block %d: %w", i, err)
+			return fmt.Errorf("follow V3 file: %w", err)
+		}
+		if offset+v18BlockHeader <= size {
+			if err := reader.src.readAt(blockHeader, offset); err != nil {
+				return fmt.Errorf("read V3 block %d header: %w", i, err)
+			}
+			typeID := reader.order.Uint32(blockHeader[0:4])
+			if typeID == v18DirectoryMagic {
+				return nil
+			}
+			blockSize := reader.order.Uint32(blockHeader[4:8])
+			if blockSize < v18BlockHeader || blockSize > v18MaxBlockSize {
+				return fmt.Errorf("read V3 block %d: invalid block size %d", i, blockSize)
+			}
+			if offset+int64(blockSize) <= size {
+				ref := blockRef{index: i, offset: offset}
+				if reader.knownBlock(typeID) {
+					onDisk := reader.blockBuffer(blockSize, cfg.parallel())
+					if err := reader.src.readAt(onDisk, offset); err != nil {
+						return fmt.Errorf("read V3 block %d: %w", i, err)
+					}
+					if err := emit(ref, reader.decodeStep(i, onDisk)); err != nil {
+						return err
+					}
+				}
+				offset += int64(blockSize)
+				i++
+				continue
+			}
+		}
+		if dirOffset == 0 {
+			if err := reader.src.readAt(headerBytes, 0); err != nil {
+				return fmt.Errorf("read V3 header: %w", err)
+			}
+			if header, err := parseV18Header(headerBytes, reader.order); err == nil && header.dirOffset != 0 {
+				dirOffset = header.dirOffset
+				continue
+			}
+		} else {
+			return fmt.Errorf("read V3 block %d: file truncated at offset %d", i, offset)
+		}
+		if ended {
+			return nil
 		}
-		select {
-		case blocks <- block:
-		case <-ctx.Done():
-			return ctx.Err()
+		if err := follow.wait(ctx); err != nil {
+			return err
 		}
 	}
-	return nil
 }
 
-func (reader *v18Reader) readBlock(entry v18DirectoryEntry) ([]byte, error) {
-	if cap(reader.readBuf) < int(entry.size) {
-		reader.readBuf = make([]byte, entry.size)
+// entryInWindow reports whether the flow block of entry may hold flows in
+// window. Only the bounds of blocks stored uncompressed and unencrypted can be
+// read without decoding the block; all other blocks, and all blocks of files
+// not written by this package, are assumed to match.
+func (reader *v18Reader) entryInWindow(entry v18DirectoryEntry, window *timeWindow) bool {
+	if !reader.packageLayout() || entry.size < v18FlowBlockHead {
+		return true
+	}
+	head := make([]byte, v18FlowBlockLast+8)
+	if reader.src.mapped != nil {
+		copy(head, reader.src.mapped[entry.offset:])
+	} else if err := reader.src.readAt(head, int64(entry.offset)); err != nil {
+		// Let readBlock report the error.
+		return true
+	}
+	compression := reader.order.Uint16(head[12:14])
+	if compression == 0 {
+		compression = reader.header.compression
 	}
-	onDisk := reader.readBuf[:entry.size]
-	if _, err := reader.file.ReadAt(onDisk, int64(entry.offset)); err != nil {
-		return nil, err
+	if compression != 1 || reader.order.Uint16(head[14:16]) != v18EncryptionNone {
+		return true
 	}
-	if binary.LittleEndian.Uint32(onDisk[0:4]) != entry.typeID || binary.LittleEndian.Uint32(onDisk[4:8]) != entry.size {
+	if reader.order == binary.BigEndian {
+		v18BlockHeaderLayout.swap(head)
+		v18FlowBlockHeadLayout.swap(head[v18BlockHeader:])
+	}
+	return v18FlowBlockInWindow(head, window)
+}
+
+// v18FlowBlockInWindow checks the time bounds in a decoded flow block head.
+// Blocks without bounds always match.
+func v18FlowBlockInWindow(head []byte, window *timeWindow) bool {
+	if len(head) < v18FlowBlockLast+8 {
+		return true
+	}
+	first := binary.LittleEndian.Uint64(head[v18FlowBlockFirst:])
+	last := binary.LittleEndian.Uint64(head[v18FlowBlockLast:])
+	if first == 0 || last == 0 {
+		return true
+	}
+	return window.overlaps(first, last)
+}
+
+// blockBuffer returns a buffer for size on-disk bytes. Unless owned is set
+// this is readBuf, which the next read overwrites.
+func (reader *v18Reader) blockBuffer(size uint32, owned bool) []byte {
+	if owned {
+		return make([]byte, size)
+	}
+	if cap(reader.readBuf) < int(size) {
+		reader.readBuf = make([]byte, size)
+	}
+	return reader.readBuf[:size]
+}
+
+// fetchBlock returns the on-disk bytes of the block of entry. With owned set
+// they do not alias readBuf, so the block can be decoded while the next one is
+// read.
+func (reader *v18Reader) fetchBlock(entry v18DirectoryEntry, owned bool) ([]byte, error) {
+	var onDisk []byte
+	if reader.src.mapped != nil {
+		onDisk = reader.src.mapped[entry.offset : entry.offset+uint64(entry.size)]
+		// Blocks are decrypted and byte-swapped in place, which the
+		// read-only mapping does not allow.
+		if reader.order.Uint16(onDisk[14:16]) != v18EncryptionNone || reader.order == binary.BigEndian {
+			onDisk = append(reader.blockBuffer(entry.size, owned)[:0], onDisk...)
+		}
+	} else {
+		onDisk = reader.blockBuffer(entry.size, owned)
+		if err := reader.src.readAt(onDisk, int64(entry.offset)); err != nil {
+			return nil, err
+		}
+	}
+	if reader.order.Uint32(onDisk[0:4]) != entry.typeID || reader.order.Uint32(onDisk[4:8]) != entry.size {
 		return nil, fmt.Errorf("block header does not match directory")
 	}
-	rawSize := binary.LittleEndian.Uint32(onDisk[8:12])
-	compression := binary.LittleEndian.Uint16(onDisk[12:14])
-	encryption := binary.LittleEndian.Uint16(onDisk[14:16])
-	checksum := binary.LittleEndian.Uint64(onDisk[16:24])
+	return onDisk, nil
+}
+
+func (reader *v18Reader) readBlock(entry v18DirectoryEntry) ([]byte, error) {
+	onDisk, err := reader.fetchBlock(entry, false)
+	if err != nil {
+		return nil, err
+	}
+	return reader.decodeBlock(onDisk)
+}
+
+// decodeBlock verifies, decrypts and decompresses the on-disk bytes of a
+// block, header included, into a freshly allocated block. An uncompressed,
+// unencrypted block of a memory-mapped file is returned as a view of the
+// mapping instead.
+func (reader *v18Reader) decodeBlock(onDisk []byte) ([]byte, error) {
+	rawSize := reader.order.Uint32(onDisk[8:12])
+	compression := reader.order.Uint16(onDisk[12:14])
+	encryption := reader.order.Uint16(onDisk[14:16])
+	checksum := reader.order.Uint64(onDisk[16:24])
 	if rawSize < v18BlockHeader || rawSize > reader.header.blockSize {
 		return nil, fmt.Errorf("invalid raw block size %d", rawSize)
 	}
 	if checksum != 0 && v3Checksum64(onDisk[v18BlockHeader:]) != checksum {
 		return nil, fmt.Errorf("block checksum mismatch")
 	}
-	if encryption != 0 {
-		return nil, fmt.Errorf("encrypted V3 blocks are not supported")
+	payload := onDisk[v18BlockHeader:]
+	if encryption != v18EncryptionNone {
+		var err error
+		if payload, err = reader.decryptV18(onDisk[:v18BlockHeader], payload, encryption); err != nil {
+			return nil, err
+		}
 	}
 	if compression == 0 {
 		compression = reader.header.compression
 	}
+	if compression == 1 && encryption == v18EncryptionNone && reader.src.mapped != nil && reader.order == binary.LittleEndian {
+		if len(onDisk) != int(rawSize) {
+			return nil, fmt.Errorf("uncompressed V3 block size %d, want %d", len(onDisk), rawSize)
+		}
+		return onDisk, nil
+	}
 	// The V3 block header carries the exact decompressed size (rawSize), so
 	// unlike V2/1.7.x we can allocate the final, correctly sized buffer once
 	// and have uncompressV18 decode straight into its tail - no separate
 	// scratch decompression buffer and no second copy to assemble the result.
 	block := make([]byte, rawSize)
 	copy(block, onDisk[:v18BlockHeader])
-	if err := reader.uncompressV18(onDisk[v18BlockHeader:], compression, block[v18BlockHeader:]); err != nil {
+	if err := reader.uncompressV18(payload, compression, block[v18BlockHeader:]); err != nil {
 		return nil, err
 	}
+	if reader.order == binary.BigEndian {
+		swapV18Block(block)
+	}
 	return block, nil
 }
 
@@ -317,4 +727,79 @@ func (reader *v18Reader) processFlowBlock(block []byte, fn func(FlowRecord) erro
 	return nil
 }
 
+// processMetadataBlock applies the records of a V3 metadata block to the
+// owning NfFile.
+func (reader *v18Reader) processMetadataBlock(block []byte) error {
+	if len(block) < v18MetaBlockHead {
+		return fmt.Errorf("invalid V3 metadata block")
+	}
+	numRecords := binary.LittleEndian.Uint32(block[24:28])
+	if err := reader.owner.processMetadataRecords(block[v18MetaBlockHead:], numRecords); err != nil {
+		return fmt.Errorf("V3 metadata block type %d: %w", binary.LittleEndian.Uint32(block[0:4]), err)
+	}
+	return nil
+}
+
 var _ fileReader = (*v18Reader)(nil)
+
+// verifyContainer reports the metadata blocks Open could not read, re-reads
+// header and footer, checks that the blocks of the directory neither overlap
+// each other nor the header and directory, and counts the blocks without
+// checksum. Open has already validated the directory range and checksum.
+func (reader *v18Reader) verifyContainer(report *VerifyReport) {
+	for _, err := range reader.metadataErrs {
+		report.addProblem(VerifyContainer, err)
+	}
+	if reader.src.stream != nil {
+		return
+	}
+	headerBytes := make([]byte, v18HeaderSize)
+	footerBytes := make([]byte, v18FooterSize)
+	if err := reader.src.readAt(headerBytes, 0); err != nil {
+		report.addProblem(VerifyContainer, fmt.Errorf("read V3 header: %w", err))
+	} else if header, err := parseV18Header(headerBytes, reader.order); err != nil || header != reader.header {
+		report.addProblem(VerifyContainer, fmt.Errorf("V3 header changed since open"))
+	}
+	if err := reader.src.readAt(footerBytes, reader.src.size-v18FooterSize); err != nil {
+		report.addProblem(VerifyContainer, fmt.Errorf("read V3 footer: %w", err))
+	} else if reader.order.Uint32(footerBytes[0:4]) != v18FooterMagic ||
+		reader.order.Uint32(footerBytes[4:8]) != reader.header.dirSize ||
+		reader.order.Uint64(footerBytes[8:16]) != reader.header.dirOffset {
+		report.addProblem(VerifyContainer, fmt.Errorf("V3 footer changed since open"))
+	}
+
+	type span struct {
+		index      int
+		start, end uint64
+	}
+	spans := make([]span, 0, len(reader.entries)+1)
+	spans = append(spans, span{-1, reader.header.dirOffset, reader.header.dirOffset + uint64(reader.header.dirSize)})
+	for i, entry := range reader.entries {
+		spans = append(spans, span{i, entry.offset, entry.offset + uint64(entry.size)})
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+	for i := 1; i < len(spans); i++ {
+		if spans[i].start < spans[i-1].end {
+			first, second := spans[i-1], spans[i]
+			if second.index < 0 {
+				first, second = second, first
+			}
+			if first.index < 0 {
+				report.addProblem(VerifyContainer, fmt.Errorf("V3 block %d overlaps the directory", second.index))
+			} else {
+				report.addProblem(VerifyContainer, fmt.Errorf("V3 blocks %d and %d overlap", first.index, second.index))
+			}
+		}
+	}
+
+	blockHeader := make([]byte, v18BlockHeader)
+	for i, entry := range reader.entries {
+		if err := reader.src.readAt(blockHeader, int64(entry.offset)); err != nil {
+			report.addProblem(VerifyContainer, fmt.Errorf("read V3 block %d header: %w", i, err))
+			continue
+		}
+		if reader.order.Uint64(blockHeader[16:24]) == 0 {
+			report.Unchecksummed++
+		}
+	}
+}
@@ -0,0 +1,365 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+// Record definitions of the nfdump 1.6.x V1 layout. V1 flow records are a
+// fixed common record followed by the extensions listed in a per-file
+// extension map. They are converted to the V3 record layout on read, so the
+// Walk and legacy APIs decode them through the same accessors as 1.7.x
+// records.
+
+package nfdump
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	v1ExtensionMapType = uint16(2)
+	v1CommonRecordType = uint16(10)
+
+	v1CommonRecordSize = 32
+	v1ExtensionMapHead = 8
+)
+
+// V1 common record flags.
+const (
+	v1FlagIPv6Addr = 0x1
+	v1FlagPkg64    = 0x2
+	v1FlagBytes64  = 0x4
+	v1FlagEvent    = 0x40
+	v1FlagSampled  = 0x80
+)
+
+// V1 extension identifiers used in extension maps.
+const (
+	v1ExIOSNMP2         = 4
+	v1ExIOSNMP4         = 5
+	v1ExAS2             = 6
+	v1ExAS4             = 7
+	v1ExMultiple        = 8
+	v1ExNextHopV4       = 9
+	v1ExNextHopV6       = 10
+	v1ExNextHopBGPV4    = 11
+	v1ExNextHopBGPV6    = 12
+	v1ExVLAN            = 13
+	v1ExOutPkg4         = 14
+	v1ExOutPkg8         = 15
+	v1ExOutBytes4       = 16
+	v1ExOutBytes8       = 17
+	v1ExAggrFlows4      = 18
+	v1ExAggrFlows8      = 19
+	v1ExMAC1            = 20
+	v1ExMAC2            = 21
+	v1ExMPLS            = 22
+	v1ExRouterIPV4      = 23
+	v1ExRouterIPV6      = 24
+	v1ExRouterID        = 25
+	v1ExBGPAdj          = 26
+	v1ExReceived        = 27
+	v1ExNSELCommon      = 37
+	v1ExNSELXlatePorts  = 38
+	v1ExNSELXlateIPV4   = 39
+	v1ExNSELXlateIPV6   = 40
+	v1ExNSELACL         = 41
+	v1ExNSELUser        = 42
+	v1ExNSELUserMax     = 43
+	v1ExLatency         = 45
+	v1ExNELCommon       = 46
+	v1ExNELGlobalIPV4   = 47
+	v1ExPortBlockAlloc  = 48
+	v1MaxExtensionMapID = 0xffff
+)
+
+// v1ExtensionSize returns the on-disk size of a V1 extension. The three
+// required extensions (addresses, packets and bytes) are not part of a map.
+func v1ExtensionSize(id uint16) (int, bool) {
+	switch id {
+	case v1ExIOSNMP2, v1ExAS2, v1ExMultiple, v1ExNextHopV4, v1ExNextHopBGPV4, v1ExVLAN,
+		v1ExOutPkg4, v1ExOutBytes4, v1ExAggrFlows4, v1ExRouterIPV4, v1ExRouterID, v1ExNSELXlatePorts:
+		return 4, true
+	case v1ExIOSNMP4, v1ExAS4, v1ExOutPkg8, v1ExOutBytes8, v1ExAggrFlows8, v1ExBGPAdj, v1ExReceived,
+		v1ExNSELXlateIPV4, v1ExPortBlockAlloc:
+		return 8, true
+	case v1ExNELCommon:
+		return 12, true
+	case v1ExNextHopV6, v1ExNextHopBGPV6, v1ExMAC1, v1ExMAC2, v1ExRouterIPV6:
+		return 16, true
+	case v1ExNSELCommon:
+		return 20, true
+	case v1ExNSELACL, v1ExNSELUser, v1ExLatency:
+		return 24, true
+	case v1ExNSELXlateIPV6:
+		return 32, true
+	case v1ExMPLS:
+		return 40, true
+	case v1ExNSELUserMax:
+		return 72, true
+	case v1ExNELGlobalIPV4:
+		return 0, true
+	default:
+		return 0, false
+	}
+}
+
+// v1ExtensionMaps holds the extension maps seen so far in a V1 file. Maps
+// precede the records that use them and may be redefined later in the file.
+type v1ExtensionMaps map[uint16][]uint16
+
+func (maps v1ExtensionMaps) add(record []byte) error {
+	if len(record) < v1ExtensionMapHead {
+		return fmt.Errorf("extension map too short: %d bytes", len(record))
+	}
+	mapID := binary.LittleEndian.Uint16(record[4:6])
+	var ids []uint16
+	for offset := v1ExtensionMapHead; offset+2 <= len(record); offset += 2 {
+		id := binary.LittleEndian.Uint16(record[offset : offset+2])
+		if id == 0 {
+			break
+		}
+		if _, ok := v1ExtensionSize(id); !ok {
+			return fmt.Errorf("extension map %d: unknown extension %d", mapID, id)
+		}
+		ids = append(ids, id)
+	}
+	maps[mapID] = ids
+	return nil
+}
+
+// v1Record collects the V1 fields that contribute to one V3 record.
+type v1Record struct {
+	generic    [48]byte
+	misc       [16]byte
+	counters   [24]byte
+	hasMisc    bool
+	hasCounter bool
+	elements   []v3Element
+}
+
+// v3Element is one V3 extension: its identifier and payload.
+type v3Element struct {
+	id   uint16
+	data []byte
+}
+
+// appendV3FromV1 converts a V1 common record into the V3 record layout and
+// appends it to dst. Address and other multi-byte fields already use the
+// host-order encoding of V3 records, so most extensions are copied verbatim.
+func appendV3FromV1(dst []byte, record []byte, maps v1ExtensionMaps) ([]byte, error) {
+	if len(record) < v1CommonRecordSize {
+		return dst, fmt.Errorf("V1 record too short: %d bytes", len(record))
+	}
+	flags := binary.LittleEndian.Uint16(record[4:6])
+	mapID := binary.LittleEndian.Uint16(record[6:8])
+	extensions, ok := maps[mapID]
+	if !ok {
+		return dst, fmt.Errorf("V1 record references unknown extension map %d", mapID)
+	}
+
+	var v1 v1Record
+	generic := v1.generic[:]
+	msecFirst := uint64(binary.LittleEndian.Uint32(record[12:16]))*1000 + uint64(binary.LittleEndian.Uint16(record[8:10]))
+	msecLast := uint64(binary.LittleEndian.Uint32(record[16:20]))*1000 + uint64(binary.LittleEndian.Uint16(record[10:12]))
+	binary.LittleEndian.PutUint64(generic[0:8], msecFirst)
+	binary.LittleEndian.PutUint64(generic[8:16], msecLast)
+	copy(generic[40:44], record[24:28]) // source and destination port
+	generic[44] = record[22]            // protocol
+	generic[45] = record[21]            // TCP flags
+	generic[46] = record[20]            // forwarding status
+	generic[47] = record[23]            // source ToS
+	v1.misc[12], v1.misc[13] = record[30], record[31]
+	v1.hasMisc = record[30] != 0 || record[31] != 0
+
+	offset := v1CommonRecordSize
+	take := func(size int) ([]byte, error) {
+		if offset+size > len(record) {
+			return nil, fmt.Errorf("V1 record truncated at offset %d", offset)
+		}
+		data := record[offset : offset+size]
+		offset += size
+		return data, nil
+	}
+	counter := func(wide bool) (uint64, error) {
+		if wide {
+			data, err := take(8)
+			if err != nil {
+				return 0, err
+			}
+			return binary.LittleEndian.Uint64(data), nil
+		}
+		data, err := take(4)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(binary.LittleEndian.Uint32(data)), nil
+	}
+
+	if flags&v1FlagIPv6Addr != 0 {
+		data, err := take(32)
+		if err != nil {
+			return dst, err
+		}
+		v1.elements = append(v1.elements, v3Element{EXipv6FlowID, data})
+	} else {
+		data, err := take(8)
+		if err != nil {
+			return dst, err
+		}
+		v1.elements = append(v1.elements, v3Element{EXipv4FlowID, data})
+	}
+	packets, err := counter(flags&v1FlagPkg64 != 0)
+	if err != nil {
+		return dst, err
+	}
+	bytes, err := counter(flags&v1FlagBytes64 != 0)
+	if err != nil {
+		return dst, err
+	}
+	binary.LittleEndian.PutUint64(generic[24:32], packets)
+	binary.LittleEndian.PutUint64(generic[32:40], bytes)
+
+	var engineType, engineID uint8
+	var mac [32]byte
+	hasMAC := false
+	for _, id := range extensions {
+		size, _ := v1ExtensionSize(id)
+		data, err := take(size)
+		if err != nil {
+			return dst, err
+		}
+		switch id {
+		case v1ExIOSNMP2:
+			binary.LittleEndian.PutUint32(v1.misc[0:4], uint32(binary.LittleEndian.Uint16(data[0:2])))
+			binary.LittleEndian.PutUint32(v1.misc[4:8], uint32(binary.LittleEndian.Uint16(data[2:4])))
+			v1.hasMisc = true
+		case v1ExIOSNMP4:
+			copy(v1.misc[0:8], data)
+			v1.hasMisc = true
+		case v1ExMultiple:
+			// dst tos, direction, src mask, dst mask
+			v1.misc[11], v1.misc[10], v1.misc[8], v1.misc[9] = data[0], data[1], data[2], data[3]
+			v1.hasMisc = true
+		case v1ExAS2, v1ExVLAN:
+			widened := make([]byte, 8)
+			binary.LittleEndian.PutUint32(widened[0:4], uint32(binary.LittleEndian.Uint16(data[0:2])))
+			binary.LittleEndian.PutUint32(widened[4:8], uint32(binary.LittleEndian.Uint16(data[2:4])))
+			exID := EXasRoutingID
+			if id == v1ExVLAN {
+				exID = EXvLanID
+			}
+			v1.elements = append(v1.elements, v3Element{exID, widened})
+		case v1ExAS4:
+			v1.elements = append(v1.elements, v3Element{EXasRoutingID, data})
+		case v1ExNextHopV4:
+			v1.elements = append(v1.elements, v3Element{EXipNextHopV4ID, data})
+		case v1ExNextHopV6:
+			v1.elements = append(v1.elements, v3Element{EXipNextHopV6ID, data})
+		case v1ExNextHopBGPV4:
+			v1.elements = append(v1.elements, v3Element{EXbgpNextHopV4ID, data})
+		case v1ExNextHopBGPV6:
+			v1.elements = append(v1.elements, v3Element{EXbgpNextHopV6ID, data})
+		case v1ExRouterIPV4:
+			v1.elements = append(v1.elements, v3Element{EXipReceivedV4ID, data})
+		case v1ExRouterIPV6:
+			v1.elements = append(v1.elements, v3Element{EXipReceivedV6ID, data})
+		case v1ExOutPkg4, v1ExOutPkg8:
+			binary.LittleEndian.PutUint64(v1.counters[8:16], v1Counter(data))
+			v1.hasCounter = true
+		case v1ExOutBytes4, v1ExOutBytes8:
+			binary.LittleEndian.PutUint64(v1.counters[16:24], v1Counter(data))
+			v1.hasCounter = true
+		case v1ExAggrFlows4, v1ExAggrFlows8:
+			binary.LittleEndian.PutUint64(v1.counters[0:8], v1Counter(data))
+			v1.hasCounter = true
+		case v1ExMAC1: // in source, out destination
+			copy(mac[0:16], data)
+			hasMAC = true
+		case v1ExMAC2: // in destination, out source
+			copy(mac[16:32], data)
+			hasMAC = true
+		case v1ExMPLS:
+			v1.elements = append(v1.elements, v3Element{EXmplsLabelID, data})
+		case v1ExRouterID:
+			engineType, engineID = data[2], data[3]
+		case v1ExBGPAdj:
+			v1.elements = append(v1.elements, v3Element{EXasAdjacentID, data})
+		case v1ExReceived:
+			copy(generic[16:24], data)
+		case v1ExLatency:
+			v1.elements = append(v1.elements, v3Element{EXlatencyID, data})
+		case v1ExNSELCommon:
+			// event time, connection ID, firewall event, fill, extended event
+			common := make([]byte, 16)
+			copy(common[0:12], data[0:12])
+			copy(common[12:14], data[14:16])
+			common[14] = data[12]
+			v1.elements = append(v1.elements, v3Element{EXnselCommonID, common})
+		case v1ExNSELXlatePorts:
+			v1.elements = append(v1.elements, v3Element{EXnatXlatePortID, data})
+		case v1ExNSELXlateIPV4:
+			v1.elements = append(v1.elements, v3Element{EXnatXlateIPv4ID, data})
+		case v1ExNSELXlateIPV6:
+			v1.elements = append(v1.elements, v3Element{EXnatXlateIPv6ID, data})
+		case v1ExNELCommon:
+			// NAT event, fill, flags, egress VRF, ingress VRF
+			common := make([]byte, 16)
+			common[12] = data[0]
+			v1.elements = append(v1.elements, v3Element{EXnatCommonID, common}, v3Element{EXvrfID, data[4:12]})
+		case v1ExPortBlockAlloc:
+			v1.elements = append(v1.elements, v3Element{EXnatPortBlockID, data})
+		}
+	}
+	if offset > len(record) {
+		return dst, fmt.Errorf("V1 record extensions exceed record size")
+	}
+	if hasMAC {
+		// V3 order: in source, out destination, in destination, out source.
+		v1.elements = append(v1.elements, v3Element{EXmacAddrID, mac[:]})
+	}
+
+	elements := append([]v3Element{{EXgenericFlowID, generic}}, v1.elements...)
+	if v1.hasMisc {
+		elements = append(elements, v3Element{EXflowMiscID, v1.misc[:]})
+	}
+	if v1.hasCounter {
+		elements = append(elements, v3Element{EXcntFlowID, v1.counters[:]})
+	}
+
+	v3Flags := uint8(0)
+	if flags&v1FlagEvent != 0 {
+		v3Flags |= uint8(V3_FLAG_EVENT)
+	}
+	if flags&v1FlagSampled != 0 {
+		v3Flags |= uint8(V3_FLAG_SAMPLED)
+	}
+	start := len(dst)
+	dst = append(dst, make([]byte, v3RecordHeaderSize)...)
+	header := dst[start:]
+	binary.LittleEndian.PutUint16(header[0:2], V3Record)
+	binary.LittleEndian.PutUint16(header[4:6], uint16(len(elements)))
+	header[6], header[7] = engineType, engineID
+	copy(header[8:10], record[28:30]) // exporter sysID
+	header[10] = v3Flags
+	for _, element := range elements {
+		var elementHeader [4]byte
+		binary.LittleEndian.PutUint16(elementHeader[0:2], element.id)
+		binary.LittleEndian.PutUint16(elementHeader[2:4], uint16(4+len(element.data)))
+		dst = append(dst, elementHeader[:]...)
+		dst = append(dst, element.data...)
+	}
+	if len(dst)-start > 0xffff {
+		return dst[:start], fmt.Errorf("converted V1 record exceeds maximum record size")
+	}
+	binary.LittleEndian.PutUint16(dst[start+2:start+4], uint16(len(dst)-start))
+	return dst, nil
+}
+
+func v1Counter(data []byte) uint64 {
+	if len(data) == 8 {
+		return binary.LittleEndian.Uint64(data)
+	}
+	return uint64(binary.LittleEndian.Uint32(data))
+}
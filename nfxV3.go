@@ -31,13 +31,21 @@ const (
 	EXipNextHopV6ID		= uint16(0xb)
 	EXipReceivedV4ID	= uint16(0xc)
 	EXipReceivedV6ID	= uint16(0xd)
+	EXmplsLabelID		= uint16(0xe)
+	EXmacAddrID		= uint16(0xf)
+	EXasAdjacentID		= uint16(0x10)
+	EXlatencyID		= uint16(0x11)
 	EXsamplerInfoID		= uint16(0x12)
+	EXnselCommonID		= uint16(0x13)
 	EXinPayloadID		= uint16(0x1d)
 	EXnatXlateIPv4ID	= uint16(0x14)
 	EXnatXlateIPv6ID	= uint16(0x15)
 	EXnatXlatePortID	= uint16(0x16)
+	EXnselAclID		= uint16(0x17)
 	EXnatCommonID		= uint16(0x19)
 	EXnatPortBlockID	= uint16(0x1a)
+	EXvrfID			= uint16(0x24)
+	EXlayer2ID		= uint16(0x26)
 	EXflowIdID		= uint16(0x27)
 	EXnokiaNatID		= uint16(0x28)
 	EXnokiaNatStringID	= uint16(0x29)
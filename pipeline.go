@@ -0,0 +1,199 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package nfdump
+
+import (
+	"context"
+	"sync"
+
+	zstd "github.com/klauspost/compress/zstd"
+)
+
+// WalkOption configures a single Walk or WalkRange call.
+type WalkOption func(*walkConfig)
+
+// WithWorkers decodes blocks on n goroutines. Blocks are still read in file
+// order and handed to the callback in file order, so records arrive exactly
+// as with a single worker. Values below 2 keep the default single producer.
+func WithWorkers(n int) WalkOption {
+	return func(cfg *walkConfig) {
+		cfg.workers = n
+	}
+}
+
+// WithBlocksInFlight bounds the number of blocks that are read or decoded
+// ahead of the callback. Memory use is therefore limited to about n+1
+// decoded blocks. The default is 2 for a single worker and twice the number
+// of workers otherwise; n below the number of workers limits parallelism.
+func WithBlocksInFlight(n int) WalkOption {
+	return func(cfg *walkConfig) {
+		cfg.blocksInFlight = n
+	}
+}
+
+func newWalkConfig(options []WalkOption) walkConfig {
+	var cfg walkConfig
+	for _, option := range options {
+		if option != nil {
+			option(&cfg)
+		}
+	}
+	return cfg
+}
+
+// parallel reports whether blocks are decoded by a worker pool. Block bytes
+// must then not alias a buffer reused by the next read.
+func (cfg walkConfig) parallel() bool {
+	return cfg.workers > 1
+}
+
+func (cfg walkConfig) inFlight() int {
+	switch {
+	case cfg.blocksInFlight > 0:
+		return cfg.blocksInFlight
+	case cfg.parallel():
+		return 2 * cfg.workers
+	default:
+		return 2
+	}
+}
+
+// blockTask is a block travelling from the producer to the callback.
+type blockTask[T any] struct {
+	ref    blockRef
+	decode func() (T, error)
+	block  T
+	err    error
+	done   chan struct{}
+}
+
+func (task *blockTask[T]) run(ctx context.Context) {
+	if err := ctx.Err(); err != nil {
+		task.err = err
+	} else {
+		task.block, task.err = task.decode()
+	}
+	close(task.done)
+}
+
+// runPipeline runs produce in its own goroutine. produce reads the blocks in
+// file order and passes emit the location of each block and a function that
+// decodes it. Blocks are decoded by the producer itself or, for a parallel
+// cfg, by a pool of workers; consume receives them in the order they were
+// emitted, in the caller's goroutine. A decode or consume error cancels the
+// walk, unless a salvaging walk records it and continues with the next
+// block. All workers have stopped when runPipeline returns, so no block is
+// decoded after Walk returns.
+func runPipeline[T any](ctx context.Context, cancel context.CancelFunc, cfg walkConfig,
+	produce func(emit func(ref blockRef, decode func() (T, error)) error) error, consume func(T) error) error {
+	order := make(chan *blockTask[T], cfg.inFlight())
+	var work chan *blockTask[T]
+	var workers sync.WaitGroup
+	if cfg.parallel() {
+		work = make(chan *blockTask[T])
+		for i := 0; i < cfg.workers; i++ {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				for task := range work {
+					task.run(ctx)
+				}
+			}()
+		}
+	}
+
+	emit := func(ref blockRef, decode func() (T, error)) error {
+		task := &blockTask[T]{ref: ref, decode: decode, done: make(chan struct{})}
+		if work == nil {
+			task.run(ctx)
+		}
+		select {
+		case order <- task:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if work != nil {
+			select {
+			case work <- task:
+			case <-ctx.Done():
+				task.err = ctx.Err()
+				close(task.done)
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+	producerDone := make(chan error, 1)
+	go func() {
+		defer close(order)
+		producerDone <- produce(emit)
+	}()
+
+	var walkErr error
+	for task := range order {
+		<-task.done
+		if cfg.salvage != nil {
+			cfg.salvage.Blocks++
+		}
+		if err := ctx.Err(); err != nil {
+			walkErr = err
+			cancel()
+			break
+		}
+		err := task.err
+		if err == nil {
+			err = consume(task.block)
+		}
+		if err != nil {
+			if cfg.salvageable(err) {
+				cfg.skipBlock(task.ref, err)
+				continue
+			}
+			walkErr = err
+			cancel()
+			break
+		}
+	}
+	producerErr := <-producerDone
+	if work != nil {
+		close(work)
+		workers.Wait()
+	}
+	if walkErr != nil {
+		return walkErr
+	}
+	return producerErr
+}
+
+// sharedZstd lazily creates the zstd decoder of a reader. Its DecodeAll is
+// safe for concurrent use, so all decode workers of a walk share it.
+type sharedZstd struct {
+	mu      sync.Mutex
+	decoder *zstd.Decoder
+}
+
+func (shared *sharedZstd) get(maxMemory uint64) (*zstd.Decoder, error) {
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+	if shared.decoder == nil {
+		decoder, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(maxMemory))
+		if err != nil {
+			return nil, err
+		}
+		shared.decoder = decoder
+	}
+	return shared.decoder, nil
+}
+
+func (shared *sharedZstd) close() {
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+	if shared.decoder != nil {
+		shared.decoder.Close()
+		shared.decoder = nil
+	}
+}
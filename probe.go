@@ -0,0 +1,78 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package nfdump
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+// Probe reports the metadata and the stat record of the flow file path
+// without opening it for reading flows. It reads only the parts of the file
+// that hold them: the V1 header and stat record, the V2 header and appendix,
+// or the V3 header, directory and stat block. The flow count is
+// StatRecord.Numflows. No exporter list is built and nothing is retained, so
+// Probe suits scans over large file trees. Info().NameTime is parsed from
+// standard nfcapd.YYYYMMDDhhmm file names.
+//
+// The stat block of an encrypted V3 file is encrypted as well. Probe then
+// needs WithKeyProvider; without it, it returns the FileInfo together with
+// ErrKeyRequired. Other options are ignored.
+func Probe(path string, options ...OpenOption) (FileInfo, StatRecord, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FileInfo{}, StatRecord{}, fmt.Errorf("nfFile Probe() on %s: %v", path, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return FileInfo{}, StatRecord{}, fmt.Errorf("nfFile stat %s: %w", path, err)
+	}
+	owner := &NfFile{options: newOpenOptions(options)}
+	err = owner.probe(source{ReaderAt: file, size: info.Size()}, path)
+	owner.info.NameTime = nameTime(path)
+	if err != nil {
+		return owner.info, StatRecord{}, err
+	}
+	return owner.info, owner.StatRecord, nil
+}
+
+// probe fills the file info and stat record of nfFile from src. It does not
+// take ownership of src.
+func (nfFile *NfFile) probe(src source, name string) error {
+	prefix := make([]byte, 4)
+	if err := src.readPrefix(prefix); err != nil {
+		return fmt.Errorf("nfFile read header on %s: %v", name, err)
+	}
+	order, ok := fileByteOrder(prefix[0:2])
+	if !ok {
+		return fmt.Errorf("nfFile read header, bad magic : 0x%x", binary.LittleEndian.Uint16(prefix[0:2]))
+	}
+	switch version := order.Uint16(prefix[2:4]); version {
+	case 1:
+		_, err := openV17ReaderV1(nfFile, src, order)
+		return err
+	case 2:
+		reader, err := openV17ReaderV2(nfFile, src, name, order)
+		if err != nil {
+			return err
+		}
+		reader.releaseDecoders()
+		return nil
+	case 3:
+		// Only the stat block is needed; the ident block is skipped.
+		reader, err := openV18Directory(nfFile, src, order)
+		if err != nil {
+			return err
+		}
+		defer reader.releaseDecoders()
+		return reader.readMetadataBlocks(v18BlockStat)
+	default:
+		return unsupportedError{operation: "open", layout: FileLayout(version)}
+	}
+}
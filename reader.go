@@ -7,9 +7,14 @@
 package nfdump
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 // FileLayout identifies an nfdump container layout.
@@ -36,7 +41,7 @@ const (
 
 // FileInfo is the version-neutral metadata available after Open. Created is
 // the nfdump on-disk creation timestamp. Its unit is defined by the container
-// layout; V2 uses milliseconds since the Unix epoch.
+// layout; V2 uses seconds since the Unix epoch, as nfcapd stores time(NULL).
 //
 // Header remains available for compatibility with V1/V2 callers. New code
 // should prefer Info so it does not depend on a particular container header.
@@ -48,6 +53,39 @@ type FileInfo struct {
 	Encrypted     bool
 	BlockSize     uint32
 	FlowBlocks    uint32
+	// BigEndian reports a file written on a big-endian host. Walk and the
+	// legacy record APIs convert its records to little-endian order;
+	// ReadDataBlocks returns the block data as stored. AllRecords converts
+	// the blocks it reads itself in place, so the blocks a ReadDataBlocks
+	// caller receives are never changed.
+	BigEndian bool
+	// NameTime is the start of the time slot encoded in a standard
+	// nfcapd.YYYYMMDDhhmm or nfcapd.YYYYMMDDhhmmss file name, in local time
+	// as nfcapd names its files. It is zero for other names.
+	NameTime time.Time
+}
+
+// nameTime parses the time slot of a file named nfcapd.YYYYMMDDhhmm or
+// nfcapd.YYYYMMDDhhmmss and returns the zero time for any other name.
+func nameTime(name string) time.Time {
+	stamp, ok := strings.CutPrefix(filepath.Base(name), "nfcapd.")
+	if !ok {
+		return time.Time{}
+	}
+	var layout string
+	switch len(stamp) {
+	case len("200601021504"):
+		layout = "200601021504"
+	case len("20060102150405"):
+		layout = "20060102150405"
+	default:
+		return time.Time{}
+	}
+	slot, err := time.ParseInLocation(layout, stamp, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return slot
 }
 
 // ErrUnsupported is returned when an operation is not available for the
@@ -66,14 +104,205 @@ func (err unsupportedError) Error() string {
 
 func (err unsupportedError) Unwrap() error { return ErrUnsupported }
 
+// ErrStreamConsumed is returned when a file opened with OpenStream is read a
+// second time. A stream cannot be rewound, so it can be walked only once.
+var ErrStreamConsumed = errors.New("nfdump: stream already consumed")
+
+// OpenOption configures how Open and its variants read a file.
+type OpenOption func(*openOptions)
+
+type openOptions struct {
+	keyProvider KeyProvider
+	mmap        bool
+	// follow is set by Follow for a file that is still being written.
+	follow bool
+	// verify is set by VerifyFile to open files whose ident or stat
+	// metadata is corrupt.
+	verify bool
+}
+
+func newOpenOptions(options []OpenOption) openOptions {
+	var opts openOptions
+	for _, option := range options {
+		if option != nil {
+			option(&opts)
+		}
+	}
+	return opts
+}
+
+// WithKeyProvider sets the provider asked for the key of an encrypted file.
+// It is consulted only when the opened file is encrypted.
+func WithKeyProvider(provider KeyProvider) OpenOption {
+	return func(opts *openOptions) {
+		opts.keyProvider = provider
+	}
+}
+
+// WithMmap makes Open map the file into memory instead of reading each block
+// with ReadAt. Uncompressed, unencrypted V3 blocks are then walked in place
+// without a copy; compressed blocks are decoded as usual. The mapping is
+// released by Close, which waits for a running Walk to return, so record
+// views never outlive it. Mapping is only available on Linux; elsewhere, and
+// for the other Open variants, the option is ignored.
+func WithMmap() OpenOption {
+	return func(opts *openOptions) {
+		opts.mmap = true
+	}
+}
+
+// source is the input of an open file. Both reader backends read through
+// it, so files, fs.FS entries and in-memory buffers share one code path.
+// closer releases the underlying file and may be nil.
+//
+// Inputs opened with OpenStream have no io.ReaderAt. They set stream instead,
+// report a size of -1 and can only be read front to back through reader.
+type source struct {
+	io.ReaderAt
+	size   int64
+	closer io.Closer
+	stream *streamInput
+	// mapped holds the whole file when it was opened WithMmap. It stays
+	// valid until closer unmaps it.
+	mapped []byte
+}
+
+// mapping unmaps a file mapped by Open.
+type mapping []byte
+
+func (data mapping) Close() error { return unmapFile(data) }
+
+// streamInput is a forward-only input that tracks the file offset it has
+// reached, so the backends can skip to a known offset without seeking.
+type streamInput struct {
+	r      *bufio.Reader
+	offset int64
+}
+
+func (stream *streamInput) Read(p []byte) (int, error) {
+	n, err := stream.r.Read(p)
+	stream.offset += int64(n)
+	return n, err
+}
+
+// skipTo discards the input up to the file offset off.
+func (stream *streamInput) skipTo(off int64) error {
+	if off < stream.offset {
+		return ErrStreamConsumed
+	}
+	if _, err := io.CopyN(io.Discard, stream, off-stream.offset); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	return nil
+}
+
+// readPrefix reads the first len(p) bytes of the input. A stream is only
+// peeked, so the backends still read it from offset 0.
+func (src source) readPrefix(p []byte) error {
+	if src.stream == nil {
+		return src.readAt(p, 0)
+	}
+	prefix, err := src.stream.r.Peek(len(p))
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	copy(p, prefix)
+	return nil
+}
+
+// readAt reads exactly len(p) bytes at off. Unlike a bare ReadAt it accepts
+// the io.EOF that an io.ReaderAt may return together with a full read at the
+// end of the input.
+func (src source) readAt(p []byte, off int64) error {
+	n, err := src.ReadAt(p, off)
+	if n == len(p) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// section returns a sequential reader over the input starting at off.
+func (src source) section(off int64) *io.SectionReader {
+	return io.NewSectionReader(src.ReaderAt, off, src.size-off)
+}
+
+// reader returns a sequential reader starting at off. For a random-access
+// input this is a fresh section; a stream is advanced to off and fails with
+// ErrStreamConsumed once it has been read past off.
+func (src source) reader(off int64) (io.Reader, error) {
+	if src.stream == nil {
+		return src.section(off), nil
+	}
+	if err := src.stream.skipTo(off); err != nil {
+		return nil, err
+	}
+	return src.stream, nil
+}
+
+// skip advances r by n bytes, seeking when r supports it.
+func skip(r io.Reader, n int64) error {
+	if seeker, ok := r.(io.Seeker); ok {
+		_, err := seeker.Seek(n, io.SeekCurrent)
+		return err
+	}
+	_, err := io.CopyN(io.Discard, r, n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (src source) close() error {
+	if src.closer == nil {
+		return nil
+	}
+	return src.closer.Close()
+}
+
 // fileReader is the format-specific side of the reader. It deliberately
 // delivers FlowRecord values, so the public Walk API has no container-format
 // branch in its hot path.
 type fileReader interface {
-	walk(context.Context, context.CancelFunc, func(FlowRecord) error) error
+	walk(context.Context, context.CancelFunc, walkConfig, func(FlowRecord) error) error
 	close() error
 }
 
+// walkConfig carries the settings of a single walk.
+type walkConfig struct {
+	// window restricts a walk to a time range. Backends use it to skip whole
+	// blocks; records are filtered by the caller. It is nil for a full walk.
+	window *timeWindow
+	// workers and blocksInFlight configure the decode pipeline.
+	workers        int
+	blocksInFlight int
+	// salvage collects skipped blocks. It is nil unless corrupt blocks are
+	// to be skipped.
+	salvage *SalvageReport
+	// follow is set when the file is still being written. The backends then
+	// wait for new blocks instead of trusting the header.
+	follow *follower
+}
+
+// timeWindow is a half-open time range in milliseconds since the Unix epoch.
+type timeWindow struct {
+	from, to uint64
+}
+
+// overlaps reports whether a flow or block spanning first..last intersects
+// the window.
+func (window *timeWindow) overlaps(first, last uint64) bool {
+	return first < window.to && last >= window.from
+}
+
 // dataBlockReader is implemented only by the legacy V1/V2 backend. DataBlock
 // reflects that container's block structure and has no V3-container meaning.
 type dataBlockReader interface {
@@ -85,5 +314,7 @@ type dataBlockReader interface {
 // must not be coerced into FlowRecordV3 values.
 type legacyRecordReader interface {
 	dataBlockReader
+	// processDataBlock converts the records of a big-endian file in place,
+	// so it must only be given blocks owned by the caller.
 	processDataBlock(DataBlock, func([]byte) error) error
 }
@@ -0,0 +1,105 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package nfdump
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Recompress rewrites the flow file fileName with the codec and block size
+// set by WithCompression and WithBlockSize. The flows keep their order, and
+// the ident, the stat record, the exporters and samplers and the creation
+// time of the file are copied. The file keeps its layout, codec and block
+// size unless an option selects another one; V1 files, which cannot be
+// written, need WithLayout.
+// Recompress fails rather than drop any field the new layout cannot hold.
+//
+// The new file is written to a temporary file in the same directory, which
+// replaces fileName by an atomic rename once it is complete, keeping its
+// mode and modification time. If Recompress fails, fileName is unchanged.
+// Encrypted files cannot be recompressed.
+func Recompress(ctx context.Context, fileName string, options ...WriterOption) error {
+	src := New()
+	if err := src.Open(fileName); err != nil {
+		return err
+	}
+	defer src.Close()
+	fileInfo, err := os.Stat(fileName)
+	if err != nil {
+		return fmt.Errorf("nfFile recompress: %w", err)
+	}
+	info := src.Info()
+	defaults := []WriterOption{
+		WithLayout(info.Layout),
+		WithCompression(info.Compression),
+		func(opts *writerOptions) { opts.created = info.Created },
+	}
+	// Files not written by a writer of this package may record a block size
+	// it does not accept; they get the default unless WithBlockSize is set.
+	maxBlockSize := uint32(BUFFSIZE)
+	if info.Layout == FileLayoutV3 {
+		maxBlockSize = v18MaxBlockSize
+	}
+	if info.BlockSize >= minWriteBlockSize && info.BlockSize <= maxBlockSize {
+		defaults = append(defaults, WithBlockSize(info.BlockSize))
+	}
+	options = append(defaults, options...)
+
+	temp, err := os.CreateTemp(filepath.Dir(fileName), "."+filepath.Base(fileName)+".*")
+	if err != nil {
+		return fmt.Errorf("nfFile recompress: %w", err)
+	}
+	tempName := temp.Name()
+	if err := recompressTo(ctx, src, temp, options); err != nil {
+		temp.Close()
+		os.Remove(tempName)
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		os.Remove(tempName)
+		return fmt.Errorf("nfFile recompress: %w", err)
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(tempName)
+		return fmt.Errorf("nfFile recompress: %w", err)
+	}
+	src.Close()
+	if err := os.Chmod(tempName, fileInfo.Mode().Perm()); err != nil {
+		os.Remove(tempName)
+		return fmt.Errorf("nfFile recompress: %w", err)
+	}
+	if err := os.Chtimes(tempName, fileInfo.ModTime(), fileInfo.ModTime()); err != nil {
+		os.Remove(tempName)
+		return fmt.Errorf("nfFile recompress: %w", err)
+	}
+	if err := os.Rename(tempName, fileName); err != nil {
+		os.Remove(tempName)
+		return fmt.Errorf("nfFile recompress: %w", err)
+	}
+	return nil
+}
+
+// recompressTo copies src to a new file written to temp.
+func recompressTo(ctx context.Context, src *NfFile, temp *os.File, options []WriterOption) error {
+	dst, err := newWriter(temp, options)
+	if err != nil {
+		return err
+	}
+	if _, err := Transcode(ctx, src, dst, WithStrict()); err != nil {
+		dst.Close()
+		return err
+	}
+	// The stat record is copied as well, rather than recomputed.
+	if stat := src.Stat(); stat != (StatRecord{}) {
+		dst.stat = stat
+	}
+	return dst.Close()
+}
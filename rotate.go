@@ -0,0 +1,340 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package nfdump
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RotateOption configures NewRotatingWriter.
+type RotateOption func(*rotateOptions)
+
+type rotateOptions struct {
+	interval  time.Duration
+	subdirs   string
+	location  *time.Location
+	now       func() time.Time
+	writerOpt []WriterOption
+}
+
+// WithRotateInterval sets the time slot of a file. The default is five
+// minutes, the rotation interval of nfcapd. Slots are aligned to the Unix
+// epoch like nfcapd aligns them.
+func WithRotateInterval(interval time.Duration) RotateOption {
+	return func(opts *rotateOptions) {
+		opts.interval = interval
+	}
+}
+
+// WithRotateSubdirs stores the rotated files in the directory hierarchy
+// layout, as WithSubdirs does for Split.
+func WithRotateSubdirs(layout string) RotateOption {
+	return func(opts *rotateOptions) {
+		opts.subdirs = layout
+	}
+}
+
+// WithRotateLocation sets the time zone of the file names and directories.
+// The default is the local time zone, which nfcapd uses.
+func WithRotateLocation(location *time.Location) RotateOption {
+	return func(opts *rotateOptions) {
+		opts.location = location
+	}
+}
+
+// WithRotateClock sets the time source that decides when to rotate. The
+// default is time.Now; tests pass a clock they advance themselves.
+func WithRotateClock(now func() time.Time) RotateOption {
+	return func(opts *rotateOptions) {
+		opts.now = now
+	}
+}
+
+// WithRotateFileOptions sets the writer options of every file, such as its
+// layout, compression and ident.
+func WithRotateFileOptions(options ...WriterOption) RotateOption {
+	return func(opts *rotateOptions) {
+		opts.writerOpt = append(opts.writerOpt, options...)
+	}
+}
+
+// RotatingWriter writes flows into a directory the way nfcapd does. The
+// flows of the current time slot are written to nfcapd.current.<pid>, which
+// is renamed to nfcapd.YYYYMMDDhhmm, named after the start of its slot, in
+// the subdirectory of WithRotateSubdirs once the slot has ended. After each
+// rename the .nfstat directory statistics nfexpire uses are updated. The
+// exporters written are repeated at the start of every new file. Rotation
+// happens in Write and Tick, so a collector that may be idle for a whole
+// slot calls Tick periodically. A RotatingWriter is not safe for
+// concurrent use.
+type RotatingWriter struct {
+	dir     string
+	opts    rotateOptions
+	current string
+	writer  *NfWriter
+	format  RecordFormat
+	// start and end bound the time slot of the current file.
+	start, end time.Time
+	// exporters holds the exporters written, by SysID, without counters.
+	exporters map[uint16]Exporter
+	// err is the error that made a rotation fail. No file is open after it.
+	err    error
+	closed bool
+}
+
+// NewRotatingWriter starts writing flows into dir, which must exist, with a
+// new nfcapd.current.<pid> file for the current time slot.
+func NewRotatingWriter(dir string, options ...RotateOption) (*RotatingWriter, error) {
+	opts := rotateOptions{interval: 5 * time.Minute, location: time.Local, now: time.Now}
+	for _, option := range options {
+		if option != nil {
+			option(&opts)
+		}
+	}
+	if opts.interval < time.Second || opts.interval%time.Second != 0 {
+		return nil, fmt.Errorf("nfWriter rotate: invalid interval %v", opts.interval)
+	}
+	rotatingWriter := &RotatingWriter{
+		dir:       dir,
+		opts:      opts,
+		current:   filepath.Join(dir, fmt.Sprintf("nfcapd.current.%d", os.Getpid())),
+		exporters: make(map[uint16]Exporter),
+	}
+	if err := rotatingWriter.open(opts.now()); err != nil {
+		return nil, err
+	}
+	return rotatingWriter, nil
+}
+
+// open creates the current file for the time slot of now.
+func (rotatingWriter *RotatingWriter) open(now time.Time) error {
+	seconds := int64(rotatingWriter.opts.interval / time.Second)
+	start := now.Unix() - now.Unix()%seconds
+	options := append([]WriterOption{
+		func(opts *writerOptions) { opts.created = uint64(now.Unix()) },
+	}, rotatingWriter.opts.writerOpt...)
+	writer, err := Create(rotatingWriter.current, options...)
+	if err != nil {
+		return err
+	}
+	var exporters []Exporter
+	for _, sysID := range slices.Sorted(maps.Keys(rotatingWriter.exporters)) {
+		exporters = append(exporters, rotatingWriter.exporters[sysID])
+	}
+	if err := writer.WriteExporters(exporters...); err != nil {
+		writer.Close()
+		os.Remove(rotatingWriter.current)
+		return err
+	}
+	rotatingWriter.writer = writer
+	rotatingWriter.format = writer.RecordFormat()
+	rotatingWriter.start = time.Unix(start, 0).In(rotatingWriter.opts.location)
+	rotatingWriter.end = rotatingWriter.start.Add(rotatingWriter.opts.interval)
+	return nil
+}
+
+// Write rotates the file if its time slot has ended and appends record to
+// it, as NfWriter.Write does.
+func (rotatingWriter *RotatingWriter) Write(record FlowRecord) error {
+	if err := rotatingWriter.Tick(); err != nil {
+		return err
+	}
+	return rotatingWriter.writer.Write(record)
+}
+
+// WriteExporters writes exporters to the current file, as
+// NfWriter.WriteExporters does, and remembers them, so every following file
+// starts with them as well. Their counters are written to the current file
+// only.
+func (rotatingWriter *RotatingWriter) WriteExporters(exporters ...Exporter) error {
+	if err := rotatingWriter.Tick(); err != nil {
+		return err
+	}
+	if err := rotatingWriter.writer.WriteExporters(exporters...); err != nil {
+		return err
+	}
+	for _, exporter := range exporters {
+		if exporter.IP != nil {
+			exporter.Packets, exporter.Flows, exporter.SequenceFailures = 0, 0, 0
+			rotatingWriter.exporters[exporter.SysId] = exporter
+		}
+	}
+	return nil
+}
+
+// RecordFormat returns the record format of the files.
+func (rotatingWriter *RotatingWriter) RecordFormat() RecordFormat {
+	return rotatingWriter.format
+}
+
+// Tick rotates the file if the clock has passed the end of its time slot.
+// The next file starts with the slot of the current time, so a slot without
+// a Write or Tick gets no file.
+func (rotatingWriter *RotatingWriter) Tick() error {
+	if rotatingWriter.closed {
+		return fmt.Errorf("nfWriter rotate: writer closed")
+	}
+	if rotatingWriter.err != nil {
+		return rotatingWriter.err
+	}
+	now := rotatingWriter.opts.now()
+	if now.Before(rotatingWriter.end) {
+		return nil
+	}
+	if err := rotatingWriter.rotate(now); err != nil {
+		rotatingWriter.err = err
+		return err
+	}
+	if err := rotatingWriter.open(now); err != nil {
+		rotatingWriter.err = err
+		return err
+	}
+	return nil
+}
+
+// rotate completes the current file and renames it after its time slot.
+func (rotatingWriter *RotatingWriter) rotate(now time.Time) error {
+	writer := rotatingWriter.writer
+	rotatingWriter.writer = nil
+	if err := writer.Close(); err != nil {
+		os.Remove(rotatingWriter.current)
+		return err
+	}
+	path := slotPath(rotatingWriter.dir, rotatingWriter.opts.subdirs, rotatingWriter.opts.interval, rotatingWriter.start)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("nfWriter rotate: %w", err)
+	}
+	if err := os.Rename(rotatingWriter.current, path); err != nil {
+		return fmt.Errorf("nfWriter rotate: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("nfWriter rotate: %w", err)
+	}
+	stat, err := readDirStat(rotatingWriter.dir)
+	if err != nil {
+		return err
+	}
+	stat.add(uint64(rotatingWriter.start.Unix()), diskUsage(info))
+	return stat.write(rotatingWriter.dir, now)
+}
+
+// Close completes the current file and renames it after its time slot, as
+// nfcapd does when it shuts down.
+func (rotatingWriter *RotatingWriter) Close() error {
+	if rotatingWriter.closed {
+		return nil
+	}
+	rotatingWriter.closed = true
+	if rotatingWriter.err != nil {
+		return rotatingWriter.err
+	}
+	return rotatingWriter.rotate(rotatingWriter.opts.now())
+}
+
+// nfstatFileName is the directory statistics file of a flow directory.
+const nfstatFileName = ".nfstat"
+
+// dirStat holds the directory statistics of a .nfstat file. Times are in
+// seconds since the Unix epoch, sizes in bytes.
+type dirStat struct {
+	first     uint64
+	last      uint64
+	size      uint64
+	maxSize   uint64
+	numFiles  uint64
+	lifetime  uint64
+	watermark uint64
+	status    uint64
+}
+
+// readDirStat reads the .nfstat file of dir. A missing file yields empty
+// statistics with the default watermark of nfexpire.
+func readDirStat(dir string) (dirStat, error) {
+	stat := dirStat{watermark: 95}
+	file, err := os.Open(filepath.Join(dir, nfstatFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return stat, nil
+	}
+	if err != nil {
+		return stat, fmt.Errorf("nfWriter dirstat: %w", err)
+	}
+	defer file.Close()
+	fields := map[string]*uint64{
+		"first":     &stat.first,
+		"last":      &stat.last,
+		"size":      &stat.size,
+		"maxsize":   &stat.maxSize,
+		"numfiles":  &stat.numFiles,
+		"lifetime":  &stat.lifetime,
+		"watermark": &stat.watermark,
+		"status":    &stat.status,
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		field, known := fields[key]
+		if !ok || !known {
+			continue
+		}
+		if *field, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return stat, fmt.Errorf("nfWriter dirstat %s: %w", key, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return stat, fmt.Errorf("nfWriter dirstat: %w", err)
+	}
+	return stat, nil
+}
+
+// add accounts a file of the time slot starting at start.
+func (stat *dirStat) add(start, size uint64) {
+	if stat.first == 0 || start < stat.first {
+		stat.first = start
+	}
+	stat.last = max(stat.last, start)
+	stat.size += size
+	stat.numFiles++
+}
+
+// write replaces the .nfstat file of dir by an atomic rename.
+func (stat dirStat) write(dir string, now time.Time) error {
+	var s strings.Builder
+	fmt.Fprintf(&s, "# Last update: %s\n", now.Format(time.ANSIC))
+	fmt.Fprintf(&s, "first=%d\nlast=%d\nsize=%d\nmaxsize=%d\n", stat.first, stat.last, stat.size, stat.maxSize)
+	fmt.Fprintf(&s, "numfiles=%d\nlifetime=%d\nwatermark=%d\nstatus=%d\n", stat.numFiles, stat.lifetime, stat.watermark, stat.status)
+
+	temp, err := os.CreateTemp(dir, nfstatFileName+".*")
+	if err != nil {
+		return fmt.Errorf("nfWriter dirstat: %w", err)
+	}
+	_, err = temp.WriteString(s.String())
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(temp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), filepath.Join(dir, nfstatFileName))
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return fmt.Errorf("nfWriter dirstat: %w", err)
+	}
+	return nil
+}
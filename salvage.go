@@ -0,0 +1,104 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package nfdump
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// BlockError describes a block that a salvaging walk skipped.
+type BlockError struct {
+	// Block is the index of the block: its directory index for V3 files and
+	// its position among the data blocks for V1/V2 files.
+	Block int
+	// Offset is the file offset of the block header.
+	Offset int64
+	Err    error
+}
+
+func (err BlockError) Error() string {
+	return fmt.Sprintf("block %d at offset %d: %v", err.Block, err.Offset, err.Err)
+}
+
+func (err BlockError) Unwrap() error { return err.Err }
+
+// SalvageReport collects the blocks skipped by a walk with WithSalvage.
+type SalvageReport struct {
+	// Blocks counts the blocks the walk read, including the skipped ones.
+	Blocks  int
+	Skipped []BlockError
+	// Resyncs counts how often a V1/V2 walk searched for the next plausible
+	// block header after an invalid one.
+	Resyncs int
+}
+
+// Err returns nil if no block was skipped, and otherwise an error joining
+// the BlockError of every skipped block.
+func (report *SalvageReport) Err() error {
+	errs := make([]error, len(report.Skipped))
+	for i, err := range report.Skipped {
+		errs[i] = err
+	}
+	return errors.Join(errs...)
+}
+
+// String summarises the skipped blocks, one per line.
+func (report *SalvageReport) String() string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "%d blocks skipped, %d resyncs", len(report.Skipped), report.Resyncs)
+	for _, err := range report.Skipped {
+		s.WriteString("\n  ")
+		s.WriteString(err.Error())
+	}
+	return s.String()
+}
+
+// WithSalvage makes a walk tolerate corrupt blocks. A checksum mismatch, a
+// decompression failure or a malformed record skips the rest of its block,
+// and the walk continues with the next one. Records of a block that were
+// delivered before the failure are not withdrawn. V1/V2 walks resynchronise
+// on the next plausible block header after an invalid one; streams cannot
+// resynchronise and stop there. Every skipped block is appended to report.
+// Errors returned by the callback and context cancellation still end the
+// walk.
+func WithSalvage(report *SalvageReport) WalkOption {
+	return func(cfg *walkConfig) {
+		cfg.salvage = report
+	}
+}
+
+// blockRef locates a block for a BlockError.
+type blockRef struct {
+	index  int
+	offset int64
+}
+
+// callbackError marks an error returned by the Walk callback, which a
+// salvaging walk must not skip.
+type callbackError struct {
+	err error
+}
+
+func (err callbackError) Error() string { return err.err.Error() }
+
+func (err callbackError) Unwrap() error { return err.err }
+
+// salvageable reports whether err may be recorded and skipped by a
+// salvaging walk.
+func (cfg walkConfig) salvageable(err error) bool {
+	var callbackErr callbackError
+	return cfg.salvage != nil && !errors.As(err, &callbackErr) &&
+		!errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// skipBlock records a skipped block in the salvage report.
+func (cfg walkConfig) skipBlock(ref blockRef, err error) {
+	cfg.salvage.Skipped = append(cfg.salvage.Skipped, BlockError{Block: ref.index, Offset: ref.offset, Err: err})
+}
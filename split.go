@@ -0,0 +1,458 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package nfdump
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SubdirLayouts are the directory hierarchies nfcapd creates with -S,
+// indexed by its argument, for use with WithSubdirs. The verbs are those of
+// strftime.
+var SubdirLayouts = []string{
+	"",
+	"%Y/%m/%d",
+	"%Y/%m/%d/%H",
+	"%Y/%W/%u",
+	"%Y/%U/%w",
+	"%Y-%m-%d",
+	"%Y-%m-%d/%H",
+}
+
+// SplitOption configures Split.
+type SplitOption func(*splitOptions)
+
+type splitOptions struct {
+	interval  time.Duration
+	received  bool
+	subdirs   string
+	location  *time.Location
+	writerOpt []WriterOption
+}
+
+// WithInterval sets the time slot of an output file. The default is five
+// minutes, the rotation interval of nfcapd. Slots are aligned to the Unix
+// epoch like nfcapd aligns them.
+func WithInterval(interval time.Duration) SplitOption {
+	return func(opts *splitOptions) {
+		opts.interval = interval
+	}
+}
+
+// WithReceivedTime buckets records by the time the collector received them
+// instead of the start of the flow.
+func WithReceivedTime() SplitOption {
+	return func(opts *splitOptions) {
+		opts.received = true
+	}
+}
+
+// WithSubdirs stores the output files in the directory hierarchy layout,
+// a path of strftime verbs such as an entry of SubdirLayouts. The verbs
+// %Y, %m, %d, %H, %M, %j, %U, %W, %u, %w and %% are supported.
+func WithSubdirs(layout string) SplitOption {
+	return func(opts *splitOptions) {
+		opts.subdirs = layout
+	}
+}
+
+// WithLocation sets the time zone of the file names and directories. The
+// default is the local time zone, which nfcapd uses.
+func WithLocation(location *time.Location) SplitOption {
+	return func(opts *splitOptions) {
+		opts.location = location
+	}
+}
+
+// WithOutputOptions sets the writer options of the output files, such as
+// their layout and compression.
+func WithOutputOptions(options ...WriterOption) SplitOption {
+	return func(opts *splitOptions) {
+		opts.writerOpt = append(opts.writerOpt, options...)
+	}
+}
+
+// Split distributes the flows of srcs into files of one time slot each,
+// named nfcapd.YYYYMMDDhhmm after the start of their slot as nfcapd names
+// them, below dir. A single source is cut into slots; several sources, such
+// as files of a shorter interval, are merged into them. Records are put
+// into the slot of their GenericFlow.MsecFirst, or MsecReceived with
+// WithReceivedTime; records without times go to the slot of the record
+// before them. Every output file gets its own stat record computed from its
+// flows, the exporter and sampler records of the exporters of its flows,
+// and the ident of its first source unless WithOutputOptions sets one.
+// Records are converted to the record format of the output layout like
+// Transcode does with WithStrict.
+//
+// All output files are open until Split returns, each with a block buffer
+// of the block size. Split does not overwrite existing files. It returns
+// the names of the files written, sorted; if it fails, it removes them.
+func Split(ctx context.Context, dir string, srcs []*NfFile, options ...SplitOption) ([]string, error) {
+	opts := splitOptions{interval: 5 * time.Minute, location: time.Local}
+	for _, option := range options {
+		if option != nil {
+			option(&opts)
+		}
+	}
+	if opts.interval < time.Second || opts.interval%time.Second != 0 {
+		return nil, fmt.Errorf("nfFile split: invalid interval %v", opts.interval)
+	}
+	interval := uint64(opts.interval / time.Millisecond)
+
+	splitter := splitter{dir: dir, opts: opts, outputs: make(map[uint64]*splitOutput)}
+	for _, src := range srcs {
+		slot := src.Stat().FirstSeen - src.Stat().FirstSeen%interval
+		err := src.Walk(ctx, func(record FlowRecord) error {
+			if generic, ok := record.Generic(); ok {
+				msec := generic.MsecFirst
+				if opts.received {
+					msec = generic.MsecReceived
+				}
+				slot = msec - msec%interval
+			}
+			output, err := splitter.output(slot, src)
+			if err != nil {
+				return err
+			}
+			if err := output.sink.write(src, record); err != nil {
+				return fmt.Errorf("nfFile split into %s: %w", output.path, err)
+			}
+			return nil
+		})
+		if err != nil {
+			splitter.abort()
+			return nil, err
+		}
+	}
+	return splitter.close()
+}
+
+// splitter holds the output files of Split by the start of their slot.
+type splitter struct {
+	dir     string
+	opts    splitOptions
+	outputs map[uint64]*splitOutput
+}
+
+type splitOutput struct {
+	path   string
+	file   *os.File
+	writer *NfWriter
+	sink   *flowSink
+	ident  string
+}
+
+// output returns the output file of the slot starting at msec, creating it
+// on first use.
+func (splitter *splitter) output(msec uint64, src *NfFile) (*splitOutput, error) {
+	if output, ok := splitter.outputs[msec]; ok {
+		return output, nil
+	}
+	start := time.UnixMilli(int64(msec)).In(splitter.opts.location)
+	path := slotPath(splitter.dir, splitter.opts.subdirs, splitter.opts.interval, start)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("nfFile split: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("nfFile split: %w", err)
+	}
+	writer, err := newWriter(file, splitter.opts.writerOpt)
+	if err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	writer.closer = file
+	output := &splitOutput{path: path, file: file, writer: writer, sink: newFlowSink(writer), ident: src.Ident()}
+	splitter.outputs[msec] = output
+	return output, nil
+}
+
+// close completes all output files and returns their names.
+func (splitter *splitter) close() ([]string, error) {
+	var paths []string
+	var closeErr error
+	for _, output := range splitter.outputs {
+		writer := output.writer
+		if writer.ident == "" && len(output.ident) <= maxIdentLength {
+			writer.ident = output.ident
+		}
+		if err := writer.Close(); err != nil && closeErr == nil {
+			closeErr = fmt.Errorf("nfFile split into %s: %w", output.path, err)
+		}
+		paths = append(paths, output.path)
+	}
+	if closeErr != nil {
+		for _, path := range paths {
+			os.Remove(path)
+		}
+		return nil, closeErr
+	}
+	slices.Sort(paths)
+	return paths, nil
+}
+
+// abort closes and removes all output files.
+func (splitter *splitter) abort() {
+	for _, output := range splitter.outputs {
+		output.file.Close()
+		os.Remove(output.path)
+	}
+}
+
+// Merge writes the flows of srcs in the given order to dst. Records are
+// converted to the record format of dst like Transcode does with
+// WithStrict. The exporters of every source are written to dst before their
+// first flow; exporters of different sources that share an exporter ID but
+// differ in address, ID or version get a new ID in dst. Exporter IDs a source
+// has no exporter record for are kept and not given to other exporters; a
+// source using such an ID after it was given to another exporter fails the
+// merge. The stat record of
+// dst is computed from the flows, plus the sequence failures of the
+// sources. The ident of the sources is used if dst has none and all sources
+// agree on it. dst is not closed.
+func Merge(ctx context.Context, dst *NfWriter, srcs ...*NfFile) error {
+	if dst.closed {
+		return fmt.Errorf("nfFile merge: writer closed")
+	}
+	sink := newFlowSink(dst)
+	for i, src := range srcs {
+		err := src.Walk(ctx, func(record FlowRecord) error {
+			if err := sink.write(src, record); err != nil {
+				return fmt.Errorf("nfFile merge source %d: %w", i, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		dst.stat.SequenceFailure += src.Stat().SequenceFailure
+	}
+	if dst.ident == "" && len(srcs) > 0 {
+		ident := srcs[0].Ident()
+		for _, src := range srcs[1:] {
+			if src.Ident() != ident {
+				ident = ""
+			}
+		}
+		if len(ident) <= maxIdentLength {
+			dst.ident = ident
+		}
+	}
+	return nil
+}
+
+// flowSink writes the records of one or more source files to a writer. It
+// converts them to the record format of the writer and maps their exporter
+// IDs to the exporters written to it.
+type flowSink struct {
+	writer     Writer
+	exporters  exporterSet
+	maps       map[*NfFile]*exporterMap
+	transcoder transcoder
+	raw        []byte
+}
+
+func newFlowSink(writer Writer) *flowSink {
+	return &flowSink{
+		writer:     writer,
+		exporters:  exporterSet{dst: writer, sysIDs: make(map[exporterKey]uint16)},
+		maps:       make(map[*NfFile]*exporterMap),
+		transcoder: transcoder{format: writer.RecordFormat()},
+	}
+}
+
+func (sink *flowSink) write(src *NfFile, record FlowRecord) error {
+	converted, err := sink.transcoder.convert(record)
+	if err != nil {
+		return err
+	}
+	if len(sink.transcoder.lost) > 0 {
+		return fmt.Errorf("cannot represent %s", strings.Join(sink.transcoder.lost, ", "))
+	}
+	exporters, ok := sink.maps[src]
+	if !ok {
+		exporters = &exporterMap{set: &sink.exporters}
+		sink.maps[src] = exporters
+	}
+	exporterID := converted.ExporterID()
+	sysID, err := exporters.sysID(src, exporterID)
+	if err != nil {
+		return err
+	}
+	if sysID != exporterID {
+		sink.raw = append(sink.raw[:0], converted.raw...)
+		converted = FlowRecord{raw: sink.raw, format: converted.format}
+		if converted.format == RecordFormatV3 {
+			binary.LittleEndian.PutUint16(converted.raw[8:10], uint16(sysID))
+		} else {
+			binary.LittleEndian.PutUint32(converted.raw[8:12], sysID)
+		}
+	}
+	return sink.writer.Write(converted)
+}
+
+// exporterKey identifies an exporter across files, whose exporter IDs are
+// assigned by the collector that wrote them.
+type exporterKey struct {
+	ip      [16]byte
+	id      uint32
+	version uint16
+}
+
+// exporterSet is the set of exporters written to one output file.
+type exporterSet struct {
+	dst    Writer
+	sysIDs map[exporterKey]uint16
+	used   [MaxExporters]bool
+	// unknown marks the used IDs that are kept for flows of exporters
+	// without an exporter record.
+	unknown [MaxExporters]bool
+	// samplers holds the samplers written, by exporter ID.
+	samplers [MaxExporters][]Sampler
+}
+
+// add writes exporter unless an equal one with the same samplers was written
+// before, and returns the exporter ID of exporter in the output. The exporter
+// keeps its ID if it is still free. As an exporter record clears the samplers
+// readers know for its ID, an exporter with new samplers is written again
+// with all samplers written for it. The counters are not written.
+func (set *exporterSet) add(exporter Exporter) (uint16, error) {
+	var key exporterKey
+	copy(key.ip[:], exporter.IP.To16())
+	key.id, key.version = exporter.Id, exporter.Version
+	sysID, ok := set.sysIDs[key]
+	if !ok {
+		sysID = exporter.SysId
+		if set.used[sysID] {
+			free := slices.Index(set.used[1:], false)
+			if free < 0 {
+				return 0, fmt.Errorf("more than %d exporters", MaxExporters-1)
+			}
+			sysID = uint16(free + 1)
+		}
+		set.used[sysID] = true
+		set.sysIDs[key] = sysID
+	}
+	changed := !ok
+	for _, sampler := range exporter.SamplerList {
+		if !slices.Contains(set.samplers[sysID], sampler) {
+			set.samplers[sysID] = append(set.samplers[sysID], sampler)
+			changed = true
+		}
+	}
+	if !changed {
+		return sysID, nil
+	}
+	exporter.SysId, exporter.SamplerList = sysID, set.samplers[sysID]
+	exporter.Packets, exporter.Flows, exporter.SequenceFailures = 0, 0, 0
+	return sysID, set.dst.WriteExporters(exporter)
+}
+
+// reserve keeps the exporter ID id of an exporter without an exporter record,
+// so that add does not give it to another exporter.
+func (set *exporterSet) reserve(id uint32) error {
+	if id == 0 || id >= MaxExporters || set.unknown[id] {
+		return nil
+	}
+	if set.used[id] {
+		return fmt.Errorf("exporter ID %d has no exporter record and is used by another exporter", id)
+	}
+	set.used[id], set.unknown[id] = true, true
+	return nil
+}
+
+// exporterMap caches the output exporter IDs of the exporters of a source
+// file. The cache is dropped whenever the source reads exporter or sampler
+// records.
+type exporterMap struct {
+	set     *exporterSet
+	updates uint64
+	mapped  [MaxExporters]bool
+	sysIDs  [MaxExporters]uint16
+}
+
+// sysID returns the output exporter ID of the exporter id of src. IDs of
+// exporters src does not know are kept and reserved.
+func (exporters *exporterMap) sysID(src *NfFile, id uint32) (uint32, error) {
+	if exporters.updates != src.exporterUpdates {
+		exporters.updates = src.exporterUpdates
+		exporters.mapped = [MaxExporters]bool{}
+	}
+	if id >= uint32(len(src.ExporterList)) || src.ExporterList[id].IP == nil {
+		return id, exporters.set.reserve(id)
+	}
+	if !exporters.mapped[id] {
+		sysID, err := exporters.set.add(src.ExporterList[id])
+		if err != nil {
+			return 0, err
+		}
+		exporters.mapped[id], exporters.sysIDs[id] = true, sysID
+	}
+	return uint32(exporters.sysIDs[id]), nil
+}
+
+// slotPath returns the path nfcapd gives the file of the time slot starting
+// at start: nfcapd.YYYYMMDDhhmm, with seconds for intervals of seconds, in
+// the subdirectory layout of dir.
+func slotPath(dir, subdirs string, interval time.Duration, start time.Time) string {
+	name := "nfcapd." + start.Format("200601021504")
+	if interval%time.Minute != 0 {
+		name = "nfcapd." + start.Format("20060102150405")
+	}
+	return filepath.Join(dir, strftime(subdirs, start), name)
+}
+
+// strftime formats t with the strftime verbs of WithSubdirs.
+func strftime(layout string, t time.Time) string {
+	var s strings.Builder
+	for i := 0; i < len(layout); i++ {
+		if layout[i] != '%' || i+1 == len(layout) {
+			s.WriteByte(layout[i])
+			continue
+		}
+		i++
+		yday, wday := t.YearDay()-1, int(t.Weekday())
+		switch layout[i] {
+		case 'Y':
+			s.WriteString(strconv.Itoa(t.Year()))
+		case 'm':
+			fmt.Fprintf(&s, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&s, "%02d", t.Day())
+		case 'H':
+			fmt.Fprintf(&s, "%02d", t.Hour())
+		case 'M':
+			fmt.Fprintf(&s, "%02d", t.Minute())
+		case 'j':
+			fmt.Fprintf(&s, "%03d", yday+1)
+		case 'U': // week of the year, starting on Sunday
+			fmt.Fprintf(&s, "%02d", (yday+7-wday)/7)
+		case 'W': // week of the year, starting on Monday
+			fmt.Fprintf(&s, "%02d", (yday+7-(wday+6)%7)/7)
+		case 'u':
+			s.WriteString(strconv.Itoa((wday+6)%7 + 1))
+		case 'w':
+			s.WriteString(strconv.Itoa(wday))
+		default:
+			s.WriteByte('%')
+			if layout[i] != '%' {
+				s.WriteByte(layout[i])
+			}
+		}
+	}
+	return s.String()
+}
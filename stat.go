@@ -0,0 +1,42 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package nfdump
+
+// addFlow accounts record in the stat record the way nfdump does: every
+// record counts as one flow with its input packets and bytes, split by
+// protocol, and widens the FirstSeen/LastSeen window. Records without a
+// generic flow extension only count as a flow of another protocol.
+func (stat *StatRecord) addFlow(record FlowRecord) {
+	generic, _ := record.Generic()
+	stat.Numflows++
+	stat.Numpackets += generic.InPackets
+	stat.Numbytes += generic.InBytes
+	switch generic.Proto {
+	case 1, 58: // ICMP, ICMPv6
+		stat.NumflowsIcmp++
+		stat.NumpacketsIcmp += generic.InPackets
+		stat.NumbytesIcmp += generic.InBytes
+	case 6: // TCP
+		stat.NumflowsTcp++
+		stat.NumpacketsTcp += generic.InPackets
+		stat.NumbytesTcp += generic.InBytes
+	case 17: // UDP
+		stat.NumflowsUdp++
+		stat.NumpacketsUdp += generic.InPackets
+		stat.NumbytesUdp += generic.InBytes
+	default:
+		stat.NumflowsOther++
+		stat.NumpacketsOther += generic.InPackets
+		stat.NumbytesOther += generic.InBytes
+	}
+	if generic.MsecFirst != 0 && (stat.FirstSeen == 0 || generic.MsecFirst < stat.FirstSeen) {
+		stat.FirstSeen = generic.MsecFirst
+	}
+	if generic.MsecLast > stat.LastSeen {
+		stat.LastSeen = generic.MsecLast
+	}
+}
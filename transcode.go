@@ -0,0 +1,350 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package nfdump
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"maps"
+	"math/bits"
+	"slices"
+	"strings"
+)
+
+// TranscodeOption configures Transcode.
+type TranscodeOption func(*transcodeOptions)
+
+type transcodeOptions struct {
+	strict bool
+}
+
+// WithStrict makes Transcode fail at the first record that loses a field,
+// instead of counting the loss in the report.
+func WithStrict() TranscodeOption {
+	return func(opts *transcodeOptions) {
+		opts.strict = true
+	}
+}
+
+// TranscodeReport summarizes a Transcode run.
+type TranscodeReport struct {
+	// Records counts the flow records written. Skipped counts the records
+	// that do not fit into the target format at all, such as V3 records
+	// whose V4 form exceeds the maximum record size.
+	Records uint64
+	Skipped uint64
+	// Exporters and Samplers count the exporters and samplers copied.
+	Exporters int
+	Samplers  int
+	// Dropped counts, for every field the target format cannot represent,
+	// the records that lost it. Extensions are named by their V3 element
+	// ID or V4 bitmap position.
+	Dropped map[string]uint64
+}
+
+// Lossless reports whether every record was written with all its fields.
+func (report *TranscodeReport) Lossless() bool {
+	return report.Skipped == 0 && len(report.Dropped) == 0
+}
+
+func (report *TranscodeReport) String() string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "%d records, %d exporters, %d samplers", report.Records, report.Exporters, report.Samplers)
+	if report.Skipped > 0 {
+		fmt.Fprintf(&s, ", %d records skipped", report.Skipped)
+	}
+	for _, field := range slices.Sorted(maps.Keys(report.Dropped)) {
+		fmt.Fprintf(&s, "\n  %s dropped from %d records", field, report.Dropped[field])
+	}
+	return s.String()
+}
+
+// Transcode copies the flows of src to dst, converting them to the record
+// format of dst: V3 records of nfdump 1.7.x files to V4 records of nfdump
+// 1.8.x files and back. Every extension is mapped through its logical
+// ExtensionID; fields without an equivalent in the target format are
+// dropped and counted in the report. Records already in the target format
+// are copied unchanged.
+//
+// Exporters and samplers are written as soon as src has read them, so they
+// keep their position before the flows that follow them in src, and the
+// exporter counters are written at the end. If dst is an NfWriter, the
+// ident of src is used if dst has none, and the sequence failures of src
+// are added to the stat record of dst, which is otherwise computed from the
+// written flows. dst is not closed.
+func Transcode(ctx context.Context, src *NfFile, dst Writer, options ...TranscodeOption) (*TranscodeReport, error) {
+	var opts transcodeOptions
+	for _, option := range options {
+		if option != nil {
+			option(&opts)
+		}
+	}
+	report := &TranscodeReport{Dropped: make(map[string]uint64)}
+	transcoder := transcoder{format: dst.RecordFormat()}
+	copier := exporterCopier{src: src, dst: dst, report: report}
+	err := src.Walk(ctx, func(record FlowRecord) error {
+		if err := copier.sync(); err != nil {
+			return err
+		}
+		converted, err := transcoder.convert(record)
+		if err != nil {
+			if opts.strict {
+				return fmt.Errorf("nfFile transcode record %d: %w", report.Records+report.Skipped, err)
+			}
+			report.Skipped++
+			return nil
+		}
+		if len(transcoder.lost) > 0 {
+			if opts.strict {
+				return fmt.Errorf("nfFile transcode record %d: cannot represent %s", report.Records+report.Skipped, strings.Join(transcoder.lost, ", "))
+			}
+			for _, field := range transcoder.lost {
+				report.Dropped[field]++
+			}
+		}
+		if err := dst.Write(converted); err != nil {
+			return err
+		}
+		report.Records++
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	if err := copier.finish(); err != nil {
+		return report, err
+	}
+	writer, ok := dst.(*NfWriter)
+	if !ok {
+		return report, nil
+	}
+	if ident := src.Ident(); writer.ident == "" {
+		if len(ident) > maxIdentLength {
+			if opts.strict {
+				return report, fmt.Errorf("nfFile transcode: ident too long: %d bytes", len(ident))
+			}
+			report.Dropped["ident"]++
+		} else {
+			writer.ident = ident
+		}
+	}
+	writer.stat.SequenceFailure += src.Stat().SequenceFailure
+	return report, nil
+}
+
+// exporterCopier copies the exporters and samplers src reads to dst.
+type exporterCopier struct {
+	src    *NfFile
+	dst    Writer
+	report *TranscodeReport
+	// updates is the src.exporterUpdates value of the last sync.
+	updates uint64
+	// written holds, by SysID, the last exporter written and the number of
+	// its samplers written.
+	written [MaxExporters]struct {
+		exporter Exporter
+		samplers int
+	}
+}
+
+// sync writes the exporters and samplers src has read since the last call.
+// An exporter announced again with a new address or ID, or with new
+// samplers, is written again with all its samplers, as an exporter record
+// clears the samplers readers know for its SysID. The counters are left to
+// finish.
+func (copier *exporterCopier) sync() error {
+	if copier.src.exporterUpdates == copier.updates {
+		return nil
+	}
+	copier.updates = copier.src.exporterUpdates
+	var changed []Exporter
+	for sysID, exporter := range copier.src.ExporterList {
+		if exporter.IP == nil || sysID >= MaxExporters {
+			continue
+		}
+		written := &copier.written[sysID]
+		if !written.exporter.IP.Equal(exporter.IP) || written.exporter.Id != exporter.Id || written.exporter.Version != exporter.Version {
+			copier.report.Exporters++
+			written.exporter, written.samplers = exporter, 0
+		} else if written.samplers >= len(exporter.SamplerList) {
+			continue
+		}
+		copier.report.Samplers += len(exporter.SamplerList) - written.samplers
+		written.samplers = len(exporter.SamplerList)
+		exporter.Packets, exporter.Flows, exporter.SequenceFailures = 0, 0, 0
+		changed = append(changed, exporter)
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	return copier.dst.WriteExporters(changed...)
+}
+
+// finish writes the exporters and samplers read after the last flow and
+// the exporter counters, which src only knows completely at the end.
+func (copier *exporterCopier) finish() error {
+	if err := copier.sync(); err != nil {
+		return err
+	}
+	var counted []Exporter
+	for _, exporter := range copier.src.ExporterList {
+		if exporter.IP != nil && (exporter.Packets != 0 || exporter.Flows != 0 || exporter.SequenceFailures != 0) {
+			counted = append(counted, exporter)
+		}
+	}
+	if len(counted) == 0 {
+		return nil
+	}
+	return copier.dst.WriteExporters(counted...)
+}
+
+// transcoder converts records to format. Its buffers are reused, so a
+// converted record is valid until the next conversion.
+type transcoder struct {
+	format     RecordFormat
+	raw        []byte
+	extensions []recordExtension
+	// lost names the fields the last converted record lost.
+	lost []string
+}
+
+func (transcoder *transcoder) convert(record FlowRecord) (FlowRecord, error) {
+	transcoder.lost = transcoder.lost[:0]
+	switch {
+	case record.format == transcoder.format:
+		return record, nil
+	case record.format == RecordFormatV3 && transcoder.format == RecordFormatV4:
+		return transcoder.toV4(record)
+	case record.format == RecordFormatV4 && transcoder.format == RecordFormatV3:
+		return transcoder.toV3(record)
+	default:
+		return FlowRecord{}, fmt.Errorf("cannot convert record format %d to %d", record.format, transcoder.format)
+	}
+}
+
+// toV4 converts a V3 record. Each element is stored at its V4 bitmap
+// position; EXflowMisc is split into EXinterface and EXflowMisc.
+func (transcoder *transcoder) toV4(record FlowRecord) (FlowRecord, error) {
+	raw := record.raw
+	extensions := transcoder.extensions[:0]
+	var bitmap uint64
+	add := func(bit uint8, data []byte) bool {
+		if bitmap&(1<<bit) != 0 {
+			return false
+		}
+		bitmap |= 1 << bit
+		extensions = append(extensions, recordExtension{id: uint16(bit), data: data})
+		return true
+	}
+	numElements := int(binary.LittleEndian.Uint16(raw[4:6]))
+	offset := v3RecordHeaderSize
+	for range numElements {
+		id := binary.LittleEndian.Uint16(raw[offset : offset+2])
+		size := int(binary.LittleEndian.Uint16(raw[offset+2 : offset+4]))
+		data := raw[offset+4 : offset+size]
+		offset += size
+
+		var mapping v4Mapping
+		if int(id) < len(v4Mappings) {
+			mapping = v4Mappings[id]
+		}
+		added := false
+		switch {
+		case mapping.bit == 0 || len(data) < int(mapping.v3Size):
+		case id == EXflowMiscID:
+			added = add(v4ExInterface, data[0:8]) && add(v4ExFlowMisc, data[8:14])
+		case mapping.v3Size == 0:
+			added = add(mapping.bit, append(binary.LittleEndian.AppendUint32(nil, uint32(len(data))), data...))
+		default:
+			added = add(mapping.bit, data[:mapping.v3Size])
+		}
+		if !added {
+			transcoder.lost = append(transcoder.lost, fmt.Sprintf("V3 extension %d", id))
+		}
+	}
+	transcoder.extensions = extensions
+	sortExtensions(extensions)
+
+	engineType, engineID := record.Engine()
+	fields := recordFields{
+		exporterID: record.ExporterID(),
+		flags:      record.Flags(),
+		engineType: engineType,
+		engineID:   engineID,
+		nfVersion:  record.NetFlowVersion(),
+	}
+	var err error
+	if transcoder.raw, err = appendV4Record(transcoder.raw[:0], fields, extensions); err != nil {
+		return FlowRecord{}, err
+	}
+	return newFlowRecordV4(transcoder.raw)
+}
+
+// toV3 converts a V4 record. Each extension is stored under its logical ID;
+// EXinterface and EXflowMisc are merged into EXflowMisc.
+func (transcoder *transcoder) toV3(record FlowRecord) (FlowRecord, error) {
+	raw := record.raw
+	extensions := transcoder.extensions[:0]
+	var flowMisc []byte
+	bitmap := binary.LittleEndian.Uint64(raw[16:24])
+	for remaining, rank := bitmap, 0; remaining != 0; rank++ {
+		bit := uint(bits.TrailingZeros64(remaining))
+		remaining &= remaining - 1
+		offset := int(binary.LittleEndian.Uint16(raw[v4RecordHeaderSize+rank*2:]))
+		size, _ := v4ExtensionSize(bit, raw[offset:])
+		data := raw[offset : offset+size]
+
+		var id ExtensionID
+		if bit < uint(len(v3ExtensionIDs)) {
+			id = v3ExtensionIDs[bit]
+		}
+		switch {
+		case bit == v4ExInterface || bit == v4ExFlowMisc:
+			if flowMisc == nil {
+				flowMisc = make([]byte, v4Mappings[EXflowMiscID].v3Size)
+			}
+			if bit == v4ExInterface {
+				copy(flowMisc[0:8], data)
+			} else {
+				copy(flowMisc[8:14], data)
+			}
+		case id == 0:
+			transcoder.lost = append(transcoder.lost, fmt.Sprintf("V4 extension %d", bit))
+		case v4Mappings[id].v3Size == 0:
+			extensions = append(extensions, recordExtension{id: id, data: data[4:]})
+		default:
+			extensions = append(extensions, recordExtension{id: id, data: data[:v4Mappings[id].v3Size]})
+		}
+	}
+	if flowMisc != nil {
+		extensions = append(extensions, recordExtension{id: EXflowMiscID, data: flowMisc})
+	}
+	transcoder.extensions = extensions
+	sortExtensions(extensions)
+
+	engineType, engineID := record.Engine()
+	fields := recordFields{
+		exporterID: record.ExporterID(),
+		flags:      record.Flags(),
+		engineType: engineType,
+		engineID:   engineID,
+		nfVersion:  record.NetFlowVersion(),
+	}
+	if fields.exporterID > 0xffff {
+		transcoder.lost = append(transcoder.lost, "exporter ID")
+		fields.exporterID = 0
+	}
+	if fields.flags > 0xff {
+		transcoder.lost = append(transcoder.lost, "record flags")
+	}
+	var err error
+	if transcoder.raw, err = appendV3Record(transcoder.raw[:0], fields, extensions); err != nil {
+		return FlowRecord{}, err
+	}
+	return newFlowRecordV3(transcoder.raw)
+}
@@ -0,0 +1,211 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package nfdump
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// VerifyCheck names the kind of check that found a VerifyProblem.
+type VerifyCheck string
+
+const (
+	// VerifyOpen reports a file that could not be opened at all.
+	VerifyOpen VerifyCheck = "open"
+	// VerifyContainer covers the file header, footer, directory and appendix.
+	VerifyContainer VerifyCheck = "container"
+	// VerifyBlock covers block checksums, decompression and record validation.
+	VerifyBlock VerifyCheck = "block"
+	// VerifyStat compares the stat record with the flows in the file.
+	VerifyStat VerifyCheck = "stat"
+)
+
+// VerifyProblem is a single integrity problem found by Verify. Block and
+// Offset are -1 when the problem does not belong to a block.
+type VerifyProblem struct {
+	Check  VerifyCheck
+	Block  int
+	Offset int64
+	Err    error
+}
+
+func (problem VerifyProblem) String() string {
+	if problem.Block < 0 {
+		return fmt.Sprintf("%s: %v", problem.Check, problem.Err)
+	}
+	return fmt.Sprintf("%s: block %d at offset %d: %v", problem.Check, problem.Block, problem.Offset, problem.Err)
+}
+
+// VerifyReport is the result of Verify.
+type VerifyReport struct {
+	Layout FileLayout
+	// Blocks and Records count the blocks read and the valid flow records
+	// found in them.
+	Blocks  int
+	Records uint64
+	// Unchecksummed counts the V3 blocks written without a checksum, whose
+	// payload could only be checked by decoding it.
+	Unchecksummed int
+	// Stat is recomputed from the valid flow records.
+	Stat     StatRecord
+	Problems []VerifyProblem
+}
+
+// OK reports whether no problem was found.
+func (report *VerifyReport) OK() bool {
+	return len(report.Problems) == 0
+}
+
+func (report *VerifyReport) String() string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "layout V%d, %d blocks, %d records", report.Layout, report.Blocks, report.Records)
+	if report.Unchecksummed > 0 {
+		fmt.Fprintf(&s, ", %d blocks without checksum", report.Unchecksummed)
+	}
+	if report.OK() {
+		s.WriteString(": OK")
+		return s.String()
+	}
+	fmt.Fprintf(&s, ": %d problems", len(report.Problems))
+	for _, problem := range report.Problems {
+		s.WriteString("\n  ")
+		s.WriteString(problem.String())
+	}
+	return s.String()
+}
+
+func (report *VerifyReport) addProblem(check VerifyCheck, err error) {
+	report.Problems = append(report.Problems, VerifyProblem{Check: check, Block: -1, Offset: -1, Err: err})
+}
+
+// containerVerifier is implemented by backends that can check their
+// container structure beyond what Open validates.
+type containerVerifier interface {
+	verifyContainer(report *VerifyReport)
+}
+
+// Verify checks the open file completely and reports every problem found
+// instead of stopping at the first one: the container structure, every
+// block checksum, decompression and decoded size, every flow record, and
+// whether the stat record matches the flows in the file. The returned error
+// is reserved for failures of Verify itself, such as a canceled context;
+// integrity problems are only listed in the report.
+func (nfFile *NfFile) Verify(ctx context.Context) (*VerifyReport, error) {
+	if ctx == nil {
+		return nil, fmt.Errorf("nfFile verify: nil context")
+	}
+	// Both phases run under one lock, so no other read sees the file
+	// between the container check and the walk.
+	nfFile.readMu.Lock()
+	defer nfFile.readMu.Unlock()
+	if nfFile.reader == nil {
+		return nil, fmt.Errorf("nfFile verify: no open file")
+	}
+	report := &VerifyReport{Layout: nfFile.info.Layout}
+	if verifier, ok := nfFile.reader.(containerVerifier); ok {
+		verifier.verifyContainer(report)
+	}
+
+	var salvage SalvageReport
+	err := nfFile.walkLocked(ctx, walkConfig{salvage: &salvage}, func(record FlowRecord) error {
+		report.Records++
+		report.Stat.addFlow(record)
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	report.Blocks = salvage.Blocks
+	for _, skipped := range salvage.Skipped {
+		report.Problems = append(report.Problems, VerifyProblem{Check: VerifyBlock, Block: skipped.Block, Offset: skipped.Offset, Err: skipped.Err})
+	}
+	// Corrupt blocks make the recomputed stat incomplete.
+	if len(salvage.Skipped) == 0 && statReadable(nfFile.reader) {
+		for _, err := range compareStat(nfFile.StatRecord, report.Stat) {
+			report.addProblem(VerifyStat, err)
+		}
+	}
+	return report, nil
+}
+
+// VerifyFile opens fileName and verifies it. A file that cannot be opened is
+// reported as a VerifyOpen problem rather than as an error. Ident and stat
+// metadata that cannot be read do not fail the open; they are reported as
+// VerifyContainer problems and the blocks are still checked.
+func VerifyFile(ctx context.Context, fileName string, options ...OpenOption) (*VerifyReport, error) {
+	nfFile := New()
+	if err := nfFile.Open(fileName, append([]OpenOption{verifyOpen}, options...)...); err != nil {
+		report := &VerifyReport{}
+		report.addProblem(VerifyOpen, err)
+		return report, nil
+	}
+	defer nfFile.Close()
+	return nfFile.Verify(ctx)
+}
+
+// verifyOpen makes open keep the errors of ident and stat metadata for
+// verifyContainer instead of failing.
+func verifyOpen(opts *openOptions) {
+	opts.verify = true
+}
+
+// statReader is implemented by readers of files whose stat record may be
+// stored in a form the reader does not interpret.
+type statReader interface {
+	statReadable() bool
+}
+
+// statReadable reports whether the stat record of the file of reader could
+// be read, so that a missing one is a problem.
+func statReadable(reader fileReader) bool {
+	if reader, ok := reader.(statReader); ok {
+		return reader.statReadable()
+	}
+	return true
+}
+
+// compareStat lists the counters in which the stat record of a file
+// disagrees with the one computed from its flows. Time bounds are compared
+// only if the file records them.
+func compareStat(file, computed StatRecord) []error {
+	if file == (StatRecord{}) {
+		if computed.Numflows == 0 {
+			return nil
+		}
+		return []error{fmt.Errorf("file has no stat record for %d flows", computed.Numflows)}
+	}
+	var errs []error
+	check := func(name string, want, got uint64) {
+		if want != got {
+			errs = append(errs, fmt.Errorf("%s: stat record %d, flows %d", name, want, got))
+		}
+	}
+	check("flows", file.Numflows, computed.Numflows)
+	check("bytes", file.Numbytes, computed.Numbytes)
+	check("packets", file.Numpackets, computed.Numpackets)
+	check("tcp flows", file.NumflowsTcp, computed.NumflowsTcp)
+	check("udp flows", file.NumflowsUdp, computed.NumflowsUdp)
+	check("icmp flows", file.NumflowsIcmp, computed.NumflowsIcmp)
+	check("other flows", file.NumflowsOther, computed.NumflowsOther)
+	check("tcp bytes", file.NumbytesTcp, computed.NumbytesTcp)
+	check("udp bytes", file.NumbytesUdp, computed.NumbytesUdp)
+	check("icmp bytes", file.NumbytesIcmp, computed.NumbytesIcmp)
+	check("other bytes", file.NumbytesOther, computed.NumbytesOther)
+	check("tcp packets", file.NumpacketsTcp, computed.NumpacketsTcp)
+	check("udp packets", file.NumpacketsUdp, computed.NumpacketsUdp)
+	check("icmp packets", file.NumpacketsIcmp, computed.NumpacketsIcmp)
+	check("other packets", file.NumpacketsOther, computed.NumpacketsOther)
+	if file.FirstSeen != 0 {
+		check("first seen", file.FirstSeen, computed.FirstSeen)
+	}
+	if file.LastSeen != 0 {
+		check("last seen", file.LastSeen, computed.LastSeen)
+	}
+	return errs
+}
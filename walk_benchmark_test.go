@@ -8,7 +8,9 @@ package nfdump
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"runtime"
 	"testing"
 )
 
@@ -50,7 +52,23 @@ func benchmarkWalkContextChecks(b *testing.B, path string) {
 	}
 }
 
-func benchmarkWalkFile(b *testing.B, path string, accessFields bool, checkEvery uint32) {
+func BenchmarkWalkV2Workers(b *testing.B) {
+	benchmarkWalkWorkers(b, os.Getenv("NFDUMP_BENCH_V2"))
+}
+
+func BenchmarkWalkV3Workers(b *testing.B) {
+	benchmarkWalkWorkers(b, os.Getenv("NFDUMP_BENCH_V3"))
+}
+
+func benchmarkWalkWorkers(b *testing.B, path string) {
+	for _, workers := range []int{1, 2, 4, runtime.GOMAXPROCS(0)} {
+		b.Run(fmt.Sprintf("workers-%d", workers), func(b *testing.B) {
+			benchmarkWalkFile(b, path, false, 256, WithWorkers(workers))
+		})
+	}
+}
+
+func benchmarkWalkFile(b *testing.B, path string, accessFields bool, checkEvery uint32, options ...WalkOption) {
 	if path == "" {
 		b.Skip("set the matching NFDUMP_BENCH_V2 or NFDUMP_BENCH_V3 fixture path")
 	}
@@ -76,7 +94,7 @@ func benchmarkWalkFile(b *testing.B, path string, accessFields bool, checkEvery
 				_, _, _ = record.IP()
 			}
 			return nil
-		})
+		}, options...)
 		b.StopTimer()
 		if err != nil {
 			b.Fatal(err)
@@ -0,0 +1,349 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package nfdump
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// WriterOption configures Create and NewWriter.
+type WriterOption func(*writerOptions)
+
+type writerOptions struct {
+	layout      FileLayout
+	compression Compression
+	blockSize   uint32
+	ident       string
+	// created is the creation time recorded in the header, in seconds since
+	// the Unix epoch. Zero stands for the current time.
+	created uint64
+}
+
+func newWriterOptions(options []WriterOption) writerOptions {
+	opts := writerOptions{layout: FileLayoutV2, blockSize: v17WriteBlockSize}
+	for _, option := range options {
+		if option != nil {
+			option(&opts)
+		}
+	}
+	return opts
+}
+
+// creationTime returns the creation time of the file header.
+func (opts writerOptions) creationTime() uint64 {
+	if opts.created != 0 {
+		return opts.created
+	}
+	return uint64(time.Now().Unix())
+}
+
+// WithLayout selects the container layout of the file: FileLayoutV2, the
+// default, for nfdump 1.7.x or FileLayoutV3 for nfdump 1.8.x. V2 files hold
+// V3 records and V3 files hold V4 records.
+func WithLayout(layout FileLayout) WriterOption {
+	return func(opts *writerOptions) {
+		opts.layout = layout
+	}
+}
+
+// WithCompression sets the codec used for the blocks of the file. The
+// default is CompressionNone. A block the codec does not shrink is stored
+// uncompressed.
+func WithCompression(compression Compression) WriterOption {
+	return func(opts *writerOptions) {
+		opts.compression = compression
+	}
+}
+
+// WithBlockSize sets the maximum uncompressed size of a block in bytes.
+// The default is 1 MiB, the block size of nfcapd. V2 files allow 4 KiB to
+// 5 MiB for the records of a block, V3 files 4 KiB to 64 MiB for the whole
+// block including its headers.
+func WithBlockSize(size uint32) WriterOption {
+	return func(opts *writerOptions) {
+		opts.blockSize = size
+	}
+}
+
+// WithIdent sets the ident string stored in the file. It may be up to 1024
+// bytes long.
+func WithIdent(ident string) WriterOption {
+	return func(opts *writerOptions) {
+		opts.ident = ident
+	}
+}
+
+// fileWriter is the format-specific side of NfWriter.
+type fileWriter interface {
+	writeRecord(FlowRecord) error
+	// writeMetadata writes exporter, sampler or exporter stat records. V3
+	// files store them in blocks of typeID.
+	writeMetadata(typeID uint32, records [][]byte) error
+	setCompression(Compression) error
+	// close writes the buffered records and the metadata describing the
+	// whole file, or returns the error that made a previous write fail. It
+	// does not close the output.
+	close(ident string, stat StatRecord) error
+}
+
+// Writer is implemented by the flow file writers NfWriter and
+// RotatingWriter.
+type Writer interface {
+	Write(record FlowRecord) error
+	WriteExporters(exporters ...Exporter) error
+	RecordFormat() RecordFormat
+}
+
+// NfWriter writes a flow file. Records are buffered into blocks, which are
+// compressed and written once full; Close writes the last block, the ident
+// and the stat record and completes the file: the appendix and header of a
+// V2 file, or the metadata blocks, block directory, footer and header of a
+// V3 file. The stat record is computed from the written records. An NfWriter
+// is not safe for concurrent use.
+type NfWriter struct {
+	writer fileWriter
+	closer io.Closer
+	// format is the record format the file layout holds.
+	format RecordFormat
+	ident  string
+	stat   StatRecord
+	closed bool
+}
+
+// Create creates or truncates the flow file fileName and returns a writer
+// for it. Close closes the file.
+func Create(fileName string, options ...WriterOption) (*NfWriter, error) {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("nfWriter Create() on %s: %w", fileName, err)
+	}
+	nfWriter, err := newWriter(file, options)
+	if err != nil {
+		file.Close()
+		os.Remove(fileName)
+		return nil, err
+	}
+	nfWriter.closer = file
+	return nfWriter, nil
+}
+
+// NewWriter returns a writer that writes a flow file to w, starting at its
+// current offset. w must support seeking, because the file header is
+// completed by Close. The caller keeps ownership of w.
+func NewWriter(w io.WriteSeeker, options ...WriterOption) (*NfWriter, error) {
+	if w == nil {
+		return nil, fmt.Errorf("nfWriter NewWriter(): nil writer")
+	}
+	return newWriter(w, options)
+}
+
+func newWriter(w io.WriteSeeker, options []WriterOption) (*NfWriter, error) {
+	opts := newWriterOptions(options)
+	var writer fileWriter
+	var format RecordFormat
+	var err error
+	switch opts.layout {
+	case FileLayoutV2:
+		writer, err = newV17Writer(w, opts)
+		format = RecordFormatV3
+	case FileLayoutV3:
+		writer, err = newV18Writer(w, opts)
+		format = RecordFormatV4
+	default:
+		err = unsupportedError{operation: "write", layout: opts.layout}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &NfWriter{writer: writer, format: format, ident: opts.ident}, nil
+}
+
+// Write appends record to the file and accounts it in the stat record. The
+// record is copied, so records passed to a Walk callback can be written
+// directly. A record the file layout cannot hold is rejected; once writing
+// a block to the output has failed, every further Write fails as well.
+func (nfWriter *NfWriter) Write(record FlowRecord) error {
+	if nfWriter.closed {
+		return fmt.Errorf("nfWriter write: writer closed")
+	}
+	if err := nfWriter.writer.writeRecord(record); err != nil {
+		return err
+	}
+	nfWriter.stat.addFlow(record)
+	return nil
+}
+
+// RecordFormat returns the record format of the file: RecordFormatV3 for a
+// V2 file or RecordFormatV4 for a V3 file.
+func (nfWriter *NfWriter) RecordFormat() RecordFormat {
+	return nfWriter.format
+}
+
+// WriteExporters writes the exporter records of exporters, followed by the
+// records of their samplers and the counters of the exporters that have
+// any. Entries without an IP address, such as the unused slots of
+// GetExporterList, are skipped. A V2 file holds the records in its data
+// blocks, a V3 file in exporter, sampler and exporter stat blocks. Readers
+// learn an exporter when they reach its record, so exporters are best
+// written before the flows referencing them.
+func (nfWriter *NfWriter) WriteExporters(exporters ...Exporter) error {
+	if nfWriter.closed {
+		return fmt.Errorf("nfWriter write exporters: writer closed")
+	}
+	var infos, samplers [][]byte
+	var counted []Exporter
+	for _, exporter := range exporters {
+		if exporter.IP == nil {
+			continue
+		}
+		if int(exporter.SysId) >= MaxExporters {
+			return fmt.Errorf("nfWriter exporter SysID %d out of range", exporter.SysId)
+		}
+		infos = append(infos, encodeExporterInfo(exporter))
+		for _, sampler := range exporter.SamplerList {
+			samplers = append(samplers, encodeSampler(exporter.SysId, sampler))
+		}
+		if exporter.Packets != 0 || exporter.Flows != 0 || exporter.SequenceFailures != 0 {
+			counted = append(counted, exporter)
+		}
+	}
+	return nfWriter.writeExporterRecords(infos, samplers, encodeExporterStats(counted))
+}
+
+// writeExporterRecords writes encoded exporter, sampler and exporter stat
+// records in this order.
+func (nfWriter *NfWriter) writeExporterRecords(infos, samplers, stats [][]byte) error {
+	for _, metadata := range []struct {
+		typeID  uint32
+		records [][]byte
+	}{
+		{v18BlockExporter, infos},
+		{v18BlockSampler, samplers},
+		{v18BlockExporterStat, stats},
+	} {
+		if len(metadata.records) == 0 {
+			continue
+		}
+		if err := nfWriter.writer.writeMetadata(metadata.typeID, metadata.records); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetCompression changes the codec of the blocks written from now on,
+// including the block currently being filled. V3 files record the codec of
+// every block. V2 files have a single codec, so the codec of a V2 file can
+// only be switched between the one it was created with and
+// CompressionNone.
+func (nfWriter *NfWriter) SetCompression(compression Compression) error {
+	if nfWriter.closed {
+		return fmt.Errorf("nfWriter set compression: writer closed")
+	}
+	return nfWriter.writer.setCompression(compression)
+}
+
+// Stat returns the stat record of the records written so far.
+func (nfWriter *NfWriter) Stat() StatRecord {
+	return nfWriter.stat
+}
+
+// Close completes the file and closes it if it was opened by Create. The
+// file is incomplete if Close returns an error.
+func (nfWriter *NfWriter) Close() error {
+	if nfWriter.closed {
+		return nil
+	}
+	nfWriter.closed = true
+	err := nfWriter.writer.close(nfWriter.ident, nfWriter.stat)
+	if nfWriter.closer != nil {
+		if closeErr := nfWriter.closer.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("nfWriter close: %w", closeErr)
+		}
+	}
+	return err
+}
+
+// writerOutput tracks the output of a file writer. Offsets are relative to
+// the position of w when the writer was created, which is the start of the
+// file.
+type writerOutput struct {
+	w      io.WriteSeeker
+	base   int64
+	offset int64
+	// err is the first error writing to w. The file cannot be completed
+	// after it.
+	err error
+}
+
+func newWriterOutput(w io.WriteSeeker) (writerOutput, error) {
+	base, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return writerOutput{}, fmt.Errorf("nfWriter seek: %w", err)
+	}
+	return writerOutput{w: w, base: base}, nil
+}
+
+// write appends data to the file.
+func (output *writerOutput) write(data []byte) error {
+	if output.err != nil {
+		return output.err
+	}
+	if _, err := output.w.Write(data); err != nil {
+		output.err = fmt.Errorf("nfWriter write: %w", err)
+		return output.err
+	}
+	output.offset += int64(len(data))
+	return nil
+}
+
+// writeStart writes header at the start of the file and seeks back to the
+// end of the data written so far.
+func (output *writerOutput) writeStart(header []byte) error {
+	if output.err != nil {
+		return output.err
+	}
+	if _, err := output.w.Seek(output.base, io.SeekStart); err != nil {
+		output.err = fmt.Errorf("nfWriter seek: %w", err)
+		return output.err
+	}
+	if _, err := output.w.Write(header); err != nil {
+		output.err = fmt.Errorf("nfWriter write header: %w", err)
+		return output.err
+	}
+	end := max(output.offset, int64(len(header)))
+	if _, err := output.w.Seek(output.base+end, io.SeekStart); err != nil {
+		output.err = fmt.Errorf("nfWriter seek: %w", err)
+		return output.err
+	}
+	output.offset = end
+	return nil
+}
+
+// identRecord encodes an ident metadata record. The ident is NUL terminated
+// and padded to a multiple of four bytes like nfdump does.
+func identRecord(ident string) []byte {
+	size := (len(ident) + 1 + 3) &^ 3
+	record := make([]byte, 4+size)
+	binary.LittleEndian.PutUint16(record[0:2], TYPE_IDENT)
+	binary.LittleEndian.PutUint16(record[2:4], uint16(len(record)))
+	copy(record[4:], ident)
+	return record
+}
+
+// statRecord encodes a stat metadata record.
+func statRecord(stat StatRecord) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, recordHeader{Type: TYPE_STAT, Size: uint16(4 + binary.Size(stat))})
+	binary.Write(&buf, binary.LittleEndian, &stat)
+	return buf.Bytes()
+}
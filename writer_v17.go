@@ -0,0 +1,199 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package nfdump
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	// v17WriteBlockSize is the block size nfcapd 1.7 writes.
+	v17WriteBlockSize = 1 << 20
+	// v17WriterVersion is the nfdump version recorded in written V2 files.
+	v17WriterVersion = 0x01070400
+	// dataBlockType3 marks a V2 block of V3 records.
+	dataBlockType3 = 3
+	// maxIdentLength bounds the ident record, so that the ident and stat
+	// records always fit into a block of minWriteBlockSize.
+	maxIdentLength    = 1024
+	minWriteBlockSize = 4096
+)
+
+// v17Compression maps a codec to its V2 header value.
+var v17Compression = map[Compression]uint8{
+	CompressionNone:  NOT_COMPRESSED,
+	CompressionLZO:   LZO_COMPRESSED,
+	CompressionBzip2: BZ2_COMPRESSED,
+	CompressionLZ4:   LZ4_COMPRESSED,
+	CompressionZSTD:  ZSTD_COMPRESSED,
+}
+
+// v17Writer writes a V2 container the way nfcapd 1.7 does: the header is
+// written first with no blocks, followed by type 3 data blocks, and on close
+// by a single appendix block with the ident and stat records, after which
+// the header is rewritten with the block counts.
+type v17Writer struct {
+	writerOutput
+	header NfFileHeader
+	// compression is the codec of the next block: the codec of the file or
+	// CompressionNone.
+	compression Compression
+	compressor  blockCompressor
+	block       []byte
+	numRecords  uint32
+}
+
+func newV17Writer(w io.WriteSeeker, opts writerOptions) (*v17Writer, error) {
+	compression, ok := v17Compression[opts.compression]
+	if !ok {
+		return nil, fmt.Errorf("nfWriter unknown compression: %d", opts.compression)
+	}
+	if opts.blockSize < minWriteBlockSize || opts.blockSize > BUFFSIZE {
+		return nil, fmt.Errorf("nfWriter invalid block size: %d", opts.blockSize)
+	}
+	if len(opts.ident) > maxIdentLength {
+		return nil, fmt.Errorf("nfWriter ident too long: %d bytes", len(opts.ident))
+	}
+	output, err := newWriterOutput(w)
+	if err != nil {
+		return nil, err
+	}
+	writer := &v17Writer{
+		writerOutput: output,
+		compression:  opts.compression,
+		block:        make([]byte, 0, opts.blockSize),
+		header: NfFileHeader{
+			Magic:       nfFileMagic,
+			Version:     2,
+			NfVersion:   v17WriterVersion,
+			Created:     opts.creationTime(),
+			Compression: compression,
+			BlockSize:   opts.blockSize,
+		},
+	}
+	if err := writer.writeHeader(); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (writer *v17Writer) setCompression(compression Compression) error {
+	if compression != CompressionNone && compression != Compression(writer.header.Compression) {
+		return fmt.Errorf("nfWriter V2 file compressed with codec %d cannot switch to %d", writer.header.Compression, compression)
+	}
+	writer.compression = compression
+	return nil
+}
+
+func (writer *v17Writer) writeRecord(record FlowRecord) error {
+	if writer.err != nil {
+		return writer.err
+	}
+	if record.format != RecordFormatV3 {
+		return fmt.Errorf("nfWriter V2 files hold V3 records only, got format %d", record.format)
+	}
+	if err := validateV3Record(record.raw); err != nil {
+		return fmt.Errorf("nfWriter invalid record: %w", err)
+	}
+	if len(record.raw) > cap(writer.block) {
+		return fmt.Errorf("nfWriter record size %d exceeds block size %d", len(record.raw), cap(writer.block))
+	}
+	if len(writer.block)+len(record.raw) > cap(writer.block) {
+		if err := writer.flush(); err != nil {
+			return err
+		}
+	}
+	writer.block = append(writer.block, record.raw...)
+	writer.numRecords++
+	return nil
+}
+
+// writeMetadata adds records to the data block, where nfcapd 1.7 writes
+// exporter and sampler records as well.
+func (writer *v17Writer) writeMetadata(_ uint32, records [][]byte) error {
+	if writer.err != nil {
+		return writer.err
+	}
+	for _, record := range records {
+		if len(writer.block)+len(record) > cap(writer.block) {
+			if err := writer.flush(); err != nil {
+				return err
+			}
+		}
+		writer.block = append(writer.block, record...)
+		writer.numRecords++
+	}
+	return nil
+}
+
+// flush writes the buffered records as a data block.
+func (writer *v17Writer) flush() error {
+	if writer.numRecords == 0 {
+		return nil
+	}
+	if err := writer.writeBlock(writer.block, writer.numRecords); err != nil {
+		return err
+	}
+	writer.header.NumBlocks++
+	writer.block = writer.block[:0]
+	writer.numRecords = 0
+	return nil
+}
+
+// writeBlock compresses data and writes it as a type 3 block.
+func (writer *v17Writer) writeBlock(data []byte, numRecords uint32) error {
+	payload, compressed, err := writer.compressor.compress(writer.compression, data)
+	if err != nil {
+		writer.err = fmt.Errorf("nfWriter compress block: %w", err)
+		return writer.err
+	}
+	blockHeader := DataBlockHeader{NumRecords: numRecords, Size: uint32(len(payload)), Type: dataBlockType3}
+	if !compressed && writer.header.Compression != NOT_COMPRESSED {
+		blockHeader.Flags = flagBlockUncompressed
+	}
+	var buf bytes.Buffer
+	buf.Grow(binary.Size(blockHeader) + len(payload))
+	binary.Write(&buf, binary.LittleEndian, &blockHeader)
+	buf.Write(payload)
+	return writer.write(buf.Bytes())
+}
+
+// writeHeader writes the file header at the start of the file.
+func (writer *v17Writer) writeHeader() error {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &writer.header)
+	return writer.writeStart(buf.Bytes())
+}
+
+func (writer *v17Writer) close(ident string, stat StatRecord) error {
+	defer writer.compressor.close()
+	if writer.err != nil {
+		return writer.err
+	}
+	if err := writer.flush(); err != nil {
+		return err
+	}
+	appendix, numRecords := appendixRecords(ident, stat)
+	writer.header.OffAppendix = uint64(writer.offset)
+	if err := writer.writeBlock(appendix, numRecords); err != nil {
+		return err
+	}
+	writer.header.AppendixBlocks = 1
+	return writer.writeHeader()
+}
+
+// appendixRecords encodes the ident and stat records of a V2 appendix. An
+// empty ident is left out.
+func appendixRecords(ident string, stat StatRecord) ([]byte, uint32) {
+	if ident == "" {
+		return statRecord(stat), 1
+	}
+	return append(identRecord(ident), statRecord(stat)...), 2
+}
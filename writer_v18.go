@@ -0,0 +1,265 @@
+// Copyright © 2026 Peter Haag peter@people.ops-trust.net
+// All rights reserved.
+//
+// Use of this source code is governed by the license that can be
+// found in the LICENSE file.
+
+package nfdump
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// v18WriterVersion is the nfdump version recorded in written V3 files.
+const v18WriterVersion = 0x01080000
+
+// v18CompressionID returns the V3 block compression value of a codec, the
+// inverse of v18Compression.
+func v18CompressionID(compression Compression) (uint16, bool) {
+	switch compression {
+	case CompressionNone:
+		return 1, true
+	case CompressionLZO:
+		return 2, true
+	case CompressionBzip2:
+		return 3, true
+	case CompressionLZ4:
+		return 4, true
+	case CompressionZSTD:
+		return 5, true
+	default:
+		return 0, false
+	}
+}
+
+// v18Writer writes a V3 container the way nfcapd 1.8 does: the header is
+// written first without a directory, followed by flow blocks and, on close,
+// by the ident and stat blocks, the block directory and the footer, after
+// which the header is rewritten with the directory location. Every block
+// records its own compression and the checksum of its on-disk payload.
+type v18Writer struct {
+	writerOutput
+	header      v18Header
+	compression Compression
+	compressor  blockCompressor
+	// block is the flow block being filled, including its header and head.
+	// Its capacity is the block size.
+	block      []byte
+	numRecords uint32
+	// first and last bound the flow times of the block. first is
+	// math.MaxUint64 while no record with times has been added.
+	first, last uint64
+	entries     []v18DirectoryEntry
+}
+
+func newV18Writer(w io.WriteSeeker, opts writerOptions) (*v18Writer, error) {
+	compression, ok := v18CompressionID(opts.compression)
+	if !ok {
+		return nil, fmt.Errorf("nfWriter unknown compression: %d", opts.compression)
+	}
+	if opts.blockSize < minWriteBlockSize || opts.blockSize > v18MaxBlockSize {
+		return nil, fmt.Errorf("nfWriter invalid V3 block size: %d", opts.blockSize)
+	}
+	if len(opts.ident) > maxIdentLength {
+		return nil, fmt.Errorf("nfWriter ident too long: %d bytes", len(opts.ident))
+	}
+	output, err := newWriterOutput(w)
+	if err != nil {
+		return nil, err
+	}
+	writer := &v18Writer{
+		writerOutput: output,
+		compression:  opts.compression,
+		block:        make([]byte, v18FlowBlockHead, opts.blockSize),
+		first:        math.MaxUint64,
+		header: v18Header{
+			nfdVersion:  v18WriterVersion,
+			created:     opts.creationTime(),
+			compression: compression,
+			flags:       v18FlagPackageLayout,
+			blockSize:   opts.blockSize,
+		},
+	}
+	if err := writer.writeHeader(); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (writer *v18Writer) setCompression(compression Compression) error {
+	if _, ok := v18CompressionID(compression); !ok {
+		return fmt.Errorf("nfWriter unknown compression: %d", compression)
+	}
+	writer.compression = compression
+	return nil
+}
+
+func (writer *v18Writer) writeRecord(record FlowRecord) error {
+	if writer.err != nil {
+		return writer.err
+	}
+	if record.format != RecordFormatV4 {
+		return fmt.Errorf("nfWriter V3 files hold V4 records only, got format %d", record.format)
+	}
+	if err := validateV4Record(record.raw); err != nil {
+		return fmt.Errorf("nfWriter invalid record: %w", err)
+	}
+	if len(record.raw) > cap(writer.block)-v18FlowBlockHead {
+		return fmt.Errorf("nfWriter record size %d exceeds block size %d", len(record.raw), cap(writer.block))
+	}
+	if len(writer.block)+len(record.raw) > cap(writer.block) {
+		if err := writer.flush(); err != nil {
+			return err
+		}
+	}
+	writer.block = append(writer.block, record.raw...)
+	writer.numRecords++
+	if generic, ok := record.Generic(); ok {
+		writer.first = min(writer.first, generic.MsecFirst)
+		writer.last = max(writer.last, generic.MsecLast)
+	}
+	return nil
+}
+
+// flush writes the buffered records as a flow block. Its head records the
+// number of records and the flow time bounds used by WalkRange.
+func (writer *v18Writer) flush() error {
+	if writer.numRecords == 0 {
+		return nil
+	}
+	head := writer.block[v18BlockHeader:v18FlowBlockHead]
+	clear(head)
+	binary.LittleEndian.PutUint32(head[0:4], writer.numRecords)
+	if writer.first != math.MaxUint64 {
+		binary.LittleEndian.PutUint64(writer.block[v18FlowBlockFirst:], writer.first)
+		binary.LittleEndian.PutUint64(writer.block[v18FlowBlockLast:], writer.last)
+	}
+	if err := writer.writeBlock(v18BlockFlow, writer.block); err != nil {
+		return err
+	}
+	writer.block = writer.block[:v18FlowBlockHead]
+	writer.numRecords = 0
+	writer.first, writer.last = math.MaxUint64, 0
+	return nil
+}
+
+// writeMetadata writes records as metadata blocks of typeID, starting a new
+// block whenever the block size is reached.
+func (writer *v18Writer) writeMetadata(typeID uint32, records [][]byte) error {
+	if writer.err != nil {
+		return writer.err
+	}
+	var data []byte
+	var numRecords uint32
+	for _, record := range records {
+		if numRecords > 0 && v18MetaBlockHead+len(data)+len(record) > cap(writer.block) {
+			if err := writer.writeMetadataBlock(typeID, data, numRecords); err != nil {
+				return err
+			}
+			data, numRecords = data[:0], 0
+		}
+		data = append(data, record...)
+		numRecords++
+	}
+	if numRecords == 0 {
+		return nil
+	}
+	return writer.writeMetadataBlock(typeID, data, numRecords)
+}
+
+// writeMetadataBlock writes records as a metadata block of typeID.
+func (writer *v18Writer) writeMetadataBlock(typeID uint32, records []byte, numRecords uint32) error {
+	block := make([]byte, v18MetaBlockHead, v18MetaBlockHead+len(records))
+	binary.LittleEndian.PutUint32(block[v18BlockHeader:], numRecords)
+	return writer.writeBlock(typeID, append(block, records...))
+}
+
+// writeBlock fills in the block header of the raw block, compresses the
+// data following it and appends the block to the directory.
+func (writer *v18Writer) writeBlock(typeID uint32, block []byte) error {
+	payload, compressed, err := writer.compressor.compress(writer.compression, block[v18BlockHeader:])
+	if err != nil {
+		writer.err = fmt.Errorf("nfWriter compress block: %w", err)
+		return writer.err
+	}
+	compression := uint16(1) // NOT_COMPRESSED
+	if compressed {
+		compression, _ = v18CompressionID(writer.compression)
+	}
+	onDisk := make([]byte, v18BlockHeader+len(payload))
+	binary.LittleEndian.PutUint32(onDisk[0:4], typeID)
+	binary.LittleEndian.PutUint32(onDisk[4:8], uint32(len(onDisk)))
+	binary.LittleEndian.PutUint32(onDisk[8:12], uint32(len(block)))
+	binary.LittleEndian.PutUint16(onDisk[12:14], compression)
+	binary.LittleEndian.PutUint16(onDisk[14:16], v18EncryptionNone)
+	copy(onDisk[v18BlockHeader:], payload)
+	binary.LittleEndian.PutUint64(onDisk[16:24], v3Checksum64(onDisk[v18BlockHeader:]))
+
+	entry := v18DirectoryEntry{typeID: typeID, size: uint32(len(onDisk)), offset: uint64(writer.offset)}
+	if err := writer.write(onDisk); err != nil {
+		return err
+	}
+	writer.entries = append(writer.entries, entry)
+	return nil
+}
+
+// writeHeader writes the file header at the start of the file.
+func (writer *v18Writer) writeHeader() error {
+	header := make([]byte, v18HeaderSize)
+	binary.LittleEndian.PutUint16(header[0:2], nfFileMagic)
+	binary.LittleEndian.PutUint16(header[2:4], 3)
+	binary.LittleEndian.PutUint32(header[4:8], writer.header.nfdVersion)
+	binary.LittleEndian.PutUint64(header[8:16], writer.header.created)
+	binary.LittleEndian.PutUint16(header[18:20], writer.header.compression)
+	binary.LittleEndian.PutUint32(header[20:24], writer.header.flags)
+	binary.LittleEndian.PutUint32(header[24:28], writer.header.blockSize)
+	binary.LittleEndian.PutUint32(header[28:32], writer.header.dirSize)
+	binary.LittleEndian.PutUint64(header[32:40], writer.header.dirOffset)
+	binary.LittleEndian.PutUint64(header[40:48], writer.header.keyCheck)
+	return writer.writeStart(header)
+}
+
+func (writer *v18Writer) close(ident string, stat StatRecord) error {
+	defer writer.compressor.close()
+	if writer.err != nil {
+		return writer.err
+	}
+	if err := writer.flush(); err != nil {
+		return err
+	}
+	if ident != "" {
+		if err := writer.writeMetadataBlock(v18BlockIdent, identRecord(ident), 1); err != nil {
+			return err
+		}
+	}
+	if err := writer.writeMetadataBlock(v18BlockStat, statRecord(stat), 1); err != nil {
+		return err
+	}
+
+	directory := make([]byte, v18DirectoryHead+len(writer.entries)*v18DirectoryEnt)
+	binary.LittleEndian.PutUint32(directory[0:4], v18DirectoryMagic)
+	binary.LittleEndian.PutUint32(directory[4:8], uint32(len(writer.entries)))
+	for i, entry := range writer.entries {
+		raw := directory[v18DirectoryHead+i*v18DirectoryEnt:]
+		binary.LittleEndian.PutUint32(raw[0:4], entry.typeID)
+		binary.LittleEndian.PutUint32(raw[4:8], entry.size)
+		binary.LittleEndian.PutUint64(raw[8:16], entry.offset)
+	}
+	writer.header.dirSize = uint32(len(directory))
+	writer.header.dirOffset = uint64(writer.offset)
+	footer := make([]byte, v18FooterSize)
+	binary.LittleEndian.PutUint32(footer[0:4], v18FooterMagic)
+	binary.LittleEndian.PutUint32(footer[4:8], writer.header.dirSize)
+	binary.LittleEndian.PutUint64(footer[8:16], writer.header.dirOffset)
+	binary.LittleEndian.PutUint64(footer[16:24], v3Checksum64(directory))
+	if err := writer.write(directory); err != nil {
+		return err
+	}
+	if err := writer.write(footer); err != nil {
+		return err
+	}
+	return writer.writeHeader()
+}